@@ -0,0 +1,76 @@
+package access
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/filewatch"
+	"github.com/rs/zerolog"
+)
+
+// Engine holds the active rule set and reloads it when the file changes on disk.
+type Engine struct {
+	path     string
+	interval time.Duration
+	log      zerolog.Logger
+
+	rules atomic.Pointer[RuleSet]
+}
+
+// New loads the rules file. Use Start to enable hot reload.
+func New(path string, interval time.Duration, logger zerolog.Logger) (*Engine, error) {
+	e := &Engine{
+		path:     path,
+		interval: interval,
+		log:      logger,
+	}
+
+	if err := e.reload(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Rules returns the active rule set.
+func (e *Engine) Rules() *RuleSet {
+	return e.rules.Load()
+}
+
+// Evaluate applies the active rule set to the subject.
+func (e *Engine) Evaluate(sub Subject) Decision {
+	return e.rules.Load().Evaluate(sub)
+}
+
+// Start polls the rules file for changes until ctx is cancelled.
+func (e *Engine) Start(ctx context.Context) {
+	if e.interval <= 0 {
+		return
+	}
+
+	go filewatch.Watch(ctx, e.path, e.interval, e.log, e.reload)
+}
+
+func (e *Engine) reload() error {
+	rs, err := loadRuleSet(e.path)
+	if err != nil {
+		return err
+	}
+
+	e.rules.Store(rs)
+
+	e.log.Info().
+		Str("file", e.path).
+		Int("rules", len(rs.Rules)).
+		Str("default", rs.Default).
+		Str("precedence", rs.Precedence).
+		Msg("Access rules loaded")
+
+	return nil
+}
+
+// NeedsAnonymousDB reports whether the active rules match on anonymity flags.
+func (e *Engine) NeedsAnonymousDB() bool {
+	return e.rules.Load().needsAnonymousDB()
+}
@@ -0,0 +1,246 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/andreybrigunet/IpContext/clientip"
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+// Precedence modes controlling how matching rules are combined.
+const (
+	FirstMatch     = "first-match"     // the first matching rule decides
+	DenyOverrides  = "deny-overrides"  // any matching deny rule wins over allow rules
+	AllowOverrides = "allow-overrides" // any matching allow rule wins over deny rules
+)
+
+// Anonymity flags a rule can match on. They require the optional Anonymous IP database.
+var anonymityFlags = map[string]func(*geoip.AnonymousFlags) bool{
+	"anonymous":         func(f *geoip.AnonymousFlags) bool { return f.Anonymous },
+	"vpn":               func(f *geoip.AnonymousFlags) bool { return f.VPN },
+	"hosting":           func(f *geoip.AnonymousFlags) bool { return f.Hosting },
+	"public_proxy":      func(f *geoip.AnonymousFlags) bool { return f.PublicProxy },
+	"residential_proxy": func(f *geoip.AnonymousFlags) bool { return f.ResidentialProxy },
+	"tor":               func(f *geoip.AnonymousFlags) bool { return f.Tor },
+}
+
+// RuleSet is the content of an access rules file.
+type RuleSet struct {
+	Default    string `json:"default"`    // allow | deny, applied when no rule matches
+	Precedence string `json:"precedence"` // first-match | deny-overrides | allow-overrides
+	Rules      []Rule `json:"rules"`
+}
+
+// Rule matches when all of its non-empty conditions match the request.
+type Rule struct {
+	Name       string   `json:"name"`
+	Action     string   `json:"action"` // allow | deny
+	Countries  []string `json:"countries,omitempty"`
+	Continents []string `json:"continents,omitempty"`
+	EU         *bool    `json:"eu,omitempty"`
+	ASNs       []uint   `json:"asns,omitempty"`
+	CIDRs      []string `json:"cidrs,omitempty"`
+	Anonymous  []string `json:"anonymous,omitempty"` // any of: anonymous, vpn, hosting, public_proxy, residential_proxy, tor
+
+	nets []*net.IPNet
+}
+
+// Subject is what a rule set is evaluated against.
+type Subject struct {
+	IP        net.IP
+	Location  *geoip.Response       // nil when the lookup failed
+	Anonymous *geoip.AnonymousFlags // nil when the Anonymous IP database is absent
+}
+
+// Decision is the outcome of evaluating a rule set.
+type Decision struct {
+	Allow bool
+	Rule  string // name of the deciding rule, empty when the default applied
+}
+
+func loadRuleSet(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs RuleSet
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	if err := rs.prepare(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &rs, nil
+}
+
+func (rs *RuleSet) prepare() error {
+	rs.Default = strings.ToLower(rs.Default)
+	switch rs.Default {
+	case "":
+		rs.Default = "allow"
+	case "allow", "deny":
+	default:
+		return fmt.Errorf("invalid default %q", rs.Default)
+	}
+
+	rs.Precedence = strings.ToLower(rs.Precedence)
+	switch rs.Precedence {
+	case "":
+		rs.Precedence = FirstMatch
+	case FirstMatch, DenyOverrides, AllowOverrides:
+	default:
+		return fmt.Errorf("invalid precedence %q", rs.Precedence)
+	}
+
+	for i := range rs.Rules {
+		r := &rs.Rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+
+		r.Action = strings.ToLower(r.Action)
+		if r.Action != "allow" && r.Action != "deny" {
+			return fmt.Errorf("rule %q: invalid action %q", r.Name, r.Action)
+		}
+
+		for _, c := range r.CIDRs {
+			n, err := clientip.ParseCIDR(c)
+			if err != nil {
+				return fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+			r.nets = append(r.nets, n)
+		}
+
+		for _, f := range r.Anonymous {
+			if _, ok := anonymityFlags[strings.ToLower(f)]; !ok {
+				return fmt.Errorf("rule %q: unknown anonymity flag %q", r.Name, f)
+			}
+		}
+	}
+
+	return nil
+}
+
+// needsAnonymousDB reports whether any rule matches on anonymity flags.
+func (rs *RuleSet) needsAnonymousDB() bool {
+	for _, r := range rs.Rules {
+		if len(r.Anonymous) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate applies the rule set to the subject.
+func (rs *RuleSet) Evaluate(sub Subject) Decision {
+	var firstAllow, firstDeny string
+
+	for _, r := range rs.Rules {
+		if !r.matches(sub) {
+			continue
+		}
+
+		switch {
+		case rs.Precedence == FirstMatch:
+			return Decision{Allow: r.Action == "allow", Rule: r.Name}
+		case r.Action == "allow" && firstAllow == "":
+			firstAllow = r.Name
+		case r.Action == "deny" && firstDeny == "":
+			firstDeny = r.Name
+		}
+	}
+
+	switch {
+	case rs.Precedence == DenyOverrides && firstDeny != "":
+		return Decision{Allow: false, Rule: firstDeny}
+	case rs.Precedence == AllowOverrides && firstAllow != "":
+		return Decision{Allow: true, Rule: firstAllow}
+	case firstDeny != "":
+		return Decision{Allow: false, Rule: firstDeny}
+	case firstAllow != "":
+		return Decision{Allow: true, Rule: firstAllow}
+	}
+
+	return Decision{Allow: rs.Default == "allow"}
+}
+
+func (r *Rule) matches(sub Subject) bool {
+	if len(r.nets) > 0 && !containsIP(r.nets, sub.IP) {
+		return false
+	}
+
+	needsLocation := len(r.Countries) > 0 || len(r.Continents) > 0 || r.EU != nil || len(r.ASNs) > 0
+	if needsLocation {
+		loc := sub.Location
+		if loc == nil {
+			return false
+		}
+		if len(r.Countries) > 0 && !containsFold(r.Countries, loc.CountryCode) {
+			return false
+		}
+		if len(r.Continents) > 0 && !containsFold(r.Continents, loc.ContinentCode) {
+			return false
+		}
+		if r.EU != nil && *r.EU != loc.IsEUCountry {
+			return false
+		}
+		if len(r.ASNs) > 0 && !containsUint(r.ASNs, loc.ASNumber()) {
+			return false
+		}
+	}
+
+	if len(r.Anonymous) > 0 {
+		if sub.Anonymous == nil {
+			return false
+		}
+		hit := false
+		for _, f := range r.Anonymous {
+			if anonymityFlags[strings.ToLower(f)](sub.Anonymous) {
+				hit = true
+				break
+			}
+		}
+		if !hit {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, v string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUint(list []uint, v uint) bool {
+	for _, n := range list {
+		if n == v {
+			return true
+		}
+	}
+	return false
+}
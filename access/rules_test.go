@@ -0,0 +1,133 @@
+package access
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+func parseRuleSet(t *testing.T, content string) *RuleSet {
+	t.Helper()
+	var rs RuleSet
+	if err := json.Unmarshal([]byte(content), &rs); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.prepare(); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	return &rs
+}
+
+func TestEvaluatePrecedence(t *testing.T) {
+	rules := `"rules": [
+		{"name": "allow-us", "action": "allow", "countries": ["US"]},
+		{"name": "deny-google", "action": "deny", "asns": [15169]},
+		{"name": "allow-google", "action": "allow", "asns": [15169]},
+		{"name": "deny-office", "action": "deny", "cidrs": ["198.51.100.0/24"]}
+	]`
+	google := Subject{IP: net.ParseIP("8.8.8.8"), Location: &geoip.Response{CountryCode: "US", AS: "AS15169 Google LLC"}}
+	googleAbroad := Subject{IP: net.ParseIP("8.8.4.4"), Location: &geoip.Response{CountryCode: "IE", AS: "AS15169 Google LLC"}}
+	office := Subject{IP: net.ParseIP("198.51.100.7"), Location: &geoip.Response{CountryCode: "US"}}
+	other := Subject{IP: net.ParseIP("203.0.113.1"), Location: &geoip.Response{CountryCode: "FR"}}
+
+	tests := []struct {
+		precedence string
+		def        string
+		sub        Subject
+		allow      bool
+		rule       string
+	}{
+		{precedence: FirstMatch, sub: google, allow: true, rule: "allow-us"},
+		{precedence: FirstMatch, sub: googleAbroad, allow: false, rule: "deny-google"},
+		{precedence: FirstMatch, sub: office, allow: true, rule: "allow-us"},
+		{precedence: DenyOverrides, sub: google, allow: false, rule: "deny-google"},
+		{precedence: DenyOverrides, sub: office, allow: false, rule: "deny-office"},
+		{precedence: AllowOverrides, sub: google, allow: true, rule: "allow-us"},
+		{precedence: AllowOverrides, sub: googleAbroad, allow: true, rule: "allow-google"},
+		{precedence: AllowOverrides, sub: office, allow: true, rule: "allow-us"},
+		{precedence: "", sub: googleAbroad, allow: false, rule: "deny-google"}, // first-match by default
+		{precedence: FirstMatch, sub: other, allow: true},
+		{precedence: DenyOverrides, def: "deny", sub: other, allow: false},
+		{precedence: AllowOverrides, def: "DENY", sub: other, allow: false},
+	}
+
+	for _, tt := range tests {
+		rs := parseRuleSet(t, `{"default": "`+tt.def+`", "precedence": "`+tt.precedence+`", `+rules+`}`)
+		got := rs.Evaluate(tt.sub)
+		if got.Allow != tt.allow || got.Rule != tt.rule {
+			t.Errorf("%s, default %q: Evaluate(%s) = %+v, want allow %v by %q", rs.Precedence, tt.def, tt.sub.IP, got, tt.allow, tt.rule)
+		}
+	}
+}
+
+func TestRuleMatching(t *testing.T) {
+	us := &geoip.Response{CountryCode: "US", ContinentCode: "NA", AS: "AS15169 Google LLC"}
+	de := &geoip.Response{CountryCode: "DE", ContinentCode: "EU", IsEUCountry: true, AS: "AS14907 Wikimedia Foundation Inc."}
+
+	tests := []struct {
+		name string
+		rule string
+		sub  Subject
+		want bool
+	}{
+		{name: "IPv4 CIDR", rule: `"cidrs": ["192.0.2.0/24"]`, sub: Subject{IP: net.ParseIP("192.0.2.200")}, want: true},
+		{name: "IPv4 CIDR miss", rule: `"cidrs": ["192.0.2.0/24"]`, sub: Subject{IP: net.ParseIP("192.0.3.1")}, want: false},
+		{name: "IPv6 CIDR", rule: `"cidrs": ["192.0.2.0/24", "2001:db8::/32"]`, sub: Subject{IP: net.ParseIP("2001:db8:1::1")}, want: true},
+		{name: "single address", rule: `"cidrs": ["192.0.2.1"]`, sub: Subject{IP: net.ParseIP("192.0.2.1")}, want: true},
+		{name: "CIDR without IP", rule: `"cidrs": ["0.0.0.0/0"]`, sub: Subject{}, want: false},
+		{name: "country", rule: `"countries": ["de", "AT"]`, sub: Subject{Location: de}, want: true},
+		{name: "country miss", rule: `"countries": ["AT"]`, sub: Subject{Location: de}, want: false},
+		{name: "continent", rule: `"continents": ["NA"]`, sub: Subject{Location: us}, want: true},
+		{name: "EU", rule: `"eu": true`, sub: Subject{Location: de}, want: true},
+		{name: "not EU", rule: `"eu": false`, sub: Subject{Location: de}, want: false},
+		{name: "ASN", rule: `"asns": [14907, 13335]`, sub: Subject{Location: de}, want: true},
+		{name: "ASN miss", rule: `"asns": [13335]`, sub: Subject{Location: us}, want: false},
+		{name: "location unknown", rule: `"countries": ["US"]`, sub: Subject{IP: net.ParseIP("8.8.8.8")}, want: false},
+		{name: "all conditions", rule: `"countries": ["US"], "asns": [15169], "cidrs": ["8.8.8.0/24"]`, sub: Subject{IP: net.ParseIP("8.8.8.8"), Location: us}, want: true},
+		{name: "one condition fails", rule: `"countries": ["US"], "asns": [15169], "cidrs": ["8.8.4.0/24"]`, sub: Subject{IP: net.ParseIP("8.8.8.8"), Location: us}, want: false},
+		{name: "anonymity flag", rule: `"anonymous": ["TOR", "vpn"]`, sub: Subject{Anonymous: &geoip.AnonymousFlags{Anonymous: true, VPN: true}}, want: true},
+		{name: "anonymity flag miss", rule: `"anonymous": ["tor"]`, sub: Subject{Anonymous: &geoip.AnonymousFlags{Anonymous: true, VPN: true}}, want: false},
+		{name: "anonymity without database", rule: `"anonymous": ["vpn"]`, sub: Subject{}, want: false},
+		{name: "no conditions", rule: `"name": "any"`, sub: Subject{}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := parseRuleSet(t, `{"default": "deny", "rules": [{"action": "allow", `+tt.rule+`}]}`)
+			if got := rs.Evaluate(tt.sub).Allow; got != tt.want {
+				t.Errorf("rule {%s} matched %v, want %v", tt.rule, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	rs := parseRuleSet(t, `{"rules": [{"action": "Allow"}, {"name": "named", "action": "deny"}]}`)
+	if rs.Default != "allow" || rs.Precedence != FirstMatch || rs.Rules[0].Name != "rule-1" || rs.Rules[0].Action != "allow" {
+		t.Errorf("prepared = %+v, want the defaults filled in", rs)
+	}
+
+	tests := []struct {
+		content string
+		wantErr string
+	}{
+		{content: `{"default": "maybe"}`, wantErr: `invalid default "maybe"`},
+		{content: `{"precedence": "last-match"}`, wantErr: `invalid precedence "last-match"`},
+		{content: `{"rules": [{"action": "block"}]}`, wantErr: `rule "rule-1": invalid action "block"`},
+		{content: `{"rules": [{"action": "deny", "cidrs": ["10.0.0.0/33"]}]}`, wantErr: "10.0.0.0/33"},
+		{content: `{"rules": [{"action": "deny", "anonymous": ["botnet"]}]}`, wantErr: `unknown anonymity flag "botnet"`},
+	}
+
+	for _, tt := range tests {
+		var rs RuleSet
+		if err := json.Unmarshal([]byte(tt.content), &rs); err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.prepare(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("prepare(%s) error = %v, want one containing %q", tt.content, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/clientip"
+	"github.com/andreybrigunet/IpContext/logx"
+	"github.com/rs/zerolog"
+)
+
+type refreshResponse struct {
+	Status    string   `json:"status"`
+	Stores    []string `json:"stores"`
+	Countries []string `json:"countries,omitempty"` // empty for every country
+}
+
+// handleRefresh starts a GeoNames refresh in the background:
+// POST /admin/refresh?stores=neighbours,languages&countries=DE,FR. Both
+// parameters are optional and default to every store and every country.
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if len(s.opts.Stores) == 0 {
+		respondError(w, "GeoNames stores are disabled", http.StatusNotFound)
+		return
+	}
+
+	names := splitList(r.URL.Query().Get("stores"), false)
+	if len(names) == 0 {
+		for name := range s.opts.Stores {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	countries := splitList(r.URL.Query().Get("countries"), true)
+
+	for _, name := range names {
+		st, ok := s.opts.Stores[name]
+		if !ok {
+			respondError(w, fmt.Sprintf("Unknown store %q", name), http.StatusBadRequest)
+			return
+		}
+		for _, cc := range countries {
+			if !st.HasCountry(cc) {
+				respondError(w, fmt.Sprintf("Unknown country %q", cc), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	started := make([]string, 0, len(names))
+	for _, name := range names {
+		if !s.refreshing[name].CompareAndSwap(false, true) {
+			continue
+		}
+		started = append(started, name)
+
+		go func(name string, st Store) {
+			defer s.refreshing[name].Store(false)
+			if len(countries) == 0 {
+				st.RefreshAllContext(s.ctx)
+			} else {
+				st.RefreshCountries(s.ctx, countries)
+			}
+		}(name, s.opts.Stores[name])
+	}
+	if len(started) == 0 {
+		respondError(w, "A refresh of "+strings.Join(names, ", ")+" is already running", http.StatusConflict)
+		return
+	}
+
+	respondJSON(w, refreshResponse{Status: "success", Stores: started, Countries: countries}, http.StatusAccepted)
+}
+
+type databaseInfo struct {
+	Edition   string    `json:"edition"`
+	BuildTime time.Time `json:"buildTime"`
+}
+
+// handleReloadDatabases reopens the MaxMind databases:
+// POST /admin/databases/reload.
+func (s *Server) handleReloadDatabases(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	if err := s.geoIP.Reload(); err != nil {
+		s.log.Error().Err(err).Msg("Admin database reload failed")
+		respondError(w, "Database reload failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dbs := make([]databaseInfo, 0, 3)
+	for _, db := range s.geoIP.Databases() {
+		dbs = append(dbs, databaseInfo{Edition: db.Edition, BuildTime: db.BuildTime})
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Edition < dbs[j].Edition })
+
+	respondJSON(w, map[string]interface{}{"status": "success", "databases": dbs}, http.StatusOK)
+}
+
+// handlePurgeCache drops cached lookups:
+// POST /admin/cache/purge?cidrs=203.0.113.0/24,2001:db8::1. Without cidrs
+// the whole cache is purged.
+func (s *Server) handlePurgeCache(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	nets, err := clientip.ParseCIDRs(splitList(r.URL.Query().Get("cidrs"), false))
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	purged := s.geoIP.PurgeCache(nets)
+	s.log.Info().Int("purged", purged).Int("cidrs", len(nets)).Msg("Cache purged")
+	respondJSON(w, map[string]interface{}{"status": "success", "purged": purged}, http.StatusOK)
+}
+
+// handleLogLevel reports the log level, or changes it with
+// POST /admin/log-level?level=debug. Outputs with their own level keep it.
+func (s *Server) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		v := r.URL.Query().Get("level")
+		lvl, err := zerolog.ParseLevel(strings.ToLower(v))
+		if err != nil || v == "" || lvl == zerolog.NoLevel || lvl == zerolog.Disabled {
+			respondError(w, "Invalid level, expected trace, debug, info, warn, error, fatal or panic", http.StatusBadRequest)
+			return
+		}
+
+		prev := logx.Level()
+		logx.SetLevel(lvl)
+		// Logged at the new level, if above info, so that it is not filtered out.
+		s.log.WithLevel(max(zerolog.InfoLevel, lvl)).Str("from", prev.String()).Str("to", lvl.String()).Msg("Log level changed")
+	}
+
+	respondJSON(w, map[string]string{"status": "success", "level": logx.Level().String()}, http.StatusOK)
+}
+
+// handleConfig returns the effective configuration with secrets redacted:
+// GET /admin/config.
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	respondJSON(w, map[string]interface{}{"status": "success", "config": s.opts.Config()}, http.StatusOK)
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// splitList splits a comma separated parameter, optionally upper-casing
+// the items.
+func splitList(v string, upper bool) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if upper {
+			item = strings.ToUpper(item)
+		}
+		out = append(out, item)
+	}
+	return out
+}
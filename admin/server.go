@@ -0,0 +1,188 @@
+// Package admin serves runtime operations on their own listener, so they
+// never reach the public API port: GeoNames refreshes, database reloads,
+// cache purges, log level changes, per-key usage, the effective
+// configuration and pprof.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/apikey"
+	"github.com/andreybrigunet/IpContext/geoip"
+	"github.com/andreybrigunet/IpContext/listen"
+	"github.com/andreybrigunet/IpContext/privacy"
+	"github.com/andreybrigunet/IpContext/usage"
+	"github.com/rs/zerolog"
+)
+
+// Store is implemented by the GeoNames stores.
+type Store interface {
+	RefreshAllContext(ctx context.Context)
+	RefreshCountries(ctx context.Context, countries []string)
+	HasCountry(countryCode string) bool
+}
+
+// Options configures the admin server.
+type Options struct {
+	Addr       string // host:port, unix:///path or systemd:[name]
+	SocketMode os.FileMode
+
+	// APIKeys authenticates callers, who need a key with the admin endpoint
+	// scope. nil leaves access control to Allowed alone.
+	APIKeys *apikey.Store
+
+	// Allowed lists the peers that may connect; empty allows any. Unix
+	// socket peers are governed by the socket permissions instead.
+	Allowed []*net.IPNet
+
+	Usage  *usage.Meter       // enables /admin/usage; nil disables it
+	Stores map[string]Store   // GeoNames stores by name
+	Config func() interface{} // effective configuration, secrets redacted
+	Pprof  bool               // serve /debug/pprof/
+
+	Privacy *privacy.Anonymizer // anonymizes peer addresses in logs; nil logs them as they are
+}
+
+// Server is the admin HTTP server.
+type Server struct {
+	opts   Options
+	geoIP  *geoip.GeoIP
+	server *http.Server
+	log    zerolog.Logger
+
+	// ctx outlives requests for refreshes running in the background.
+	ctx        context.Context
+	cancel     context.CancelFunc
+	refreshing map[string]*atomic.Bool
+}
+
+// NewServer returns an admin server for opts.
+func NewServer(opts Options, geoIP *geoip.GeoIP, logger zerolog.Logger) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		opts:       opts,
+		geoIP:      geoIP,
+		log:        logger,
+		ctx:        ctx,
+		cancel:     cancel,
+		refreshing: make(map[string]*atomic.Bool, len(opts.Stores)),
+	}
+	for name := range opts.Stores {
+		s.refreshing[name] = &atomic.Bool{}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/refresh", s.handleRefresh)
+	mux.HandleFunc("/admin/databases/reload", s.handleReloadDatabases)
+	mux.HandleFunc("/admin/cache/purge", s.handlePurgeCache)
+	mux.HandleFunc("/admin/log-level", s.handleLogLevel)
+	mux.HandleFunc("/admin/config", s.handleConfig)
+	if opts.Usage != nil {
+		mux.HandleFunc("/admin/usage", s.handleUsage)
+	}
+	if opts.Pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	s.server = &http.Server{
+		Addr:              opts.Addr,
+		Handler:           s.authMiddleware(mux),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// Start serves until Stop is called.
+func (s *Server) Start() error {
+	s.log.Info().
+		Str("addr", s.server.Addr).
+		Bool("apiKeys", s.opts.APIKeys != nil).
+		Int("allowedNets", len(s.opts.Allowed)).
+		Bool("pprof", s.opts.Pprof).
+		Msg("Starting admin server")
+
+	ln, err := listen.Listen(s.server.Addr, s.opts.SocketMode)
+	if err != nil {
+		return err
+	}
+	return s.server.Serve(ln)
+}
+
+// Stop shuts the server down gracefully and abandons running refreshes.
+func (s *Server) Stop() error {
+	s.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// authMiddleware admits allowed peers presenting a key with the admin scope.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.peerAllowed(r.RemoteAddr) {
+			s.log.Warn().Str("remoteAddr", s.opts.Privacy.String(r.RemoteAddr)).Str("path", r.URL.Path).Msg("Admin request from disallowed peer")
+			respondError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		keyName := ""
+		if s.opts.APIKeys != nil {
+			key, ok := s.opts.APIKeys.Authenticate(apikey.FromRequest(r))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="ipcontext-admin"`)
+				respondError(w, "Valid API key required", http.StatusUnauthorized)
+				return
+			}
+			if !key.AllowsEndpoint(apikey.EndpointAdmin) {
+				respondError(w, "API key is not permitted to use this endpoint", http.StatusForbidden)
+				return
+			}
+			keyName = key.Name
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			s.log.Info().Str("method", r.Method).Str("path", r.URL.Path).Str("query", apikey.StripQuery(r.URL.RawQuery)).Str("key", keyName).Msg("Admin request")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) peerAllowed(remoteAddr string) bool {
+	if len(s.opts.Allowed) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		// Unix socket peers have no address.
+		return true
+	}
+	ip := net.ParseIP(host)
+	for _, n := range s.opts.Allowed {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func respondError(w http.ResponseWriter, message string, statusCode int) {
+	respondJSON(w, map[string]string{"status": "fail", "message": message}, statusCode)
+}
+
+func respondJSON(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,60 @@
+package admin
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/andreybrigunet/IpContext/usage"
+)
+
+type keyUsage struct {
+	Key   string      `json:"key"`
+	Total uint64      `json:"total"`
+	Days  []usage.Day `json:"days"`
+}
+
+type usageResponse struct {
+	Status string     `json:"status"`
+	From   string     `json:"from"`
+	To     string     `json:"to"`
+	Keys   []keyUsage `json:"keys"`
+}
+
+// handleUsage reports the usage of every key:
+// GET /admin/usage?from=2024-05-01&to=2024-05-31 (defaults to the current
+// month).
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	from, to, err := usage.ParseRange(r.URL.Query())
+	if err != nil {
+		respondError(w, "Invalid usage range: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	all, err := s.opts.Usage.ReportAll(from, to)
+	if err != nil {
+		s.log.Error().Err(err).Msg("Usage report failed")
+		respondError(w, "Usage report failed", http.StatusInternalServerError)
+		return
+	}
+
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	keys := make([]keyUsage, 0, len(names))
+	for _, name := range names {
+		ku := keyUsage{Key: name, Days: all[name]}
+		for _, d := range ku.Days {
+			ku.Total += d.Total
+		}
+		keys = append(keys, ku)
+	}
+
+	respondJSON(w, usageResponse{Status: "success", From: from, To: to, Keys: keys}, http.StatusOK)
+}
@@ -0,0 +1,170 @@
+// Package apikey authenticates API callers against a file of hashed keys and
+// describes what each key may do.
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andreybrigunet/IpContext/ratelimit"
+)
+
+// Endpoint scopes a key can be granted.
+const (
+	EndpointSingle = "single" // GET / and GET /{ip}
+	EndpointBatch  = "batch"  // POST /batch
+	EndpointAdmin  = "admin"  // administrative endpoints
+)
+
+// File is the content of an API keys file.
+type File struct {
+	Keys []Key `json:"keys"`
+}
+
+// Key is one API key. Only the SHA-256 hash of the key is stored.
+type Key struct {
+	Name   string `json:"name"`
+	Hash   string `json:"hash"` // hex SHA-256 of the key, optionally prefixed with "sha256:"
+	Scopes Scopes `json:"scopes"`
+}
+
+// Scopes limit what a key may do. Empty lists mean no restriction, except
+// that the admin endpoint must always be granted explicitly.
+type Scopes struct {
+	Endpoints []string `json:"endpoints,omitempty"` // single, batch, admin
+	Fields    []string `json:"fields,omitempty"`    // response fields the key may read
+	MaxBatch  int      `json:"maxBatch,omitempty"`  // largest accepted batch, 0 for the server limit
+	RateLimit string   `json:"rateLimit,omitempty"` // e.g. "100/s:200", overrides the server's per-key limit
+
+	MonthlyQuota uint64 `json:"monthlyQuota,omitempty"` // lookups per calendar month (UTC), 0 for unlimited
+	Precision    string `json:"precision,omitempty"`    // response precision profile, overriding the route's
+
+	rateLimit ratelimit.Limit
+}
+
+// Hash returns the value to store in a keys file for the raw key.
+func Hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// RateLimit returns the key's own rate limit, if it has one.
+func (k *Key) RateLimit() (ratelimit.Limit, bool) {
+	return k.Scopes.rateLimit, k.Scopes.RateLimit != ""
+}
+
+// AllowsEndpoint reports whether the key may call the given endpoint.
+func (k *Key) AllowsEndpoint(endpoint string) bool {
+	if len(k.Scopes.Endpoints) == 0 {
+		return endpoint != EndpointAdmin
+	}
+	for _, e := range k.Scopes.Endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields resolves the response fields for a request. With no field scope the
+// requested fields are returned unchanged. Otherwise an empty request means
+// every permitted field, and the first requested field outside the scope is
+// returned as denied.
+func (k *Key) Fields(requested []string) (fields []string, denied string) {
+	if len(k.Scopes.Fields) == 0 {
+		return requested, ""
+	}
+	if len(requested) == 0 {
+		return k.Scopes.Fields, ""
+	}
+	for _, f := range requested {
+		if !k.allowsField(f) {
+			return nil, f
+		}
+	}
+	return requested, ""
+}
+
+func (k *Key) allowsField(field string) bool {
+	// query and status are part of every response
+	if field == "query" || field == "status" {
+		return true
+	}
+	for _, f := range k.Scopes.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func loadFile(path string) (map[string]*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	keys := make(map[string]*Key, len(f.Keys))
+	names := make(map[string]bool, len(f.Keys))
+	for i := range f.Keys {
+		k := &f.Keys[i]
+		// Names identify keys in usage reports, so they must be unique.
+		if k.Name == "" || strings.Contains(k.Name, "|") {
+			return nil, fmt.Errorf("%s: key %d needs a name without \"|\"", path, i)
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("%s: duplicate key name %q", path, k.Name)
+		}
+		names[k.Name] = true
+
+		hash := strings.ToLower(strings.TrimPrefix(k.Hash, "sha256:"))
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("%s: key %q: hash must be a hex SHA-256 digest", path, k.Name)
+		}
+		if _, dup := keys[hash]; dup {
+			return nil, fmt.Errorf("%s: key %q: duplicate hash", path, k.Name)
+		}
+
+		for _, e := range k.Scopes.Endpoints {
+			switch e {
+			case EndpointSingle, EndpointBatch, EndpointAdmin:
+			default:
+				return nil, fmt.Errorf("%s: key %q: unknown endpoint scope %q", path, k.Name, e)
+			}
+		}
+		if k.Scopes.MaxBatch < 0 {
+			return nil, fmt.Errorf("%s: key %q: maxBatch must not be negative", path, k.Name)
+		}
+		if k.Scopes.rateLimit, err = ratelimit.ParseLimit(k.Scopes.RateLimit); err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", path, k.Name, err)
+		}
+
+		k.Hash = hash
+		keys[hash] = k
+	}
+
+	return keys, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the authenticated key.
+func NewContext(ctx context.Context, k *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, k)
+}
+
+// FromContext returns the key stored by NewContext, if any.
+func FromContext(ctx context.Context) (*Key, bool) {
+	k, ok := ctx.Value(contextKey{}).(*Key)
+	return k, ok && k != nil
+}
@@ -0,0 +1,258 @@
+package apikey
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func writeKeys(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newStore(t *testing.T, content string) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeys(t, path, content)
+	s, err := New(path, 0, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s, path
+}
+
+func TestHash(t *testing.T) {
+	// echo -n secret | sha256sum
+	const want = "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+	if got := Hash("secret"); got != want {
+		t.Errorf("Hash(secret) = %s, want %s", got, want)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s, _ := newStore(t, `{"keys": [
+		{"name": "plain", "hash": "`+Hash("secret")+`"},
+		{"name": "prefixed", "hash": "sha256:`+strings.ToUpper(Hash("other"))+`"}
+	]}`)
+
+	tests := []struct {
+		raw  string
+		want string // key name, empty when rejected
+	}{
+		{raw: "secret", want: "plain"},
+		{raw: "other", want: "prefixed"},
+		{raw: "wrong"},
+		{raw: ""},
+		{raw: Hash("secret")}, // the stored hash is not a key
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if k, ok := s.Authenticate(tt.raw); ok {
+			got = k.Name
+		}
+		if got != tt.want {
+			t.Errorf("Authenticate(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	hash := Hash("secret")
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "invalid JSON", content: `{"keys": [`, wantErr: "parse"},
+		{name: "missing name", content: `{"keys": [{"hash": "` + hash + `"}]}`, wantErr: "needs a name"},
+		{name: "name with separator", content: `{"keys": [{"name": "a|b", "hash": "` + hash + `"}]}`, wantErr: "needs a name"},
+		{name: "duplicate name", content: `{"keys": [{"name": "a", "hash": "` + hash + `"}, {"name": "a", "hash": "` + Hash("x") + `"}]}`, wantErr: "duplicate key name"},
+		{name: "short hash", content: `{"keys": [{"name": "a", "hash": "abcd"}]}`, wantErr: "hex SHA-256"},
+		{name: "duplicate hash", content: `{"keys": [{"name": "a", "hash": "` + hash + `"}, {"name": "b", "hash": "sha256:` + hash + `"}]}`, wantErr: "duplicate hash"},
+		{name: "unknown endpoint", content: `{"keys": [{"name": "a", "hash": "` + hash + `", "scopes": {"endpoints": ["bulk"]}}]}`, wantErr: "unknown endpoint scope"},
+		{name: "negative maxBatch", content: `{"keys": [{"name": "a", "hash": "` + hash + `", "scopes": {"maxBatch": -1}}]}`, wantErr: "maxBatch"},
+		{name: "bad rate limit", content: `{"keys": [{"name": "a", "hash": "` + hash + `", "scopes": {"rateLimit": "fast"}}]}`, wantErr: `key "a"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.json")
+			writeKeys(t, path, tt.content)
+			_, err := loadFile(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadFile() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAllowsEndpoint(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints []string
+		endpoint  string
+		want      bool
+	}{
+		{name: "unscoped single", endpoint: EndpointSingle, want: true},
+		{name: "unscoped batch", endpoint: EndpointBatch, want: true},
+		{name: "unscoped admin", endpoint: EndpointAdmin, want: false},
+		{name: "scoped in", endpoints: []string{EndpointBatch}, endpoint: EndpointBatch, want: true},
+		{name: "scoped out", endpoints: []string{EndpointBatch}, endpoint: EndpointSingle, want: false},
+		{name: "admin granted", endpoints: []string{EndpointAdmin}, endpoint: EndpointAdmin, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &Key{Scopes: Scopes{Endpoints: tt.endpoints}}
+			if got := k.AllowsEndpoint(tt.endpoint); got != tt.want {
+				t.Errorf("AllowsEndpoint(%s) = %v, want %v", tt.endpoint, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFields(t *testing.T) {
+	tests := []struct {
+		name       string
+		scope      []string
+		requested  []string
+		wantFields string
+		wantDenied string
+	}{
+		{name: "unscoped", requested: []string{"city"}, wantFields: "city"},
+		{name: "unscoped, none requested", wantFields: ""},
+		{name: "scoped, none requested", scope: []string{"country", "as"}, wantFields: "country,as"},
+		{name: "scoped, allowed", scope: []string{"country", "as"}, requested: []string{"as"}, wantFields: "as"},
+		{name: "scoped, always allowed", scope: []string{"country"}, requested: []string{"query", "status", "country"}, wantFields: "query,status,country"},
+		{name: "scoped, denied", scope: []string{"country"}, requested: []string{"country", "lat", "city"}, wantDenied: "lat"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &Key{Scopes: Scopes{Fields: tt.scope}}
+			fields, denied := k.Fields(tt.requested)
+			if got := strings.Join(fields, ","); got != tt.wantFields || denied != tt.wantDenied {
+				t.Errorf("Fields(%v) = %q, %q, want %q, %q", tt.requested, got, denied, tt.wantFields, tt.wantDenied)
+			}
+		})
+	}
+}
+
+func TestMaxBatchScope(t *testing.T) {
+	s, _ := newStore(t, `{"keys": [{"name": "small", "hash": "`+Hash("secret")+`", "scopes": {"maxBatch": 5, "endpoints": ["batch"]}}]}`)
+	k, ok := s.Authenticate("secret")
+	if !ok {
+		t.Fatal("key rejected")
+	}
+	if k.Scopes.MaxBatch != 5 {
+		t.Errorf("MaxBatch = %d, want 5", k.Scopes.MaxBatch)
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		header map[string]string
+		want   string
+	}{
+		{name: "none", target: "/8.8.8.8"},
+		{name: "header", target: "/8.8.8.8", header: map[string]string{"X-API-Key": " secret "}, want: "secret"},
+		{name: "bearer", target: "/8.8.8.8", header: map[string]string{"Authorization": "bearer secret"}, want: "secret"},
+		{name: "basic is ignored", target: "/8.8.8.8", header: map[string]string{"Authorization": "Basic secret"}},
+		{name: "query", target: "/8.8.8.8?key=secret", want: "secret"},
+		{name: "header wins over query", target: "/8.8.8.8?key=query", header: map[string]string{"X-API-Key": "header"}, want: "header"},
+		{name: "bearer wins over query", target: "/8.8.8.8?key=query", header: map[string]string{"Authorization": "Bearer bearer"}, want: "bearer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			if got := FromRequest(r); got != tt.want {
+				t.Errorf("FromRequest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "", want: ""},
+		{query: "fields=country", want: "fields=country"},
+		{query: "key=secret", want: ""},
+		{query: "fields=country&key=secret", want: "fields=country"},
+		{query: "key=a&key=b&lang=en", want: "lang=en"},
+		{query: "key=%zz", want: ""}, // malformed, may hide a key
+	}
+
+	for _, tt := range tests {
+		if got := StripQuery(tt.query); got != tt.want {
+			t.Errorf("StripQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	s, path := newStore(t, `{"keys": [{"name": "old", "hash": "`+Hash("old")+`"}]}`)
+
+	// A broken file keeps the previous keys.
+	writeKeys(t, path, `{"keys": [`)
+	if err := s.reload(); err == nil {
+		t.Fatal("reload of a broken file succeeded")
+	}
+	if _, ok := s.Authenticate("old"); !ok {
+		t.Error("previous key dropped after a failed reload")
+	}
+
+	writeKeys(t, path, `{"keys": [{"name": "new", "hash": "`+Hash("new")+`"}]}`)
+	if err := s.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, ok := s.Authenticate("old"); ok {
+		t.Error("removed key still accepted")
+	}
+	if _, ok := s.Authenticate("new"); !ok {
+		t.Error("added key rejected")
+	}
+}
+
+func TestSetCheck(t *testing.T) {
+	s, path := newStore(t, `{"keys": [{"name": "a", "hash": "`+Hash("a")+`"}]}`)
+
+	reject := func(name string) func(*Key) error {
+		return func(k *Key) error {
+			if k.Name == name {
+				return os.ErrInvalid
+			}
+			return nil
+		}
+	}
+	if err := s.SetCheck(reject("a")); err == nil {
+		t.Fatal("SetCheck accepted a failing key")
+	}
+	if err := s.SetCheck(reject("b")); err != nil {
+		t.Fatalf("SetCheck: %v", err)
+	}
+
+	writeKeys(t, path, `{"keys": [{"name": "b", "hash": "`+Hash("b")+`"}]}`)
+	if err := s.reload(); err == nil {
+		t.Fatal("reload accepted a key failing the check")
+	}
+	if _, ok := s.Authenticate("a"); !ok {
+		t.Error("previous key dropped after a failed check")
+	}
+}
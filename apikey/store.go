@@ -0,0 +1,127 @@
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/filewatch"
+	"github.com/rs/zerolog"
+)
+
+// QueryParam is the query parameter that may carry the key, as in ip-api pro.
+const QueryParam = "key"
+
+// Store holds the active keys and reloads them when the file changes on disk.
+type Store struct {
+	path     string
+	interval time.Duration
+	log      zerolog.Logger
+	check    func(*Key) error
+
+	keys atomic.Pointer[map[string]*Key]
+}
+
+// New loads the keys file. Use Start to enable hot reload.
+func New(path string, interval time.Duration, logger zerolog.Logger) (*Store, error) {
+	s := &Store{
+		path:     path,
+		interval: interval,
+		log:      logger,
+	}
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// SetCheck adds a validation that every key must pass, for scopes that
+// refer to server configuration. The loaded keys are checked right away;
+// a reload that fails the check keeps the previous keys. It must be called
+// before Start.
+func (s *Store) SetCheck(check func(*Key) error) error {
+	s.check = check
+	return s.checkKeys(*s.keys.Load())
+}
+
+func (s *Store) checkKeys(keys map[string]*Key) error {
+	if s.check == nil {
+		return nil
+	}
+	for _, k := range keys {
+		if err := s.check(k); err != nil {
+			return fmt.Errorf("%s: key %q: %w", s.path, k.Name, err)
+		}
+	}
+	return nil
+}
+
+// Authenticate returns the key matching raw.
+func (s *Store) Authenticate(raw string) (*Key, bool) {
+	if raw == "" {
+		return nil, false
+	}
+	k, ok := (*s.keys.Load())[Hash(raw)]
+	return k, ok
+}
+
+// FromRequest returns the raw key from the X-API-Key header, an
+// "Authorization: Bearer" header or the key query parameter, in that order.
+func FromRequest(r *http.Request) string {
+	if v := strings.TrimSpace(r.Header.Get("X-API-Key")); v != "" {
+		return v
+	}
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get(QueryParam)
+}
+
+// StripQuery returns rawQuery without QueryParam, so that it can be logged
+// without leaking keys.
+func StripQuery(rawQuery string) string {
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// A malformed query may still carry a key, so leave it out.
+		return ""
+	}
+	if !q.Has(QueryParam) {
+		return rawQuery
+	}
+	q.Del(QueryParam)
+	return q.Encode()
+}
+
+// Start polls the keys file for changes until ctx is cancelled.
+func (s *Store) Start(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+
+	go filewatch.Watch(ctx, s.path, s.interval, s.log, s.reload)
+}
+
+func (s *Store) reload() error {
+	keys, err := loadFile(s.path)
+	if err != nil {
+		return err
+	}
+	if err := s.checkKeys(keys); err != nil {
+		return err
+	}
+
+	s.keys.Store(&keys)
+
+	s.log.Info().
+		Str("file", s.path).
+		Int("keys", len(keys)).
+		Msg("API keys loaded")
+
+	return nil
+}
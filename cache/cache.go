@@ -2,6 +2,7 @@ package cache
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,6 +25,18 @@ type Cache struct {
 	mu    sync.RWMutex
 	items map[string]Item
 	ttl   time.Duration
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// Stats is a snapshot of cache counters
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64 // expired items removed by the cleanup loop
+	Size      int
 }
 
 // New creates a new cache with the specified TTL
@@ -57,14 +70,12 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 	defer c.mu.RUnlock()
 	
 	item, found := c.items[key]
-	if !found {
-		return nil, false
-	}
-	
-	if item.IsExpired() {
+	if !found || item.IsExpired() {
+		c.misses.Add(1)
 		return nil, false
 	}
 	
+	c.hits.Add(1)
 	return item.Value, true
 }
 
@@ -82,6 +93,28 @@ func (c *Cache) Clear() {
 	c.items = make(map[string]Item)
 }
 
+// DeleteFunc removes the items whose key matches and returns how many were removed
+func (c *Cache) DeleteFunc(match func(key string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for key := range c.items {
+		if match(key) {
+			delete(c.items, key)
+			n++
+		}
+	}
+	return n
+}
+
+// SetTTL changes the TTL of items added from now on
+func (c *Cache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
 // Size returns the number of items in the cache
 func (c *Cache) Size() int {
 	c.mu.RLock()
@@ -89,6 +122,16 @@ func (c *Cache) Size() int {
 	return len(c.items)
 }
 
+// Stats returns the current counters
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      c.Size(),
+	}
+}
+
 // cleanup removes expired items periodically
 func (c *Cache) cleanup() {
 	ticker := time.NewTicker(time.Minute)
@@ -101,6 +144,7 @@ func (c *Cache) cleanup() {
 			for key, item := range c.items {
 				if item.IsExpired() {
 					delete(c.items, key)
+					c.evictions.Add(1)
 				}
 			}
 			c.mu.Unlock()
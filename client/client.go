@@ -0,0 +1,337 @@
+// Package client is a Go client for the IpContext HTTP API.
+//
+//	c, err := client.New("http://localhost:3280", client.WithCache(10000, 5*time.Minute))
+//	if err != nil { ... }
+//	resp, err := c.Lookup(ctx, "8.8.8.8", "country", "city")
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+// MaxBatchSize is the number of queries the server accepts per batch request.
+// Larger batches are split transparently.
+const MaxBatchSize = 100
+
+// APIError is returned when the server answers with an error status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ipcontext: HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("ipcontext: HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+// Client talks to an IpContext server. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	cache      *lru
+	apiKey     string
+}
+
+// Option customises a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the underlying HTTP client (default: 10s timeout).
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithAPIKey sends key in the X-API-Key header of every request.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithRetries retries failed requests up to max times with exponential
+// backoff between min and max delay. Network errors, 429 and 5xx are retried.
+func WithRetries(max int, minDelay, maxDelay time.Duration) Option {
+	return func(c *Client) {
+		c.retries = max
+		c.minBackoff = minDelay
+		c.maxBackoff = maxDelay
+	}
+}
+
+// WithCache keeps up to size successful responses in a local LRU cache for ttl.
+func WithCache(size int, ttl time.Duration) Option {
+	return func(c *Client) {
+		if size > 0 {
+			c.cache = newLRU(size, ttl)
+		}
+	}
+}
+
+// New creates a client for the server at baseURL, e.g. "http://localhost:3280".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("ipcontext: base URL must be absolute, got %q", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		retries:    2,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 2 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// Lookup returns information about ip. When fields are given, only those
+// response fields (plus query and status) are requested.
+func (c *Client) Lookup(ctx context.Context, ip string, fields ...string) (*geoip.Response, error) {
+	key := cacheKey(ip, fields)
+	if resp, ok := c.cacheGet(key); ok {
+		return resp, nil
+	}
+
+	var resp geoip.Response
+	if err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(ip), fields, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	c.cacheSet(key, &resp)
+	return &resp, nil
+}
+
+// Self returns information about the caller's own address as seen by the server.
+// Results are never cached since the address may change.
+func (c *Client) Self(ctx context.Context, fields ...string) (*geoip.Response, error) {
+	var resp geoip.Response
+	if err := c.do(ctx, http.MethodGet, "/", fields, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Batch looks up several addresses, returning results in the same order.
+// Entries the server could not resolve have Status "fail" and a Message;
+// an error is returned only when a request as a whole fails.
+func (c *Client) Batch(ctx context.Context, ips []string, fields ...string) ([]*geoip.Response, error) {
+	results := make([]*geoip.Response, len(ips))
+
+	var missing []int
+	for i, ip := range ips {
+		if resp, ok := c.cacheGet(cacheKey(ip, fields)); ok {
+			results[i] = resp
+			continue
+		}
+		missing = append(missing, i)
+	}
+
+	for start := 0; start < len(missing); start += MaxBatchSize {
+		end := start + MaxBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		chunk := missing[start:end]
+
+		queries := make([]string, len(chunk))
+		for j, idx := range chunk {
+			queries[j] = ips[idx]
+		}
+
+		body, err := json.Marshal(queries)
+		if err != nil {
+			return nil, err
+		}
+
+		var batch []*geoip.Response
+		if err := c.do(ctx, http.MethodPost, "/batch", fields, body, &batch); err != nil {
+			return nil, err
+		}
+		if len(batch) != len(chunk) {
+			return nil, fmt.Errorf("ipcontext: batch returned %d results for %d queries", len(batch), len(chunk))
+		}
+
+		for j, idx := range chunk {
+			results[idx] = batch[j]
+			if batch[j].Status == "success" {
+				c.cacheSet(cacheKey(ips[idx], fields), batch[j])
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, fields []string, body []byte, out interface{}) error {
+	u := *c.baseURL
+	u.Path += path
+	if len(fields) > 0 {
+		u.RawQuery = url.Values{"fields": {strings.Join(fields, ",")}}.Encode()
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			if err := c.sleep(ctx, attempt, lastErr); err != nil {
+				return err
+			}
+		}
+
+		retry, err := c.attempt(ctx, method, u.String(), body, out)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return err
+		}
+		lastErr = err
+	}
+
+	var ra *retryAfterError
+	if errors.As(lastErr, &ra) {
+		return ra.APIError
+	}
+	return lastErr
+}
+
+// attempt performs a single request and reports whether a failure is retryable.
+func (c *Client) attempt(ctx context.Context, method, target string, body []byte, out interface{}) (bool, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return false, fmt.Errorf("ipcontext: decode response: %w", err)
+		}
+		return false, nil
+	}
+
+	apiErr := &APIError{StatusCode: res.StatusCode}
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&payload) == nil {
+		apiErr.Message = payload.Message
+	}
+
+	retryable := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	if retryable {
+		return true, &retryAfterError{APIError: apiErr, after: parseRetryAfter(res.Header.Get("Retry-After"))}
+	}
+	return false, apiErr
+}
+
+// retryAfterError carries the server's Retry-After hint between attempts.
+type retryAfterError struct {
+	*APIError
+	after time.Duration
+}
+
+func (e *retryAfterError) Unwrap() error { return e.APIError }
+
+func (c *Client) sleep(ctx context.Context, attempt int, lastErr error) error {
+	t := time.NewTimer(c.backoff(attempt, lastErr))
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// backoff returns the delay before retry attempt, or the server's
+// Retry-After hint carried by lastErr when that is longer.
+func (c *Client) backoff(attempt int, lastErr error) time.Duration {
+	delay := c.minBackoff << (attempt - 1)
+	if delay > c.maxBackoff || delay <= 0 {
+		delay = c.maxBackoff
+	}
+	// Full jitter keeps many clients from retrying in lockstep.
+	if delay > 0 {
+		delay = time.Duration(rand.Int63n(int64(delay))) + 1
+	}
+
+	var ra *retryAfterError
+	if errors.As(lastErr, &ra) && ra.after > delay {
+		delay = ra.after
+	}
+	return delay
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func cacheKey(ip string, fields []string) string {
+	return ip + "|" + strings.Join(fields, ",")
+}
+
+func (c *Client) cacheGet(key string) (*geoip.Response, bool) {
+	if c.cache == nil {
+		return nil, false
+	}
+	return c.cache.get(key)
+}
+
+func (c *Client) cacheSet(key string, resp *geoip.Response) {
+	if c.cache != nil {
+		c.cache.set(key, resp)
+	}
+}
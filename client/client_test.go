@@ -0,0 +1,223 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/client/clienttest"
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+func newTestClient(t *testing.T, opts ...Option) (*Client, *clienttest.Server) {
+	t.Helper()
+	fake := clienttest.NewServer()
+	t.Cleanup(fake.Close)
+
+	c, err := New(fake.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, fake
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		failures []int
+		status   int // of the returned APIError, 0 for success
+		requests int
+	}{
+		{name: "retried until success", retries: 2, failures: []int{429, 503}, requests: 3},
+		{name: "retries exhausted", retries: 2, failures: []int{429, 500, 502}, status: 502, requests: 3},
+		{name: "client error is not retried", retries: 2, failures: []int{400}, status: 400, requests: 1},
+		{name: "retries disabled", retries: 0, failures: []int{503}, status: 503, requests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, fake := newTestClient(t, WithRetries(tt.retries, time.Millisecond, 2*time.Millisecond))
+			fake.Add(&geoip.Response{Query: "8.8.8.8", CountryCode: "US"})
+			fake.FailNext(tt.failures...)
+
+			resp, err := c.Lookup(context.Background(), "8.8.8.8")
+			if tt.status == 0 {
+				if err != nil || resp.CountryCode != "US" {
+					t.Fatalf("Lookup() = %+v, %v, want the US response", resp, err)
+				}
+			} else {
+				apiErr, ok := err.(*APIError)
+				if !ok || apiErr.StatusCode != tt.status || apiErr.Message != http.StatusText(tt.status) {
+					t.Fatalf("Lookup() error = %#v, want an *APIError with status %d", err, tt.status)
+				}
+			}
+			if got := fake.Requests(); got != tt.requests {
+				t.Errorf("%d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	c, fake := newTestClient(t, WithRetries(1, time.Millisecond, 2*time.Millisecond))
+	fake.FailNext(http.StatusTooManyRequests)
+	fake.SetRetryAfter("3")
+
+	retry, err := c.attempt(context.Background(), http.MethodGet, fake.URL+"/8.8.8.8", nil, new(geoip.Response))
+	var ra *retryAfterError
+	if !retry || !errors.As(err, &ra) || ra.after != 3*time.Second {
+		t.Fatalf("attempt() = %v, %#v, want a retryable error waiting 3s", retry, err)
+	}
+	// The hint wins over the much shorter backoff.
+	if got := c.backoff(1, err); got != 3*time.Second {
+		t.Errorf("backoff() = %v, want 3s", got)
+	}
+
+	// A cancelled context ends the wait.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.sleep(ctx, 1, err); !errors.Is(err, context.Canceled) {
+		t.Errorf("sleep() = %v, want context.Canceled", err)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	c, _ := newTestClient(t, WithRetries(5, 100*time.Millisecond, time.Second))
+
+	for attempt, ceiling := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		40: time.Second, // the shift overflows
+	} {
+		lowest, highest := ceiling, time.Duration(0)
+		for i := 0; i < 1000; i++ {
+			d := c.backoff(attempt, errors.New("network"))
+			if d <= 0 || d > ceiling {
+				t.Fatalf("attempt %d: backoff() = %v, want within (0, %v]", attempt, d, ceiling)
+			}
+			lowest, highest = min(lowest, d), max(highest, d)
+		}
+		if lowest > ceiling/4 || highest < ceiling*3/4 {
+			t.Errorf("attempt %d: delays between %v and %v, want them spread over (0, %v]", attempt, lowest, highest, ceiling)
+		}
+	}
+
+	c.minBackoff, c.maxBackoff = 0, 0
+	if d := c.backoff(1, nil); d != 0 {
+		t.Errorf("backoff() without delays = %v, want 0", d)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{value: ""},
+		{value: "2", min: 2 * time.Second, max: 2 * time.Second},
+		{value: "0"},
+		{value: "-5"},
+		{value: "soon"},
+		{value: time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), min: 8 * time.Second, max: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want within [%v, %v]", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	c, fake := newTestClient(t, WithCache(10, time.Minute))
+	fake.Add(&geoip.Response{Query: "8.8.8.8", CountryCode: "US", Languages: []string{"en"}})
+	fake.Add(&geoip.Response{Query: "1.1.1.1", CountryCode: "AU"})
+	ctx := context.Background()
+
+	first, err := c.Lookup(ctx, "8.8.8.8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.CountryCode = "XX"
+	first.Languages[0] = "xx"
+
+	again, err := c.Lookup(ctx, "8.8.8.8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.CountryCode != "US" || again.Languages[0] != "en" {
+		t.Errorf("cached response = %+v, changed through an earlier copy", again)
+	}
+	if fake.Requests() != 1 {
+		t.Errorf("%d requests, want the second lookup served from the cache", fake.Requests())
+	}
+
+	// Other fields are another cache entry; the batch asks only for the miss.
+	if _, err := c.Lookup(ctx, "8.8.8.8", "country"); err != nil {
+		t.Fatal(err)
+	}
+	results, err := c.Batch(ctx, []string{"8.8.8.8", "1.1.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].CountryCode != "US" || results[1].CountryCode != "AU" {
+		t.Errorf("Batch() = %+v, %+v", results[0], results[1])
+	}
+	if fake.Requests() != 3 {
+		t.Errorf("%d requests, want 3", fake.Requests())
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Self(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.Requests() != 5 {
+		t.Errorf("%d requests, want Self never cached", fake.Requests())
+	}
+}
+
+func TestLRU(t *testing.T) {
+	c := newLRU(2, time.Minute)
+
+	in := &geoip.Response{Query: "a", Languages: []string{"en"}}
+	c.set("a", in)
+	in.Languages[0] = "xx"
+	in.Query = "changed"
+
+	got, ok := c.get("a")
+	if !ok || got.Query != "a" || got.Languages[0] != "en" {
+		t.Fatalf("get(a) = %+v, %v, want the value as it was set", got, ok)
+	}
+	got.Languages[0] = "yy"
+	if again, _ := c.get("a"); again.Languages[0] != "en" {
+		t.Errorf("get(a) = %+v, changed through a returned copy", again)
+	}
+
+	c.set("b", &geoip.Response{Query: "b"})
+	c.get("a") // a is now more recent than b
+	c.set("c", &geoip.Response{Query: "c"})
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used entry b kept")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("recently used entry a evicted")
+	}
+
+	c.set("a", &geoip.Response{Query: "a2"})
+	if got, _ := c.get("a"); got.Query != "a2" {
+		t.Errorf("get(a) = %q after overwrite, want a2", got.Query)
+	}
+
+	expiring := newLRU(2, time.Millisecond)
+	expiring.set("a", &geoip.Response{Query: "a"})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := expiring.get("a"); ok || expiring.ll.Len() != 0 {
+		t.Error("expired entry returned or kept")
+	}
+}
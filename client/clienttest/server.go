@@ -0,0 +1,175 @@
+// Package clienttest provides an in-memory fake of the IpContext HTTP API so
+// code using the client package can be tested without MaxMind databases.
+//
+//	fake := clienttest.NewServer()
+//	defer fake.Close()
+//	fake.Add(&geoip.Response{Query: "8.8.8.8", Status: "success", CountryCode: "US"})
+//	c, _ := client.New(fake.URL)
+package clienttest
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+// Server is a fake IpContext server backed by canned responses.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	responses  map[string]*geoip.Response
+	selfIP     string
+	failures   []int
+	retryAfter string
+
+	requests atomic.Int64
+}
+
+// NewServer starts a fake server. Call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		responses: make(map[string]*geoip.Response),
+		selfIP:    "127.0.0.1",
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Add registers a canned response, keyed by its Query. Unknown but valid
+// addresses are answered with an empty successful response.
+func (s *Server) Add(resp *geoip.Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if resp.Status == "" {
+		resp.Status = "success"
+	}
+	s.responses[resp.Query] = resp
+}
+
+// SetSelfIP sets the address reported for GET /.
+func (s *Server) SetSelfIP(ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.selfIP = ip
+}
+
+// FailNext makes the next requests fail with the given HTTP status codes, in order.
+func (s *Server) FailNext(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+// SetRetryAfter sets the Retry-After header of the failures FailNext
+// injects, e.g. "2"; empty leaves it out.
+func (s *Server) SetRetryAfter(v string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retryAfter = v
+}
+
+// Requests returns the number of HTTP requests received so far.
+func (s *Server) Requests() int {
+	return int(s.requests.Load())
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+
+	s.mu.Lock()
+	var fail int
+	if len(s.failures) > 0 {
+		fail, s.failures = s.failures[0], s.failures[1:]
+	}
+	retryAfter := s.retryAfter
+	s.mu.Unlock()
+
+	if fail != 0 {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		writeJSON(w, fail, map[string]string{"status": "fail", "message": http.StatusText(fail)})
+		return
+	}
+
+	fields := geoip.ParseFields(r.URL.Query().Get("fields"))
+
+	switch {
+	case r.URL.Path == "/batch" && r.Method == http.MethodPost:
+		s.handleBatch(w, r, fields)
+	case r.Method == http.MethodGet:
+		ip := strings.TrimPrefix(r.URL.Path, "/")
+		if ip == "" {
+			s.mu.Lock()
+			ip = s.selfIP
+			s.mu.Unlock()
+		}
+		if net.ParseIP(ip) == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"status": "fail", "message": "Invalid IP address"})
+			return
+		}
+		writeJSON(w, http.StatusOK, s.lookup(ip, fields))
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"status": "fail", "message": "Method not allowed"})
+	}
+}
+
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request, fields []string) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"status": "fail", "message": "Invalid batch request body"})
+		return
+	}
+
+	out := make([]interface{}, 0, len(raw))
+	for _, item := range raw {
+		var q struct {
+			Query  string `json:"query"`
+			Fields string `json:"fields"`
+		}
+		if json.Unmarshal(item, &q.Query) != nil {
+			json.Unmarshal(item, &q)
+		}
+
+		if net.ParseIP(q.Query) == nil {
+			out = append(out, &geoip.Response{Query: q.Query, Status: "fail", Message: "invalid query"})
+			continue
+		}
+
+		f := fields
+		if q.Fields != "" {
+			f = geoip.ParseFields(q.Fields)
+		}
+		out = append(out, s.lookup(q.Query, f))
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) lookup(ip string, fields []string) interface{} {
+	s.mu.Lock()
+	resp, ok := s.responses[ip]
+	s.mu.Unlock()
+
+	if !ok {
+		resp = &geoip.Response{Query: ip, Status: "success"}
+	}
+	if len(fields) > 0 {
+		return resp.Select(fields)
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
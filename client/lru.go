@@ -0,0 +1,83 @@
+package client
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+// lru is a size-bounded cache with per-entry expiry. It stores and returns
+// copies, so callers may modify the responses they get.
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   *geoip.Response
+	expires time.Time
+}
+
+func newLRU(size int, ttl time.Duration) *lru {
+	return &lru{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *lru) get(key string) (*geoip.Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*lruEntry)
+	if c.ttl > 0 && time.Now().After(e.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	return cloneResponse(e.value), true
+}
+
+func (c *lru) set(key string, value *geoip.Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value = cloneResponse(value)
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*lruEntry)
+		e.value, e.expires = value, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func cloneResponse(r *geoip.Response) *geoip.Response {
+	out := *r
+	out.Neighbours = slices.Clone(r.Neighbours)
+	out.Languages = slices.Clone(r.Languages)
+	return &out
+}
@@ -0,0 +1,294 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Header names the resolver can take the client address from.
+const (
+	HeaderXForwardedFor  = "x-forwarded-for"
+	HeaderForwarded      = "forwarded"
+	HeaderCFConnectingIP = "cf-connecting-ip"
+	HeaderXRealIP        = "x-real-ip"
+)
+
+// Headers lists the supported header names.
+var Headers = []string{HeaderXForwardedFor, HeaderForwarded, HeaderCFConnectingIP, HeaderXRealIP}
+
+// Resolver extracts the client IP from a request, honouring a forwarding
+// header only when the peer is a trusted proxy.
+type Resolver struct {
+	trusted   []*net.IPNet
+	header    string
+	trustUnix bool
+}
+
+// Options configures a Resolver.
+type Options struct {
+	Trusted []*net.IPNet // proxy networks allowed to set Header
+	Header  string       // one of Headers; empty always uses the peer address
+
+	// TrustUnixSockets lets peers on unix sockets, which have no address,
+	// set Header like trusted proxies.
+	TrustUnixSockets bool
+}
+
+// NewResolver returns a resolver trusting the given proxy networks to set
+// header, one of Headers. Only that header is read: a proxy that sets one
+// header passes the others through from the client, so they cannot be
+// believed. With no header or no trusted networks, the peer address is
+// always the client.
+func NewResolver(trusted []*net.IPNet, header string) *Resolver {
+	return New(Options{Trusted: trusted, Header: header})
+}
+
+// New returns a resolver for opts, see NewResolver.
+func New(opts Options) *Resolver {
+	header := strings.ToLower(strings.TrimSpace(opts.Header))
+	if !isKnownHeader(header) {
+		header = ""
+	}
+	return &Resolver{trusted: opts.Trusted, header: header, trustUnix: opts.TrustUnixSockets}
+}
+
+// CheckHeader reports an unsupported header name; empty is allowed.
+func CheckHeader(header string) error {
+	if header = strings.ToLower(strings.TrimSpace(header)); header != "" && !isKnownHeader(header) {
+		return fmt.Errorf("unsupported client IP header %q (supported: %s)", header, strings.Join(Headers, ", "))
+	}
+	return nil
+}
+
+func isKnownHeader(h string) bool {
+	for _, known := range Headers {
+		if h == known {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerTrusted reports whether the directly connected peer of req may set
+// the forwarding header.
+func (r *Resolver) PeerTrusted(req *http.Request) bool {
+	if r.header == "" {
+		return false
+	}
+	if peer := PeerIP(req); peer != nil {
+		return r.IsTrusted(peer)
+	}
+	// Peers on unix sockets have no address.
+	return r.trustUnix
+}
+
+// IsTrusted reports whether ip belongs to a trusted proxy network.
+func (r *Resolver) IsTrusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range r.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that originated the request.
+//
+// The header is only consulted when the peer is a trusted proxy. Chain
+// headers (X-Forwarded-For, Forwarded) are walked from right to left and
+// the first hop that is not a trusted proxy is the client. If every hop is
+// trusted, the left-most address is returned.
+//
+// Peers on unix sockets have no IP. Unless they are trusted and send the
+// header, the result is nil.
+func (r *Resolver) ClientIP(req *http.Request) net.IP {
+	_, hops := r.Chain(req)
+	if i := r.ClientHop(req, hops); i < len(hops) {
+		return net.ParseIP(hops[i])
+	}
+	return PeerIP(req)
+}
+
+// ClientHop returns the index in hops, the chain returned by Chain, of the
+// hop ClientIP picks as the client, or len(hops) when it picks the peer.
+// Every hop right of the client is a trusted proxy; hops left of it may
+// have been made up by the client.
+func (r *Resolver) ClientHop(req *http.Request, hops []string) int {
+	if len(hops) == 0 || !r.PeerTrusted(req) {
+		return len(hops)
+	}
+
+	switch r.header {
+	case HeaderXForwardedFor, HeaderForwarded:
+		return r.walk(hops)
+	case HeaderCFConnectingIP, HeaderXRealIP:
+		if net.ParseIP(hops[0]) != nil {
+			return 0
+		}
+	}
+	return len(hops)
+}
+
+// Chain returns the forwarding chain the resolver would use for req: the
+// configured header and its hops from left (original client) to right
+// (closest proxy). Single-address headers give a one-hop chain. The peer is
+// not included.
+func (r *Resolver) Chain(req *http.Request) (string, []string) {
+	switch r.header {
+	case HeaderXForwardedFor:
+		if hops := ForwardedFor(req.Header); len(hops) > 0 {
+			return r.header, hops
+		}
+	case HeaderForwarded:
+		if hops := Forwarded(req.Header); len(hops) > 0 {
+			return r.header, hops
+		}
+	case HeaderCFConnectingIP, HeaderXRealIP:
+		if v := strings.TrimSpace(req.Header.Get(r.header)); v != "" {
+			return r.header, []string{v}
+		}
+	}
+	return "", nil
+}
+
+// walk returns the index of the right-most hop that is not a trusted proxy,
+// the left-most hop if all are trusted, or len(hops) if the right-most hop
+// is not an address.
+func (r *Resolver) walk(hops []string) int {
+	client := len(hops)
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			// Garbage or an obfuscated node: stop at the last address we could trust.
+			break
+		}
+		client = i
+		if !r.IsTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// PeerIP returns the address of the directly connected peer.
+func PeerIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// ForwardedFor returns all X-Forwarded-For entries in order, across
+// repeated headers.
+func ForwardedFor(h http.Header) []string {
+	var hops []string
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				hops = append(hops, stripPort(part))
+			}
+		}
+	}
+	return hops
+}
+
+// Forwarded returns the "for" node of every RFC 7239 Forwarded element in
+// order, with ports and IPv6 brackets removed. Nodes that are not addresses
+// ("unknown", obfuscated identifiers) are returned as-is.
+func Forwarded(h http.Header) []string {
+	var hops []string
+	for _, v := range h.Values("Forwarded") {
+		for _, element := range splitQuoted(v, ',') {
+			for _, pair := range splitQuoted(element, ';') {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "for") {
+					continue
+				}
+				value = strings.Trim(strings.TrimSpace(value), `"`)
+				hops = append(hops, stripPort(value))
+			}
+		}
+	}
+	return hops
+}
+
+// splitQuoted splits s on sep, ignoring separators inside double quotes.
+func splitQuoted(s string, sep byte) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// stripPort removes an optional port and IPv6 brackets from a node.
+func stripPort(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.IndexByte(node, ']'); end > 0 {
+			return node[1:end]
+		}
+		return node
+	}
+	// A single colon means IPv4 with a port; bare IPv6 has several.
+	if strings.Count(node, ":") == 1 {
+		host, _, _ := strings.Cut(node, ":")
+		return host
+	}
+	return node
+}
+
+// ParseCIDR accepts either a CIDR or a bare address.
+func ParseCIDR(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", s)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", s)
+	}
+	return n, nil
+}
+
+// ParseCIDRs parses a list of CIDRs or bare addresses, skipping empty entries.
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, s := range list {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		n, err := ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
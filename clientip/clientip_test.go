@@ -0,0 +1,268 @@
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func mustCIDRs(t *testing.T, list ...string) []*net.IPNet {
+	t.Helper()
+	nets, err := ParseCIDRs(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nets
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "2001:db8:ffff::/48"}
+
+	tests := []struct {
+		name    string
+		header  string
+		peer    string
+		headers map[string][]string
+		want    string
+	}{
+		{
+			name:    "untrusted peer ignores headers",
+			header:  HeaderXForwardedFor,
+			peer:    "198.51.100.7:4000",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "no header configured ignores headers",
+			header:  "",
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1"}},
+			want:    "10.0.0.1",
+		},
+		{
+			name:   "trusted peer without header",
+			header: HeaderXForwardedFor,
+			peer:   "10.0.0.1:4000",
+			want:   "10.0.0.1",
+		},
+		{
+			name:    "single hop",
+			header:  HeaderXForwardedFor,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1"}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "spoofed left hops are skipped",
+			header:  HeaderXForwardedFor,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"X-Forwarded-For": {"1.1.1.1, 203.0.113.1, 10.0.0.2"}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "repeated headers are one list",
+			header:  HeaderXForwardedFor,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"X-Forwarded-For": {"1.1.1.1", "203.0.113.1, 10.0.0.2"}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "every hop trusted returns left-most",
+			header:  HeaderXForwardedFor,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			want:    "10.0.0.3",
+		},
+		{
+			name:    "garbage stops at last good hop",
+			header:  HeaderXForwardedFor,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1, bogus, 10.0.0.2"}},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "garbage right of peer keeps peer",
+			header:  HeaderXForwardedFor,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1, bogus"}},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "port is stripped",
+			header:  HeaderXForwardedFor,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1:5555"}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "IPv6 hops",
+			header:  HeaderXForwardedFor,
+			peer:    "[2001:db8:ffff::1]:4000",
+			headers: map[string][]string{"X-Forwarded-For": {"2001:db8::1, 2001:db8:ffff::2"}},
+			want:    "2001:db8::1",
+		},
+		{
+			name:   "XFF ignored when Forwarded is configured",
+			header: HeaderForwarded,
+			peer:   "10.0.0.1:4000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"1.1.1.1"},
+				"Forwarded":       {"for=203.0.113.1"},
+			},
+			want: "203.0.113.1",
+		},
+		{
+			name:    "Forwarded ignored when XFF is configured",
+			header:  HeaderXForwardedFor,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"Forwarded": {"for=1.1.1.1"}},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "Forwarded walk",
+			header:  HeaderForwarded,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"Forwarded": {`for=1.1.1.1, for=203.0.113.1;proto=https, for="10.0.0.2:80"`}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "Forwarded quoted IPv6 with port",
+			header:  HeaderForwarded,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"Forwarded": {`for="[2001:db8::1]:4711"`}},
+			want:    "2001:db8::1",
+		},
+		{
+			name:    "Forwarded obfuscated node stops the walk",
+			header:  HeaderForwarded,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"Forwarded": {"for=203.0.113.1, for=_hidden, for=10.0.0.2"}},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "Forwarded separators inside quotes",
+			header:  HeaderForwarded,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"Forwarded": {`for=203.0.113.1;by="a,b;c"`}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "X-Real-IP",
+			header:  HeaderXRealIP,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"X-Real-Ip": {"203.0.113.1"}, "X-Forwarded-For": {"1.1.1.1"}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "invalid CF-Connecting-IP keeps peer",
+			header:  HeaderCFConnectingIP,
+			peer:    "10.0.0.1:4000",
+			headers: map[string][]string{"Cf-Connecting-Ip": {"nope"}},
+			want:    "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewResolver(mustCIDRs(t, trusted...), tt.header)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			for k, vs := range tt.headers {
+				for _, v := range vs {
+					req.Header.Add(k, v)
+				}
+			}
+
+			if got := r.ClientIP(req); got.String() != tt.want {
+				t.Errorf("ClientIP() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwarded(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"for=192.0.2.60;proto=http;by=203.0.113.43", []string{"192.0.2.60"}},
+		{`For="[2001:db8:cafe::17]:4711"`, []string{"2001:db8:cafe::17"}},
+		{"for=192.0.2.43, for=198.51.100.17", []string{"192.0.2.43", "198.51.100.17"}},
+		{"for=unknown", []string{"unknown"}},
+		{"proto=https", nil},
+	}
+
+	for _, tt := range tests {
+		h := http.Header{}
+		h.Set("Forwarded", tt.value)
+		if got := Forwarded(h); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Forwarded(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestChainUsesConfiguredHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	req.Header.Set("X-Real-Ip", "203.0.113.1")
+
+	source, hops := NewResolver(nil, HeaderXRealIP).Chain(req)
+	if source != HeaderXRealIP || !reflect.DeepEqual(hops, []string{"203.0.113.1"}) {
+		t.Errorf("Chain() = %s %q, want %s [203.0.113.1]", source, hops, HeaderXRealIP)
+	}
+}
+
+func TestClientHop(t *testing.T) {
+	r := NewResolver(mustCIDRs(t, "10.0.0.0/8"), HeaderXForwardedFor)
+
+	tests := []struct {
+		peer string
+		xff  string
+		want int
+	}{
+		{peer: "198.51.100.7:4000", xff: "203.0.113.1, 10.0.0.2", want: 2},
+		{peer: "10.0.0.1:4000", xff: "203.0.113.1, 10.0.0.2", want: 0},
+		{peer: "10.0.0.1:4000", xff: "198.51.100.9, 203.0.113.1, 10.0.0.2", want: 1},
+		{peer: "10.0.0.1:4000", xff: "10.0.0.3, 10.0.0.2", want: 0},
+		{peer: "10.0.0.1:4000", xff: "203.0.113.1, unknown", want: 2},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.peer
+		req.Header.Set("X-Forwarded-For", tt.xff)
+
+		_, hops := r.Chain(req)
+		if got := r.ClientHop(req, hops); got != tt.want {
+			t.Errorf("ClientHop() from %s with %q = %d, want %d", tt.peer, tt.xff, got, tt.want)
+		}
+	}
+}
+
+func TestCheckHeader(t *testing.T) {
+	for _, h := range []string{"", "X-Forwarded-For", " forwarded "} {
+		if err := CheckHeader(h); err != nil {
+			t.Errorf("CheckHeader(%q) = %v", h, err)
+		}
+	}
+	if err := CheckHeader("x-client-ip"); err == nil {
+		t.Error("CheckHeader(x-client-ip) succeeded")
+	}
+}
+
+func TestClientIPUnixSocket(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "@"
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+
+	if got := NewResolver(nil, HeaderXForwardedFor).ClientIP(req); got != nil {
+		t.Errorf("untrusted unix peer: ClientIP() = %v, want nil", got)
+	}
+
+	r := New(Options{Header: HeaderXForwardedFor, TrustUnixSockets: true})
+	if got := r.ClientIP(req); got.String() != "203.0.113.1" {
+		t.Errorf("trusted unix peer: ClientIP() = %v, want 203.0.113.1", got)
+	}
+}
@@ -1,65 +1,423 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"net/url"
 	"os"
-	"strconv"
+	"strings"
 )
 
-// Config holds application configuration loaded from env and flags.
+// Config holds application configuration loaded from a config file, env
+// and flags.
 type Config struct {
-	ListenAddr string
+	File                string // YAML config file, from -config or CONFIG_FILE; empty when none
+	ConfigReloadSeconds int    // how often File is checked for changes; 0 leaves reloads to SIGHUP
+
+	ListenAddr string // host:port, unix:///path or systemd:[name]
+	SocketMode string // octal permissions for unix sockets
 	DBPath     string
 	LogLevel   string
-	LogFormat  string // json | console
-	LogTimeFmt string // Go layout or aliases handled by logx
+	LogFormat  string   // json | console
+	LogTimeFmt string   // Go layout or aliases handled by logx
+	LogOutputs []string // logx sink specs; empty logs to stdout
+
+	DBReloadSeconds int // how often DB_PATH is checked for new or updated databases; 0 disables
+
+	PrivacyMode              string // off | truncate | hash
+	PrivacyIPv4Prefix        int
+	PrivacyIPv6Prefix        int
+	PrivacyHashRotationHours int
+
+	PrecisionProfile      string   // default response precision profile
+	PrecisionRoutes       []string // route=profile pairs
+	PrecisionProfilesFile string   // custom profiles, JSON
 
-	GeoNamesUser         string
+	AccessLog           bool
+	AccessLogFields     []string // empty logs the default fields
+	AccessLogSampleRate float64  // fraction of requests logged
+
+	GeoNamesUser          string
 	NeighboursUpdateHours int
 	LanguagesUpdateHours  int
-	CacheTTLMinutes      int
+	CacheTTLMinutes       int
+
+	CORSAllowedOrigins []string // "*" allows any origin; empty disables CORS
+	CORSMaxAgeSeconds  int      // how long browsers may cache preflight results; 0 omits it
+
+	DNSListenAddr string // empty disables the GeoDNS responder
+	DNSRulesFile  string
+
+	AccessRulesFile     string // empty disables the /auth endpoint
+	AccessReloadSeconds int
+
+	APIKeysFile          string // empty disables API key checks
+	APIKeysRequired      bool   // reject callers without a key
+	APIKeysReloadSeconds int
+
+	UsageDBFile       string // empty disables usage metering
+	UsageFlushSeconds int
+
+	MetricsListenAddr string // empty disables /metrics
+
+	AdminListenAddr   string   // empty disables the admin API
+	AdminAllowedCIDRs []string // peers allowed to call the admin API; empty allows any
+	AdminPprof        bool     // serve /debug/pprof/ on the admin listener
+
+	ReadyMaxDBAgeDays        int // 0 disables the database age check
+	ReadyMinGeoNamesCoverage int // percent of countries each store needs, 0 disables
+
+	TracingExporter    string // otlp | stdout | none
+	TracingEndpoint    string // OTLP/HTTP endpoint; OTEL_EXPORTER_OTLP_* apply when empty
+	TracingSampleRatio float64
+
+	MaxInFlight         int // 0 disables load shedding
+	AdaptiveConcurrency bool
+	TargetLatencyMs     int
+	MinInFlight         int
+
+	RateLimitIP         string // token bucket specs such as "10/s:20"; empty disables
+	RateLimitCIDR       string
+	RateLimitKey        string
+	RateLimitIPv4Prefix int
+	RateLimitIPv6Prefix int
+	RateLimitMaxEntries int
+
+	ProxyUpstream   string // empty disables the geo reverse proxy
+	ProxyListenAddr string
+	ProxyHeaders    string // Header=field pairs, comma separated
+
+	TrustedProxies []string // CIDRs allowed to set client IP headers
+	ClientIPHeader string   // the header trusted proxies set, see clientip.Headers; empty uses the peer
+
+	TrustUnixSockets bool // unix socket peers may set ClientIPHeader and send PROXY headers
+
+	ProxyProtocolListeners []string // listeners accepting PROXY protocol: api, tls, proxy
+	ProxyProtocolTrusted   []string // CIDRs allowed to send PROXY headers
+
+	TLSListenAddr    string // empty disables HTTPS
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSClientCAFile  string // enables mTLS
+	TLSClientAuth    string // require | verify-if-given
+	TLSMinVersion    string
+	TLSReloadSeconds int
+
+	raw     map[string]string // effective value of each setting by key
+	sources map[string]string // where each value in raw comes from
+	flags   map[string]string // values given as flags, by key, kept for Reload
+	checks  Checks            // kept for Reload
+}
+
+// Checks validates settings whose formats belong to the packages that use
+// them. The caller supplies them, so that config only deals in plain values.
+// Nil checks are skipped.
+type Checks struct {
+	LogOutputs      func(specs []string) error  // LOG_OUTPUTS
+	AccessLogFields func(fields []string) error // ACCESS_LOG_FIELDS
+	TracingEndpoint func(endpoint string) error // TRACING_OTLP_ENDPOINT
+	RateLimit       func(spec string) error     // RATE_LIMIT_IP, RATE_LIMIT_CIDR and RATE_LIMIT_KEY
+	ProxyHeaders    func(spec string) error     // PROXY_HEADERS
+
+	// PrecisionRoutes are the routes PRECISION_ROUTES may name.
+	PrecisionRoutes []string
+
+	// Precision checks the profiles and the route to profile map.
+	Precision func(profilesFile, profile string, routes map[string]string) error
+
+	// TLS checks the certificate, key and client CA files while
+	// TLS_LISTEN_ADDR is set.
+	TLS func(certFile, keyFile, clientCAFile string) error
 }
 
-// Load reads environment variables and flags, applying sane defaults.
-// Flags override environment variables when provided.
-func Load() *Config {
-	cfg := &Config{
-		ListenAddr:            getEnv("LISTEN_ADDR", ":3280"),
-		DBPath:                getEnv("DB_PATH", "/data"),
-		LogLevel:              getEnv("LOG_LEVEL", "info"),
-		LogFormat:             getEnv("LOG_FORMAT", "console"),
-		LogTimeFmt:            getEnv("LOG_TIME_FORMAT", "2006-01-02 15:04:05"),
-		GeoNamesUser:          getEnv("GEONAMES_USERNAME", ""),
-		NeighboursUpdateHours: getEnvInt("NEIGHBOURS_UPDATE_HOURS", 168),
-		LanguagesUpdateHours:  getEnvInt("LANGUAGES_UPDATE_HOURS", 168),
-		CacheTTLMinutes:       getEnvInt("CACHE_TTL_MINUTES", 5),
+// Load parses the command line arguments, without the program name, and
+// loads the configuration. Values come from the config file, then env
+// variables, then flags, each overriding the previous; see settings. Every
+// invalid value is reported in the returned error, one per line. Flag
+// errors, including flag.ErrHelp for -h, are returned as they are.
+func Load(args []string, checks Checks) (*Config, error) {
+	fs := flag.NewFlagSet("ipcontext", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML config file (env CONFIG_FILE)")
+	byFlag := make(map[string]*setting, len(settings))
+	for i := range settings {
+		s := &settings[i]
+		byFlag[s.flagName()] = s
+		usage := fmt.Sprintf("%s (config file: %s)", s.env, s.key)
+		if s.secret {
+			usage = fmt.Sprintf("%s or %s_FILE (config file: %s)", s.env, s.env, s.key)
+		}
+		fs.Var(&flagValue{value: s.def, isBool: s.set.isBool}, s.flagName(), usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
 	}
 
-	// Define flags that can override env
-	listen := flag.String("listen", cfg.ListenAddr, "Address to listen on")
-	dbPath := flag.String("db-path", cfg.DBPath, "Path to GeoIP database files")
-	logLevel := flag.String("log-level", cfg.LogLevel, "Log level (debug, info, warn, error, fatal)")
-	flag.Parse()
+	flags := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if s, ok := byFlag[f.Name]; ok {
+			flags[s.key] = f.Value.String()
+		}
+	})
 
-	cfg.ListenAddr = *listen
-	cfg.DBPath = *dbPath
-	cfg.LogLevel = *logLevel
+	return load(*file, flags, checks)
+}
 
-	return cfg
+// Reload loads the configuration again from the same file and flags, e.g.
+// after the file has changed.
+func (c *Config) Reload() (*Config, error) {
+	return load(c.File, c.flags, c.checks)
 }
 
-func getEnv(key, def string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+// Sources of setting values, see Value.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceEnvFile = "env file" // read from the file named by <ENV>_FILE
+	SourceFlag    = "flag"
+)
+
+func load(file string, flags map[string]string, checks Checks) (*Config, error) {
+	var fromFile map[string]string
+	if file != "" {
+		var err error
+		if fromFile, err = readFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &Config{
+		File:    file,
+		raw:     make(map[string]string, len(settings)),
+		sources: make(map[string]string, len(settings)),
+		flags:   flags,
+		checks:  checks,
+	}
+	var errs []error
+	for i := range settings {
+		s := &settings[i]
+
+		v, source, err := lookup(s, file, fromFile, flags)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := s.set.apply(c, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s %q: %w", describe(s, source, file), v, err))
+			continue
+		}
+		c.raw[s.key], c.sources[s.key] = v, source
+	}
+	errs = append(errs, c.validate()...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
-	return def
+
+	return c, nil
 }
 
-func getEnvInt(key string, def int) int {
-	if v := os.Getenv(key); v != "" {
-		if i, err := strconv.Atoi(v); err == nil {
-			return i
+// validate checks settings that depend on each other. Checks involving a
+// setting that failed to load are skipped.
+func (c *Config) validate() []error {
+	var errs []error
+
+	// A PROXY header lets the peer claim any source address, so the peers
+	// allowed to send one are never implied.
+	if c.loaded("proxyProtocol.listeners", "proxyProtocol.trusted", "clientIP.trustUnixSockets") &&
+		len(c.ProxyProtocolListeners) > 0 && len(c.ProxyProtocolTrusted) == 0 && !c.TrustUnixSockets {
+		errs = append(errs, errors.New("PROXY_PROTOCOL is enabled: set PROXY_PROTOCOL_TRUSTED to the load balancers allowed to send PROXY headers"))
+	}
+
+	if c.loaded("accessLog.enabled", "accessLog.sampleRate") && c.AccessLog && c.AccessLogSampleRate == 0 {
+		errs = append(errs, c.invalid("accessLog.sampleRate", errors.New("must be greater than 0 while ACCESS_LOG is on")))
+	}
+
+	if c.loaded("admin.listen", "apiKeys.file", "admin.allowedCidrs") &&
+		c.AdminListenAddr != "" && c.APIKeysFile == "" && len(c.AdminAllowedCIDRs) == 0 && !strings.HasPrefix(c.AdminListenAddr, "unix://") {
+		errs = append(errs, errors.New("ADMIN_LISTEN_ADDR is set: the admin API needs API_KEYS_FILE or ADMIN_ALLOWED_CIDRS to restrict access"))
+	}
+
+	if check := c.checks.Precision; check != nil && c.loaded("precision.profile", "precision.routes", "precision.profilesFile") {
+		if err := check(c.PrecisionProfilesFile, c.PrecisionProfile, c.PrecisionRouteProfiles()); err != nil {
+			errs = append(errs, fmt.Errorf("invalid precision profiles: %w", err))
+		}
+	}
+
+	if check := c.checks.TLS; check != nil && c.loaded("tls.listen", "tls.certFile", "tls.keyFile", "tls.clientCaFile") && c.TLSListenAddr != "" {
+		if err := check(c.TLSCertFile, c.TLSKeyFile, c.TLSClientCAFile); err != nil {
+			errs = append(errs, fmt.Errorf("TLS_LISTEN_ADDR is set: %w", err))
 		}
 	}
-	return def
+
+	return errs
+}
+
+// PrecisionRouteProfiles returns PRECISION_ROUTES as a map from route to
+// profile name.
+func (c *Config) PrecisionRouteProfiles() map[string]string {
+	routes := make(map[string]string, len(c.PrecisionRoutes))
+	for _, pair := range c.PrecisionRoutes {
+		route, name, _ := strings.Cut(pair, "=")
+		routes[route] = name
+	}
+	return routes
+}
+
+// loaded reports whether the settings with keys were loaded without errors.
+func (c *Config) loaded(keys ...string) bool {
+	for _, key := range keys {
+		if _, ok := c.sources[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// invalid reports err for the value of the setting with key.
+func (c *Config) invalid(key string, err error) error {
+	for i := range settings {
+		if s := &settings[i]; s.key == key {
+			return fmt.Errorf("%s %q: %w", describe(s, c.sources[key], c.File), c.raw[key], err)
+		}
+	}
+	return err
+}
+
+// lookup returns the value of s and where it comes from.
+func lookup(s *setting, file string, fromFile, flags map[string]string) (v, source string, err error) {
+	if v, ok := flags[s.key]; ok {
+		return v, SourceFlag, nil
+	}
+
+	// An empty variable counts as unset.
+	if v := os.Getenv(s.env); v != "" {
+		if s.secret && os.Getenv(s.env+"_FILE") != "" {
+			return "", "", fmt.Errorf("env %s and %s_FILE are both set, use one", s.env, s.env)
+		}
+		return v, SourceEnv, nil
+	}
+	if path := os.Getenv(s.env + "_FILE"); s.secret && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("env %s_FILE: %w", s.env, err)
+		}
+		// Secret files usually end with a newline.
+		return strings.TrimSpace(string(data)), SourceEnvFile, nil
+	}
+
+	if v, ok := fromFile[s.key]; ok {
+		return v, SourceFile, nil
+	}
+	return s.def, SourceDefault, nil
+}
+
+// describe names where a value of s was set, for error messages.
+func describe(s *setting, source, file string) string {
+	switch source {
+	case SourceFlag:
+		return "flag -" + s.flagName()
+	case SourceEnv:
+		return "env " + s.env
+	case SourceEnvFile:
+		return "env " + s.env + "_FILE"
+	case SourceFile:
+		return fmt.Sprintf("%s in %s", s.key, file)
+	}
+	return "default of " + s.env
+}
+
+// Value is the effective value of one setting.
+type Value struct {
+	Key    string // config file key
+	Env    string
+	Value  string // secrets and URL credentials are redacted
+	Source string // SourceDefault, SourceFile, SourceEnv, SourceEnvFile or SourceFlag
+}
+
+// Values lists every setting in config file order, with its value and
+// source.
+func (c *Config) Values() []Value {
+	out := make([]Value, 0, len(settings))
+	for i := range settings {
+		s := &settings[i]
+		v := c.raw[s.key]
+		switch {
+		case s.secret && v != "":
+			v = redacted
+		case v != "":
+			items := strings.Split(v, ",")
+			for j := range items {
+				items[j] = redactURL(items[j])
+			}
+			v = strings.Join(items, ",")
+		}
+		out = append(out, Value{Key: s.key, Env: s.env, Value: v, Source: c.sources[s.key]})
+	}
+	return out
+}
+
+// Changes lists the settings that differ in next, by env variable name:
+// those that can be applied live, and those that need a restart.
+func (c *Config) Changes(next *Config) (live, restart []string) {
+	for i := range settings {
+		s := &settings[i]
+		if c.raw[s.key] == next.raw[s.key] {
+			continue
+		}
+		if s.live {
+			live = append(live, s.env)
+		} else {
+			restart = append(restart, s.env)
+		}
+	}
+	return live, restart
+}
+
+// flagValue is a string flag that can behave as a boolean one.
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (f *flagValue) String() string     { return f.value }
+func (f *flagValue) Set(v string) error { f.value = v; return nil }
+func (f *flagValue) IsBoolFlag() bool   { return f.isBool }
+
+// ProxyProtocolEnabled reports whether the named listener accepts PROXY protocol headers.
+func (c *Config) ProxyProtocolEnabled(listener string) bool {
+	for _, l := range c.ProxyProtocolListeners {
+		if strings.EqualFold(l, listener) || strings.EqualFold(l, "all") {
+			return true
+		}
+	}
+	return false
+}
+
+// redacted replaces secrets in Redacted.
+const redacted = "REDACTED"
+
+// Redacted returns a copy of c that is safe to display: secrets are replaced
+// and credentials are removed from URLs.
+func (c *Config) Redacted() *Config {
+	out := *c
+	if out.GeoNamesUser != "" {
+		out.GeoNamesUser = redacted
+	}
+	out.ProxyUpstream = redactURL(out.ProxyUpstream)
+	out.TracingEndpoint = redactURL(out.TracingEndpoint)
+	out.LogOutputs = make([]string, len(c.LogOutputs))
+	for i, spec := range c.LogOutputs {
+		out.LogOutputs[i] = redactURL(spec)
+	}
+	return &out
+}
+
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	u.User = url.User(redacted)
+	return u.String()
 }
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadErrors(t *testing.T) {
+	secret := writeFile(t, "geonames", "user\n")
+
+	tests := []struct {
+		name   string
+		env    map[string]string
+		args   []string
+		checks Checks
+		want   []string // one line of the error each
+	}{
+		{
+			name: "bad int",
+			env:  map[string]string{"DB_RELOAD_SECONDS": "often"},
+			want: []string{`env DB_RELOAD_SECONDS "often": must be a whole number`},
+		},
+		{
+			name: "bad int flag",
+			args: []string{"-cache-ttl-minutes=-1"},
+			want: []string{`flag -cache-ttl-minutes "-1": must be 0 or more`},
+		},
+		{
+			name: "bad enum",
+			env:  map[string]string{"LOG_FORMAT": "jsn"},
+			want: []string{`env LOG_FORMAT "jsn": must be one of console, json`},
+		},
+		{
+			name: "several errors",
+			env: map[string]string{
+				"LOG_FORMAT":          "jsn",
+				"PRIVACY_IPV4_PREFIX": "33",
+				"UNIX_SOCKET_MODE":    "01777",
+				"TRUSTED_PROXIES":     "10.0.0.0/33",
+			},
+			want: []string{
+				`env LOG_FORMAT "jsn": must be one of console, json`,
+				`env PRIVACY_IPV4_PREFIX "33": must be between 0 and 32`,
+				`env UNIX_SOCKET_MODE "01777": must be octal permissions such as 0660`,
+				`env TRUSTED_PROXIES "10.0.0.0/33": invalid CIDR "10.0.0.0/33"`,
+			},
+		},
+		{
+			name: "secret and secret file",
+			env:  map[string]string{"GEONAMES_USERNAME": "user", "GEONAMES_USERNAME_FILE": secret},
+			want: []string{"env GEONAMES_USERNAME and GEONAMES_USERNAME_FILE are both set, use one"},
+		},
+		{
+			name: "cross-field check",
+			env:  map[string]string{"PROXY_PROTOCOL": "api"},
+			want: []string{"PROXY_PROTOCOL is enabled: set PROXY_PROTOCOL_TRUSTED"},
+		},
+		{
+			name:   "injected check",
+			env:    map[string]string{"RATE_LIMIT_IP": "fast", "RATE_LIMIT_KEY": "10/s"},
+			checks: Checks{RateLimit: func(spec string) error { return errors.New("bad limit " + spec) }},
+			want:   []string{`env RATE_LIMIT_IP "fast": bad limit fast`, `env RATE_LIMIT_KEY "10/s": bad limit 10/s`},
+		},
+		{
+			name:   "precision route",
+			env:    map[string]string{"PRECISION_ROUTES": "/nowhere=city"},
+			checks: Checks{PrecisionRoutes: []string{"/", "/batch"}},
+			want:   []string{`env PRECISION_ROUTES "/nowhere=city": entry "/nowhere=city" must be route=profile, with route one of /, /batch`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			_, err := Load(tt.args, tt.checks)
+			if err == nil {
+				t.Fatal("Load() succeeded")
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.want) {
+				t.Errorf("Load() reported %d errors, want %d:\n%v", len(lines), len(tt.want), err)
+			}
+			for _, want := range tt.want {
+				found := false
+				for _, line := range lines {
+					found = found || strings.HasPrefix(line, want)
+				}
+				if !found {
+					t.Errorf("Load() error is missing %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadSkipsNilChecks(t *testing.T) {
+	t.Setenv("RATE_LIMIT_IP", "fast")
+	t.Setenv("ACCESS_LOG_FIELDS", "anything")
+
+	c, err := Load(nil, Checks{})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if c.RateLimitIP != "fast" || len(c.AccessLogFields) != 1 {
+		t.Errorf("values not loaded: %q, %q", c.RateLimitIP, c.AccessLogFields)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", "log:\n  level: warn\n  format: json\ncache:\n  ttlMinutes: 7\nserver:\n  socketMode: 0600\n")
+
+	tests := []struct {
+		name   string
+		env    map[string]string
+		args   []string
+		level  string
+		source string
+	}{
+		{name: "default", level: "info", source: SourceDefault},
+		{name: "file", args: []string{"-config", file}, level: "warn", source: SourceFile},
+		{name: "env over file", env: map[string]string{"CONFIG_FILE": file, "LOG_LEVEL": "error"}, level: "error", source: SourceEnv},
+		{name: "flag over env", env: map[string]string{"CONFIG_FILE": file, "LOG_LEVEL": "error"}, args: []string{"-log-level", "debug"}, level: "debug", source: SourceFlag},
+		{name: "empty env is unset", env: map[string]string{"CONFIG_FILE": file, "LOG_LEVEL": ""}, level: "warn", source: SourceFile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			c, err := Load(tt.args, Checks{})
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if c.LogLevel != tt.level {
+				t.Errorf("LogLevel = %q, want %q", c.LogLevel, tt.level)
+			}
+			for _, v := range c.Values() {
+				if v.Env == "LOG_LEVEL" && v.Source != tt.source {
+					t.Errorf("LOG_LEVEL source = %s, want %s", v.Source, tt.source)
+				}
+			}
+			if c.File != "" && (c.LogFormat != "json" || c.CacheTTLMinutes != 7 || c.SocketMode != "0600") {
+				t.Errorf("file values not loaded: %q, %d, %q", c.LogFormat, c.CacheTTLMinutes, c.SocketMode)
+			}
+		})
+	}
+}
+
+func TestSecretFile(t *testing.T) {
+	t.Setenv("GEONAMES_USERNAME_FILE", writeFile(t, "geonames", "  user\n"))
+
+	c, err := Load(nil, Checks{})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if c.GeoNamesUser != "user" {
+		t.Errorf("GeoNamesUser = %q, want user", c.GeoNamesUser)
+	}
+	for _, v := range c.Values() {
+		if v.Env == "GEONAMES_USERNAME" && (v.Value != redacted || v.Source != SourceEnvFile) {
+			t.Errorf("Values() shows %q from %s, want it redacted from %s", v.Value, v.Source, SourceEnvFile)
+		}
+	}
+}
+
+func TestFlagErrors(t *testing.T) {
+	if _, err := Load([]string{"-no-such-flag"}, Checks{}); err == nil || !strings.Contains(err.Error(), "no-such-flag") {
+		t.Errorf("Load() error = %v, want an unknown flag error", err)
+	}
+	// Loading again must not redefine flags.
+	if _, err := Load([]string{"-log-level", "debug"}, Checks{}); err != nil {
+		t.Errorf("second Load() error = %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	file := writeFile(t, "config.yaml", "cache:\n  ttlMinutes: 7\n")
+
+	c, err := Load([]string{"-config", file, "-log-level", "debug"}, Checks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(file, []byte("cache:\n  ttlMinutes: 9\nserver:\n  listen: :9000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	next, err := c.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if next.CacheTTLMinutes != 9 || next.LogLevel != "debug" {
+		t.Errorf("Reload() = ttl %d, level %s, want the new file and the old flags", next.CacheTTLMinutes, next.LogLevel)
+	}
+
+	live, restart := c.Changes(next)
+	if strings.Join(live, ",") != "CACHE_TTL_MINUTES" || strings.Join(restart, ",") != "LISTEN_ADDR" {
+		t.Errorf("Changes() = %v, %v, want CACHE_TTL_MINUTES live and LISTEN_ADDR on restart", live, restart)
+	}
+}
+
+func TestUnknownFileSetting(t *testing.T) {
+	file := writeFile(t, "config.yaml", "log:\n  levle: debug\n")
+	if _, err := Load([]string{"-config", file}, Checks{}); err == nil || !strings.Contains(err.Error(), "unknown settings log.levle") {
+		t.Errorf("Load() error = %v, want the unknown setting", err)
+	}
+}
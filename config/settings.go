@@ -0,0 +1,332 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andreybrigunet/IpContext/clientip"
+)
+
+// setting is one configuration value. It can be set in the config file
+// under key, with the env variable, and with a flag named after the
+// variable (LOG_LEVEL is -log-level), in increasing order of precedence.
+type setting struct {
+	key  string // dotted path in the config file, e.g. log.level
+	env  string
+	flag string // defaults to env in lower case with dashes
+	def  string
+	set  setter
+	live bool // applied on reload without a restart
+
+	// secret values are hidden by config check and can be read from the
+	// file named by the env variable with a _FILE suffix.
+	secret bool
+}
+
+// setter parses a value into its Config field.
+type setter struct {
+	apply  func(c *Config, v string) error
+	isBool bool // the flag may be given without a value
+}
+
+func (s *setting) flagName() string {
+	if s.flag != "" {
+		return s.flag
+	}
+	return strings.ReplaceAll(strings.ToLower(s.env), "_", "-")
+}
+
+// settings lists every setting, grouped by config file section.
+var settings = []setting{
+	{key: "server.listen", env: "LISTEN_ADDR", flag: "listen", def: ":3280", set: str(func(c *Config) *string { return &c.ListenAddr })},
+	{key: "server.socketMode", env: "UNIX_SOCKET_MODE", def: "0660", set: octal(func(c *Config) *string { return &c.SocketMode })},
+
+	{key: "databases.path", env: "DB_PATH", def: "/data", set: str(func(c *Config) *string { return &c.DBPath })},
+	{key: "databases.reloadSeconds", env: "DB_RELOAD_SECONDS", def: "30", set: integer(func(c *Config) *int { return &c.DBReloadSeconds })},
+
+	{key: "log.level", env: "LOG_LEVEL", def: "info", live: true, set: enum(func(c *Config) *string { return &c.LogLevel }, "trace", "debug", "info", "warn", "error", "fatal", "panic")},
+	{key: "log.format", env: "LOG_FORMAT", def: "console", set: enum(func(c *Config) *string { return &c.LogFormat }, "console", "json")},
+	{key: "log.timeFormat", env: "LOG_TIME_FORMAT", def: "2006-01-02 15:04:05", set: str(func(c *Config) *string { return &c.LogTimeFmt })},
+	{key: "log.outputs", env: "LOG_OUTPUTS", set: checkedList(func(c *Config) *[]string { return &c.LogOutputs }, func(k *Checks) func([]string) error { return k.LogOutputs })},
+
+	{key: "config.reloadSeconds", env: "CONFIG_RELOAD_SECONDS", def: "10", set: integer(func(c *Config) *int { return &c.ConfigReloadSeconds })},
+
+	{key: "privacy.mode", env: "PRIVACY_MODE", def: "off", set: enum(func(c *Config) *string { return &c.PrivacyMode }, "off", "truncate", "hash")},
+	{key: "privacy.ipv4Prefix", env: "PRIVACY_IPV4_PREFIX", def: "24", set: intRange(func(c *Config) *int { return &c.PrivacyIPv4Prefix }, 0, 32)},
+	{key: "privacy.ipv6Prefix", env: "PRIVACY_IPV6_PREFIX", def: "48", set: intRange(func(c *Config) *int { return &c.PrivacyIPv6Prefix }, 0, 128)},
+	{key: "privacy.hashRotationHours", env: "PRIVACY_HASH_ROTATION_HOURS", def: "24", set: intRange(func(c *Config) *int { return &c.PrivacyHashRotationHours }, 1, math.MaxInt)},
+
+	{key: "precision.profile", env: "PRECISION_PROFILE", def: "full", set: str(func(c *Config) *string { return &c.PrecisionProfile })},
+	{key: "precision.routes", env: "PRECISION_ROUTES", set: precisionRoutes(func(c *Config) *[]string { return &c.PrecisionRoutes })},
+	{key: "precision.profilesFile", env: "PRECISION_PROFILES_FILE", set: str(func(c *Config) *string { return &c.PrecisionProfilesFile })},
+
+	{key: "accessLog.enabled", env: "ACCESS_LOG", def: "false", set: boolean(func(c *Config) *bool { return &c.AccessLog })},
+	{key: "accessLog.fields", env: "ACCESS_LOG_FIELDS", set: checkedList(func(c *Config) *[]string { return &c.AccessLogFields }, func(k *Checks) func([]string) error { return k.AccessLogFields })},
+	{key: "accessLog.sampleRate", env: "ACCESS_LOG_SAMPLE_RATE", def: "1", set: fraction(func(c *Config) *float64 { return &c.AccessLogSampleRate })},
+
+	{key: "geonames.username", env: "GEONAMES_USERNAME", secret: true, set: str(func(c *Config) *string { return &c.GeoNamesUser })},
+	{key: "geonames.neighboursUpdateHours", env: "NEIGHBOURS_UPDATE_HOURS", def: "168", live: true, set: integer(func(c *Config) *int { return &c.NeighboursUpdateHours })},
+	{key: "geonames.languagesUpdateHours", env: "LANGUAGES_UPDATE_HOURS", def: "168", live: true, set: integer(func(c *Config) *int { return &c.LanguagesUpdateHours })},
+
+	{key: "cache.ttlMinutes", env: "CACHE_TTL_MINUTES", def: "5", live: true, set: integer(func(c *Config) *int { return &c.CacheTTLMinutes })},
+
+	{key: "cors.allowedOrigins", env: "CORS_ALLOWED_ORIGINS", def: "*", live: true, set: list(func(c *Config) *[]string { return &c.CORSAllowedOrigins })},
+	{key: "cors.maxAgeSeconds", env: "CORS_MAX_AGE_SECONDS", def: "0", live: true, set: integer(func(c *Config) *int { return &c.CORSMaxAgeSeconds })},
+
+	{key: "dns.listen", env: "DNS_LISTEN_ADDR", set: str(func(c *Config) *string { return &c.DNSListenAddr })},
+	{key: "dns.rulesFile", env: "DNS_RULES_FILE", def: "/app/geodns.json", set: str(func(c *Config) *string { return &c.DNSRulesFile })},
+
+	{key: "access.rulesFile", env: "ACCESS_RULES_FILE", set: str(func(c *Config) *string { return &c.AccessRulesFile })},
+	{key: "access.reloadSeconds", env: "ACCESS_RELOAD_SECONDS", def: "10", set: integer(func(c *Config) *int { return &c.AccessReloadSeconds })},
+
+	{key: "apiKeys.file", env: "API_KEYS_FILE", set: str(func(c *Config) *string { return &c.APIKeysFile })},
+	{key: "apiKeys.required", env: "API_KEYS_REQUIRED", def: "true", set: boolean(func(c *Config) *bool { return &c.APIKeysRequired })},
+	{key: "apiKeys.reloadSeconds", env: "API_KEYS_RELOAD_SECONDS", def: "10", set: integer(func(c *Config) *int { return &c.APIKeysReloadSeconds })},
+
+	{key: "usage.dbFile", env: "USAGE_DB_FILE", set: str(func(c *Config) *string { return &c.UsageDBFile })},
+	{key: "usage.flushSeconds", env: "USAGE_FLUSH_SECONDS", def: "10", set: integer(func(c *Config) *int { return &c.UsageFlushSeconds })},
+
+	{key: "metrics.listen", env: "METRICS_LISTEN_ADDR", set: str(func(c *Config) *string { return &c.MetricsListenAddr })},
+
+	{key: "admin.listen", env: "ADMIN_LISTEN_ADDR", set: str(func(c *Config) *string { return &c.AdminListenAddr })},
+	{key: "admin.allowedCidrs", env: "ADMIN_ALLOWED_CIDRS", def: "127.0.0.0/8,::1/128", set: cidrs(func(c *Config) *[]string { return &c.AdminAllowedCIDRs })},
+	{key: "admin.pprof", env: "ADMIN_PPROF", def: "false", set: boolean(func(c *Config) *bool { return &c.AdminPprof })},
+
+	{key: "ready.maxDbAgeDays", env: "READY_MAX_DB_AGE_DAYS", def: "0", set: integer(func(c *Config) *int { return &c.ReadyMaxDBAgeDays })},
+	{key: "ready.minGeonamesCoverage", env: "READY_MIN_GEONAMES_COVERAGE", def: "0", set: intRange(func(c *Config) *int { return &c.ReadyMinGeoNamesCoverage }, 0, 100)},
+
+	{key: "tracing.exporter", env: "TRACING_EXPORTER", def: "none", set: enum(func(c *Config) *string { return &c.TracingExporter }, "otlp", "stdout", "none")},
+	{key: "tracing.otlpEndpoint", env: "TRACING_OTLP_ENDPOINT", set: checked(func(c *Config) *string { return &c.TracingEndpoint }, func(k *Checks) func(string) error { return k.TracingEndpoint })},
+	{key: "tracing.sampleRatio", env: "TRACING_SAMPLE_RATIO", def: "1", set: fraction(func(c *Config) *float64 { return &c.TracingSampleRatio })},
+
+	{key: "loadShed.maxInFlight", env: "MAX_INFLIGHT", def: "0", set: integer(func(c *Config) *int { return &c.MaxInFlight })},
+	{key: "loadShed.adaptive", env: "ADAPTIVE_CONCURRENCY", def: "false", set: boolean(func(c *Config) *bool { return &c.AdaptiveConcurrency })},
+	{key: "loadShed.targetLatencyMs", env: "TARGET_LATENCY_MS", def: "50", set: integer(func(c *Config) *int { return &c.TargetLatencyMs })},
+	{key: "loadShed.minInFlight", env: "MIN_INFLIGHT", def: "0", set: integer(func(c *Config) *int { return &c.MinInFlight })},
+
+	{key: "rateLimit.ip", env: "RATE_LIMIT_IP", set: checked(func(c *Config) *string { return &c.RateLimitIP }, func(k *Checks) func(string) error { return k.RateLimit })},
+	{key: "rateLimit.cidr", env: "RATE_LIMIT_CIDR", set: checked(func(c *Config) *string { return &c.RateLimitCIDR }, func(k *Checks) func(string) error { return k.RateLimit })},
+	{key: "rateLimit.key", env: "RATE_LIMIT_KEY", set: checked(func(c *Config) *string { return &c.RateLimitKey }, func(k *Checks) func(string) error { return k.RateLimit })},
+	{key: "rateLimit.ipv4Prefix", env: "RATE_LIMIT_IPV4_PREFIX", def: "24", set: intRange(func(c *Config) *int { return &c.RateLimitIPv4Prefix }, 0, 32)},
+	{key: "rateLimit.ipv6Prefix", env: "RATE_LIMIT_IPV6_PREFIX", def: "48", set: intRange(func(c *Config) *int { return &c.RateLimitIPv6Prefix }, 0, 128)},
+	{key: "rateLimit.maxEntries", env: "RATE_LIMIT_MAX_ENTRIES", def: "100000", set: integer(func(c *Config) *int { return &c.RateLimitMaxEntries })},
+
+	{key: "proxy.upstream", env: "PROXY_UPSTREAM", set: checked(func(c *Config) *string { return &c.ProxyUpstream }, func(*Checks) func(string) error { return checkAbsoluteURL })},
+	{key: "proxy.listen", env: "PROXY_LISTEN_ADDR", def: ":3281", set: str(func(c *Config) *string { return &c.ProxyListenAddr })},
+	{key: "proxy.headers", env: "PROXY_HEADERS", set: checked(func(c *Config) *string { return &c.ProxyHeaders }, func(k *Checks) func(string) error { return k.ProxyHeaders })},
+
+	{key: "clientIP.trustedProxies", env: "TRUSTED_PROXIES", def: "127.0.0.0/8,::1/128", set: cidrs(func(c *Config) *[]string { return &c.TrustedProxies })},
+	{key: "clientIP.trustUnixSockets", env: "TRUST_UNIX_SOCKETS", def: "false", set: boolean(func(c *Config) *bool { return &c.TrustUnixSockets })},
+	{key: "clientIP.header", env: "CLIENT_IP_HEADER", set: clientIPHeader(func(c *Config) *string { return &c.ClientIPHeader })},
+
+	{key: "proxyProtocol.listeners", env: "PROXY_PROTOCOL", set: enumList(func(c *Config) *[]string { return &c.ProxyProtocolListeners }, "api", "tls", "proxy", "all")},
+	{key: "proxyProtocol.trusted", env: "PROXY_PROTOCOL_TRUSTED", set: cidrs(func(c *Config) *[]string { return &c.ProxyProtocolTrusted })},
+
+	{key: "tls.listen", env: "TLS_LISTEN_ADDR", set: str(func(c *Config) *string { return &c.TLSListenAddr })},
+	{key: "tls.certFile", env: "TLS_CERT_FILE", set: str(func(c *Config) *string { return &c.TLSCertFile })},
+	{key: "tls.keyFile", env: "TLS_KEY_FILE", set: str(func(c *Config) *string { return &c.TLSKeyFile })},
+	{key: "tls.clientCaFile", env: "TLS_CLIENT_CA_FILE", set: str(func(c *Config) *string { return &c.TLSClientCAFile })},
+	{key: "tls.clientAuth", env: "TLS_CLIENT_AUTH", def: "require", set: enum(func(c *Config) *string { return &c.TLSClientAuth }, "require", "verify-if-given")},
+	{key: "tls.minVersion", env: "TLS_MIN_VERSION", def: "1.2", set: enum(func(c *Config) *string { return &c.TLSMinVersion }, "1.2", "1.3")},
+	{key: "tls.reloadSeconds", env: "TLS_RELOAD_SECONDS", def: "30", set: integer(func(c *Config) *int { return &c.TLSReloadSeconds })},
+}
+
+func str(field func(*Config) *string) setter {
+	return setter{apply: func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}}
+}
+
+// enum accepts one of values, ignoring case, and stores it as listed.
+func enum(field func(*Config) *string, values ...string) setter {
+	return setter{apply: func(c *Config, v string) error {
+		for _, value := range values {
+			if strings.EqualFold(value, v) {
+				*field(c) = value
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}}
+}
+
+// octal accepts unix permissions such as 0660, up to 0777.
+func octal(field func(*Config) *string) setter {
+	return setter{apply: func(c *Config, v string) error {
+		if mode, err := strconv.ParseUint(v, 8, 32); err != nil || mode > 0777 {
+			return errors.New("must be octal permissions such as 0660")
+		}
+		*field(c) = v
+		return nil
+	}}
+}
+
+// integer accepts whole numbers that are not negative.
+func integer(field func(*Config) *int) setter {
+	return intRange(field, 0, math.MaxInt)
+}
+
+func intRange(field func(*Config) *int, min, max int) setter {
+	return setter{apply: func(c *Config, v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.New("must be a whole number")
+		}
+		if i < min || i > max {
+			if max == math.MaxInt {
+				return fmt.Errorf("must be %d or more", min)
+			}
+			return fmt.Errorf("must be between %d and %d", min, max)
+		}
+		*field(c) = i
+		return nil
+	}}
+}
+
+// fraction accepts numbers from 0 to 1.
+func fraction(field func(*Config) *float64) setter {
+	return setter{apply: func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return errors.New("must be a number between 0 and 1")
+		}
+		*field(c) = f
+		return nil
+	}}
+}
+
+func boolean(field func(*Config) *bool) setter {
+	return setter{isBool: true, apply: func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+// list splits a comma separated value. "none" yields an empty list.
+func list(field func(*Config) *[]string) setter {
+	return setter{apply: func(c *Config, v string) error {
+		*field(c) = splitList(v)
+		return nil
+	}}
+}
+
+// enumList is a list of items from values, ignoring case.
+func enumList(field func(*Config) *[]string, values ...string) setter {
+	return setter{apply: func(c *Config, v string) error {
+		items := splitList(v)
+		for _, item := range items {
+			if !slices.ContainsFunc(values, func(value string) bool { return strings.EqualFold(value, item) }) {
+				return fmt.Errorf("%q is not one of %s", item, strings.Join(values, ", "))
+			}
+		}
+		*field(c) = items
+		return nil
+	}}
+}
+
+// cidrs is a list of CIDRs or bare addresses.
+func cidrs(field func(*Config) *[]string) setter {
+	return setter{apply: func(c *Config, v string) error {
+		items := splitList(v)
+		if _, err := clientip.ParseCIDRs(items); err != nil {
+			return err
+		}
+		*field(c) = items
+		return nil
+	}}
+}
+
+// clientIPHeader accepts one of clientip.Headers, or none.
+func clientIPHeader(field func(*Config) *string) setter {
+	return setter{apply: func(c *Config, v string) error {
+		if strings.EqualFold(v, "none") {
+			v = ""
+		}
+		if strings.Contains(v, ",") {
+			return errors.New("must be a single header, the one your proxy sets")
+		}
+		if err := clientip.CheckHeader(v); err != nil {
+			return err
+		}
+		*field(c) = strings.ToLower(strings.TrimSpace(v))
+		return nil
+	}}
+}
+
+// checked is str for values that the check picked from Checks accepts.
+// Empty values, and all values when the check is nil, are not checked.
+func checked(field func(*Config) *string, check func(*Checks) func(string) error) setter {
+	return setter{apply: func(c *Config, v string) error {
+		if fn := check(&c.checks); fn != nil && v != "" {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+		*field(c) = v
+		return nil
+	}}
+}
+
+// checkedList is list for lists that the check picked from Checks accepts.
+func checkedList(field func(*Config) *[]string, check func(*Checks) func([]string) error) setter {
+	return setter{apply: func(c *Config, v string) error {
+		items := splitList(v)
+		if fn := check(&c.checks); fn != nil {
+			if err := fn(items); err != nil {
+				return err
+			}
+		}
+		*field(c) = items
+		return nil
+	}}
+}
+
+// precisionRoutes is a list of route=profile pairs, with routes from
+// Checks.PrecisionRoutes when it is set.
+func precisionRoutes(field func(*Config) *[]string) setter {
+	return setter{apply: func(c *Config, v string) error {
+		items := splitList(v)
+		routes := c.checks.PrecisionRoutes
+		for _, item := range items {
+			route, profile, ok := strings.Cut(item, "=")
+			if !ok || route == "" || profile == "" || (routes != nil && !slices.Contains(routes, route)) {
+				if routes == nil {
+					return fmt.Errorf("entry %q must be route=profile", item)
+				}
+				return fmt.Errorf("entry %q must be route=profile, with route one of %s", item, strings.Join(routes, ", "))
+			}
+		}
+		*field(c) = items
+		return nil
+	}}
+}
+
+func checkAbsoluteURL(v string) error {
+	if u, err := url.Parse(v); err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.New("must be an absolute URL such as http://backend:8080")
+	}
+	return nil
+}
+
+func splitList(v string) []string {
+	if strings.EqualFold(strings.TrimSpace(v), "none") {
+		return nil
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
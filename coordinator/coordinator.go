@@ -2,18 +2,27 @@ package coordinator
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/andreybrigunet/IpContext/languages"
 	"github.com/andreybrigunet/IpContext/neighbours"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/andreybrigunet/IpContext/coordinator")
+
 type Coordinator struct {
 	neighStore *neighbours.Store
 	langStore  *languages.Store
 	logger     zerolog.Logger
-	intervals  Intervals
+
+	mu        sync.Mutex
+	intervals Intervals
+	changed   chan struct{}
 }
 
 type Intervals struct {
@@ -27,9 +36,29 @@ func New(neighStore *neighbours.Store, langStore *languages.Store, intervals Int
 		langStore:  langStore,
 		logger:     logger,
 		intervals:  intervals,
+		changed:    make(chan struct{}, 1),
+	}
+}
+
+// SetIntervals changes the refresh intervals. The next refresh of each
+// store is rescheduled from its last one; one that is overdue starts now.
+func (c *Coordinator) SetIntervals(intervals Intervals) {
+	c.mu.Lock()
+	c.intervals = intervals
+	c.mu.Unlock()
+
+	select {
+	case c.changed <- struct{}{}:
+	default:
 	}
 }
 
+func (c *Coordinator) currentIntervals() Intervals {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.intervals
+}
+
 func (c *Coordinator) Start(ctx context.Context) {
 	if c.neighStore == nil && c.langStore == nil {
 		c.logger.Info().Msg("No stores configured, coordinator will not run")
@@ -42,12 +71,21 @@ func (c *Coordinator) Start(ctx context.Context) {
 func (c *Coordinator) run(ctx context.Context) {
 	c.logger.Info().Msg("Starting GeoNames coordinator (neighbours -> languages)")
 	
-	now := time.Now()
-	nextNeigh := now
-	nextLang := now
+	// Scheduled times of the last refreshes; both stores are due at start.
+	start := time.Now()
+	lastNeigh, lastLang := time.Time{}, time.Time{}
 
 	for {
-		next := c.calculateNextUpdate(nextNeigh, nextLang)
+		intervals := c.currentIntervals()
+		nextNeigh, nextLang := start, start
+		if !lastNeigh.IsZero() {
+			nextNeigh = lastNeigh.Add(intervals.Neighbours)
+		}
+		if !lastLang.IsZero() {
+			nextLang = lastLang.Add(intervals.Languages)
+		}
+
+		next := c.calculateNextUpdate(intervals, nextNeigh, nextLang)
 		sleep := time.Until(next)
 		if sleep < 0 {
 			sleep = 0
@@ -57,26 +95,36 @@ func (c *Coordinator) run(ctx context.Context) {
 		case <-ctx.Done():
 			c.logger.Info().Msg("Coordinator shutting down")
 			return
+		case <-c.changed:
+			changed := c.currentIntervals()
+			c.logger.Info().
+				Dur("neighbours", changed.Neighbours).
+				Dur("languages", changed.Languages).
+				Msg("GeoNames refresh intervals changed")
 		case <-time.After(sleep):
-			now = time.Now()
+			now := time.Now()
 			
-			if c.shouldUpdateNeighbours(now, nextNeigh) {
-				c.neighStore.RefreshAllOnce()
-				nextNeigh = nextNeigh.Add(c.intervals.Neighbours)
+			if c.shouldUpdateNeighbours(intervals, now, nextNeigh) {
+				spanCtx, span := tracer.Start(ctx, "geonames.refresh", trace.WithAttributes(attribute.String("store", "neighbours")))
+				c.neighStore.RefreshAllContext(spanCtx)
+				span.End()
+				lastNeigh = nextNeigh
 			}
 			
-			if c.shouldUpdateLanguages(now, nextLang) {
-				c.langStore.RefreshAllOnce()
-				nextLang = nextLang.Add(c.intervals.Languages)
+			if c.shouldUpdateLanguages(intervals, now, nextLang) {
+				spanCtx, span := tracer.Start(ctx, "geonames.refresh", trace.WithAttributes(attribute.String("store", "languages")))
+				c.langStore.RefreshAllContext(spanCtx)
+				span.End()
+				lastLang = nextLang
 			}
 		}
 	}
 }
 
-func (c *Coordinator) calculateNextUpdate(nextNeigh, nextLang time.Time) time.Time {
+func (c *Coordinator) calculateNextUpdate(intervals Intervals, nextNeigh, nextLang time.Time) time.Time {
 	next := nextNeigh
 	
-	if c.langStore != nil && c.intervals.Languages > 0 && 
+	if c.langStore != nil && intervals.Languages > 0 && 
 		(nextLang.Before(next) || next.IsZero()) {
 		next = nextLang
 	}
@@ -84,14 +132,14 @@ func (c *Coordinator) calculateNextUpdate(nextNeigh, nextLang time.Time) time.Ti
 	return next
 }
 
-func (c *Coordinator) shouldUpdateNeighbours(now, nextNeigh time.Time) bool {
+func (c *Coordinator) shouldUpdateNeighbours(intervals Intervals, now, nextNeigh time.Time) bool {
 	return c.neighStore != nil && 
-		   c.intervals.Neighbours > 0 && 
+		   intervals.Neighbours > 0 && 
 		   !now.Before(nextNeigh)
 }
 
-func (c *Coordinator) shouldUpdateLanguages(now, nextLang time.Time) bool {
+func (c *Coordinator) shouldUpdateLanguages(intervals Intervals, now, nextLang time.Time) bool {
 	return c.langStore != nil && 
-		   c.intervals.Languages > 0 && 
+		   intervals.Languages > 0 && 
 		   !now.Before(nextLang)
 }
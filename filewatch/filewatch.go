@@ -0,0 +1,50 @@
+// Package filewatch polls files for changes, so that they can be reloaded
+// without a restart.
+package filewatch
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Watch checks path every interval and calls reload whenever its
+// modification time changes, until ctx is cancelled. The file as it is when
+// Watch starts counts as loaded. A failed reload is logged and not retried
+// until the file changes again; reload should keep the previous state.
+func Watch(ctx context.Context, path string, interval time.Duration, logger zerolog.Logger, reload func() error) {
+	modTime := stat(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fi, err := os.Stat(path)
+			if err != nil {
+				logger.Warn().Err(err).Str("file", path).Msg("Cannot stat watched file")
+				continue
+			}
+			if fi.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = fi.ModTime()
+			if err := reload(); err != nil {
+				logger.Error().Err(err).Str("file", path).Msg("Failed to reload file; keeping the previous version")
+			}
+		}
+	}
+}
+
+func stat(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
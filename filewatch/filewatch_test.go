@@ -0,0 +1,87 @@
+package filewatch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := make(chan struct{}, 10)
+	var fail atomic.Bool
+	fail.Store(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Watch(ctx, path, 5*time.Millisecond, zerolog.Nop(), func() error {
+			reloads <- struct{}{}
+			if fail.Load() {
+				return errors.New("broken")
+			}
+			return nil
+		})
+	}()
+
+	expect := func(want bool) {
+		t.Helper()
+		select {
+		case <-reloads:
+			if !want {
+				t.Fatal("reloaded without a change")
+			}
+		case <-time.After(50 * time.Millisecond):
+			if want {
+				t.Fatal("change not picked up")
+			}
+		}
+	}
+	touch := func(mtime time.Time) {
+		t.Helper()
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect(false)
+
+	base := time.Now().Add(time.Hour)
+	touch(base)
+	expect(true)
+	// The failed reload is not retried until the file changes again.
+	expect(false)
+
+	fail.Store(false)
+	touch(base.Add(time.Second))
+	expect(true)
+
+	// A missing file is skipped until it is back and changes.
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatal(err)
+	}
+	expect(false)
+	if err := os.Rename(path+".old", path); err != nil {
+		t.Fatal(err)
+	}
+	expect(false)
+	touch(base.Add(2 * time.Second))
+	expect(true)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Watch did not return after cancellation")
+	}
+}
@@ -0,0 +1,265 @@
+package geodns
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+	"github.com/miekg/dns"
+)
+
+// Rules is the top-level structure of the GeoDNS rules file.
+type Rules struct {
+	Zones []*Zone `json:"zones"`
+}
+
+// Zone describes an authoritative zone and the geo-aware records within it.
+type Zone struct {
+	Name    string             `json:"name"`
+	TTL     uint32             `json:"ttl"`
+	NS      []string           `json:"ns"`
+	Mbox    string             `json:"mbox"`
+	Records map[string]*Record `json:"records"` // relative label ("@" for apex) -> record
+}
+
+// Record holds the candidate pools for a name and the rules choosing between them.
+type Record struct {
+	TTL     uint32           `json:"ttl"`
+	Pools   map[string]*Pool `json:"pools"`
+	Rules   []Rule           `json:"rules"`
+	Default string           `json:"default"`
+}
+
+// Pool is a set of addresses served together, optionally anchored to a location
+// so that "nearest" rules can pick it.
+type Pool struct {
+	A    []string `json:"a"`
+	AAAA []string `json:"aaaa"`
+	Lat  *float64 `json:"lat,omitempty"`
+	Lon  *float64 `json:"lon,omitempty"`
+
+	a    []net.IP
+	aaaa []net.IP
+}
+
+// Rule selects a pool when all of its non-empty conditions match the client location.
+// Rules are evaluated in order; the first match wins.
+type Rule struct {
+	Countries  []string `json:"countries,omitempty"`
+	Continents []string `json:"continents,omitempty"`
+	EU         *bool    `json:"eu,omitempty"`
+	Pool       string   `json:"pool,omitempty"`
+	Nearest    bool     `json:"nearest,omitempty"` // pick the closest pool that has coordinates
+}
+
+// LoadRules reads and validates a rules file.
+func LoadRules(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	if err := rules.prepare(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &rules, nil
+}
+
+func (r *Rules) prepare() error {
+	if len(r.Zones) == 0 {
+		return fmt.Errorf("no zones defined")
+	}
+
+	for _, z := range r.Zones {
+		if z.Name == "" {
+			return fmt.Errorf("zone without name")
+		}
+		z.Name = dns.CanonicalName(z.Name)
+		if z.TTL == 0 {
+			z.TTL = 60
+		}
+		for i, ns := range z.NS {
+			z.NS[i] = dns.Fqdn(ns)
+		}
+		if z.Mbox == "" {
+			z.Mbox = "hostmaster." + z.Name
+		}
+		z.Mbox = dns.Fqdn(z.Mbox)
+
+		// Queries are matched in lower case, like the zone names.
+		records := make(map[string]*Record, len(z.Records))
+		for label, rec := range z.Records {
+			if err := rec.prepare(z); err != nil {
+				return fmt.Errorf("zone %s record %q: %w", z.Name, label, err)
+			}
+			key := strings.ToLower(label)
+			if _, dup := records[key]; dup {
+				return fmt.Errorf("zone %s: duplicate record %q", z.Name, key)
+			}
+			records[key] = rec
+		}
+		z.Records = records
+	}
+
+	return nil
+}
+
+func (rec *Record) prepare(z *Zone) error {
+	if len(rec.Pools) == 0 {
+		return fmt.Errorf("no pools defined")
+	}
+	if rec.TTL == 0 {
+		rec.TTL = z.TTL
+	}
+
+	for name, p := range rec.Pools {
+		for _, s := range p.A {
+			ip := net.ParseIP(s).To4()
+			if ip == nil {
+				return fmt.Errorf("pool %q: invalid A address %q", name, s)
+			}
+			p.a = append(p.a, ip)
+		}
+		for _, s := range p.AAAA {
+			ip := net.ParseIP(s)
+			if ip == nil || ip.To4() != nil {
+				return fmt.Errorf("pool %q: invalid AAAA address %q", name, s)
+			}
+			p.aaaa = append(p.aaaa, ip)
+		}
+		if (p.Lat == nil) != (p.Lon == nil) {
+			return fmt.Errorf("pool %q: lat and lon must be set together", name)
+		}
+	}
+
+	if rec.Default != "" {
+		if _, ok := rec.Pools[rec.Default]; !ok {
+			return fmt.Errorf("default pool %q not defined", rec.Default)
+		}
+	}
+
+	for i, rule := range rec.Rules {
+		if rule.Pool == "" && !rule.Nearest {
+			return fmt.Errorf("rule %d: needs either pool or nearest", i)
+		}
+		if rule.Pool != "" {
+			if _, ok := rec.Pools[rule.Pool]; !ok {
+				return fmt.Errorf("rule %d: pool %q not defined", i, rule.Pool)
+			}
+		}
+	}
+
+	return nil
+}
+
+// findZone returns the most specific zone containing qname.
+func (r *Rules) findZone(qname string) *Zone {
+	var best *Zone
+	for _, z := range r.Zones {
+		if dns.IsSubDomain(z.Name, qname) && (best == nil || len(z.Name) > len(best.Name)) {
+			best = z
+		}
+	}
+	return best
+}
+
+// record returns the record for qname inside the zone, if any.
+func (z *Zone) record(qname string) *Record {
+	if qname == z.Name {
+		return z.Records["@"]
+	}
+	label := strings.TrimSuffix(qname, "."+z.Name)
+	return z.Records[label]
+}
+
+// selectPool picks the pool for a client location. loc may be nil when the
+// location is unknown, in which case only the default pool applies.
+func (rec *Record) selectPool(loc *geoip.Response) (string, *Pool) {
+	if loc != nil {
+		for _, rule := range rec.Rules {
+			if !rule.matches(loc) {
+				continue
+			}
+			if rule.Nearest {
+				if name, p := rec.nearestPool(loc.Lat, loc.Lon); p != nil {
+					return name, p
+				}
+				continue
+			}
+			return rule.Pool, rec.Pools[rule.Pool]
+		}
+	}
+
+	if rec.Default != "" {
+		return rec.Default, rec.Pools[rec.Default]
+	}
+	return "", nil
+}
+
+func (rule Rule) matches(loc *geoip.Response) bool {
+	if len(rule.Countries) > 0 && !containsFold(rule.Countries, loc.CountryCode) {
+		return false
+	}
+	if len(rule.Continents) > 0 && !containsFold(rule.Continents, loc.ContinentCode) {
+		return false
+	}
+	if rule.EU != nil && *rule.EU != geoip.IsEUCountry(loc.CountryCode) {
+		return false
+	}
+	return true
+}
+
+func (rec *Record) nearestPool(lat, lon float64) (string, *Pool) {
+	if lat == 0 && lon == 0 {
+		return "", nil
+	}
+
+	var (
+		bestName string
+		best     *Pool
+		bestDist = math.MaxFloat64
+	)
+	for name, p := range rec.Pools {
+		if p.Lat == nil {
+			continue
+		}
+		d := haversine(lat, lon, *p.Lat, *p.Lon)
+		// Tie-break on name so answers are deterministic.
+		if d < bestDist || (d == bestDist && name < bestName) {
+			bestName, best, bestDist = name, p, d
+		}
+	}
+	return bestName, best
+}
+
+// haversine returns the great-circle distance in kilometres.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	rad := math.Pi / 180
+
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+func containsFold(list []string, v string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
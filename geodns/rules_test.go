@@ -0,0 +1,187 @@
+package geodns
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+func loadRules(t *testing.T, content string) (*Rules, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "geodns.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadRules(path)
+}
+
+func TestLoadRules(t *testing.T) {
+	r, err := loadRules(t, `{"zones": [{"name": "Geo.Example.com", "records": {
+		"@":   {"pools": {"p": {"a": ["192.0.2.1"]}}, "default": "p"},
+		"WWW": {"ttl": 30, "pools": {"p": {"a": ["192.0.2.2"]}}, "default": "p"}
+	}}]}`)
+	if err != nil {
+		t.Fatalf("LoadRules: %v", err)
+	}
+
+	z := r.findZone("www.geo.example.com.")
+	if z == nil || z.Name != "geo.example.com." || z.TTL != 60 || z.Mbox != "hostmaster.geo.example.com." {
+		t.Fatalf("zone = %+v, want geo.example.com. with defaults", z)
+	}
+	if rec := z.record("www.geo.example.com."); rec == nil || rec.TTL != 30 {
+		t.Errorf("record(www) = %+v, want the WWW record", rec)
+	}
+	if rec := z.record("geo.example.com."); rec == nil || rec.TTL != 60 {
+		t.Errorf("record(apex) = %+v, want the @ record with the zone TTL", rec)
+	}
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "no zones", content: `{"zones": []}`, wantErr: "no zones"},
+		{name: "zone without name", content: `{"zones": [{"records": {}}]}`, wantErr: "zone without name"},
+		{name: "no pools", content: `{"zones": [{"name": "z", "records": {"www": {}}}]}`, wantErr: "no pools"},
+		{name: "bad A", content: `{"zones": [{"name": "z", "records": {"www": {"pools": {"p": {"a": ["2001:db8::1"]}}}}}]}`, wantErr: "invalid A address"},
+		{name: "bad AAAA", content: `{"zones": [{"name": "z", "records": {"www": {"pools": {"p": {"aaaa": ["192.0.2.1"]}}}}}]}`, wantErr: "invalid AAAA address"},
+		{name: "lat without lon", content: `{"zones": [{"name": "z", "records": {"www": {"pools": {"p": {"lat": 1}}}}}]}`, wantErr: "lat and lon"},
+		{name: "unknown default", content: `{"zones": [{"name": "z", "records": {"www": {"pools": {"p": {}}, "default": "q"}}}]}`, wantErr: `default pool "q"`},
+		{name: "rule without target", content: `{"zones": [{"name": "z", "records": {"www": {"pools": {"p": {}}, "rules": [{"eu": true}]}}}]}`, wantErr: "needs either pool or nearest"},
+		{name: "unknown rule pool", content: `{"zones": [{"name": "z", "records": {"www": {"pools": {"p": {}}, "rules": [{"pool": "q"}]}}}]}`, wantErr: `pool "q" not defined`},
+		{name: "keys differing in case", content: `{"zones": [{"name": "z", "records": {"www": {"pools": {"p": {}}}, "WWW": {"pools": {"p": {}}}}}]}`, wantErr: `duplicate record "www"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadRules(t, tt.content)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadRules() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func coord(v float64) *float64 { return &v }
+
+func TestSelectPool(t *testing.T) {
+	eu, no := true, false
+	rec := &Record{
+		Pools: map[string]*Pool{
+			"eu":    {Lat: coord(50.1), Lon: coord(8.7)},
+			"us":    {Lat: coord(39.0), Lon: coord(-77.5)},
+			"apac":  {Lat: coord(1.35), Lon: coord(103.8)},
+			"other": {},
+		},
+		Rules: []Rule{
+			{EU: &eu, Pool: "eu"},
+			{Countries: []string{"US", "CA"}, Pool: "us"},
+			{Continents: []string{"OC"}, EU: &no, Pool: "apac"},
+			{Continents: []string{"AS", "EU"}, Nearest: true},
+		},
+		Default: "other",
+	}
+	countryOnly := &Record{
+		Pools:   map[string]*Pool{"de": {}, "world": {}},
+		Rules:   []Rule{{Countries: []string{"DE"}, Pool: "de"}},
+		Default: "world",
+	}
+	noDefault := &Record{
+		Pools: map[string]*Pool{"de": {}},
+		Rules: []Rule{{Countries: []string{"DE"}, Pool: "de"}},
+	}
+
+	tests := []struct {
+		name string
+		rec  *Record
+		loc  *geoip.Response
+		want string
+	}{
+		{name: "EU member", rec: rec, loc: &geoip.Response{CountryCode: "FR", ContinentCode: "EU"}, want: "eu"},
+		{name: "country", rec: rec, loc: &geoip.Response{CountryCode: "US", ContinentCode: "NA"}, want: "us"},
+		{name: "country in lower case", rec: rec, loc: &geoip.Response{CountryCode: "ca", ContinentCode: "NA"}, want: "us"},
+		{name: "continent", rec: rec, loc: &geoip.Response{CountryCode: "AU", ContinentCode: "OC"}, want: "apac"},
+		{name: "nearest outside the EU", rec: rec, loc: &geoip.Response{CountryCode: "GB", ContinentCode: "EU", Lat: 51.5, Lon: -0.1}, want: "eu"},
+		{name: "nearest in Asia", rec: rec, loc: &geoip.Response{CountryCode: "JP", ContinentCode: "AS", Lat: 35.7, Lon: 139.7}, want: "apac"},
+		{name: "nearest without coordinates", rec: rec, loc: &geoip.Response{CountryCode: "JP", ContinentCode: "AS"}, want: "other"},
+		{name: "no rule matches", rec: rec, loc: &geoip.Response{CountryCode: "BR", ContinentCode: "SA"}, want: "other"},
+		{name: "unknown location", rec: rec, loc: &geoip.Response{}, want: "other"},
+		{name: "lookup failed", rec: rec, loc: nil, want: "other"},
+		{name: "country pool", rec: countryOnly, loc: &geoip.Response{CountryCode: "DE"}, want: "de"},
+		{name: "country pool falls back to default", rec: countryOnly, loc: &geoip.Response{CountryCode: "AT"}, want: "world"},
+		{name: "no default", rec: noDefault, loc: &geoip.Response{CountryCode: "AT"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, p := tt.rec.selectPool(tt.loc)
+			if name != tt.want || (p == nil) != (tt.want == "") || (p != nil && p != tt.rec.Pools[name]) {
+				t.Errorf("selectPool() = %q, %v, want %q", name, p, tt.want)
+			}
+		})
+	}
+}
+
+func TestNearestPool(t *testing.T) {
+	rec := &Record{Pools: map[string]*Pool{
+		"frankfurt": {Lat: coord(50.1), Lon: coord(8.7)},
+		"ashburn":   {Lat: coord(39.0), Lon: coord(-77.5)},
+		"b":         {Lat: coord(-33.9), Lon: coord(151.2)},
+		"a":         {Lat: coord(-33.9), Lon: coord(151.2)},
+		"anywhere":  {},
+	}}
+
+	tests := []struct {
+		name     string
+		lat, lon float64
+		want     string
+	}{
+		{name: "Berlin", lat: 52.5, lon: 13.4, want: "frankfurt"},
+		{name: "New York", lat: 40.7, lon: -74.0, want: "ashburn"},
+		{name: "across the date line", lat: -13.8, lon: -171.8, want: "a"},
+		{name: "tie breaks on name", lat: -33.9, lon: 151.2, want: "a"},
+		{name: "no location", want: ""},
+	}
+
+	for _, tt := range tests {
+		if name, _ := rec.nearestPool(tt.lat, tt.lon); name != tt.want {
+			t.Errorf("%s: nearestPool(%v, %v) = %q, want %q", tt.name, tt.lat, tt.lon, name, tt.want)
+		}
+	}
+
+	if name, p := (&Record{Pools: map[string]*Pool{"p": {}}}).nearestPool(1, 1); p != nil {
+		t.Errorf("nearestPool() without coordinates = %q", name)
+	}
+}
+
+func TestFindZone(t *testing.T) {
+	r := &Rules{Zones: []*Zone{{Name: "example.com."}, {Name: "geo.example.com."}, {Name: "example.org."}}}
+
+	tests := []struct {
+		qname string
+		want  string
+	}{
+		{qname: "example.com.", want: "example.com."},
+		{qname: "www.example.com.", want: "example.com."},
+		{qname: "geo.example.com.", want: "geo.example.com."},
+		{qname: "a.b.geo.example.com.", want: "geo.example.com."},
+		{qname: "xgeo.example.com.", want: "example.com."},
+		{qname: "example.net.", want: ""},
+		{qname: "com.", want: ""},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if z := r.findZone(tt.qname); z != nil {
+			got = z.Name
+		}
+		if got != tt.want {
+			t.Errorf("findZone(%s) = %q, want %q", tt.qname, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package geodns
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+	"github.com/andreybrigunet/IpContext/privacy"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+)
+
+// Server is an authoritative DNS responder answering A/AAAA queries for the
+// configured zones with records chosen by client location.
+type Server struct {
+	rules   *Rules
+	geoIP   *geoip.GeoIP
+	anon    *privacy.Anonymizer
+	log     zerolog.Logger
+	serial  uint32
+	servers []*dns.Server
+}
+
+// New creates a GeoDNS server listening on addr over both UDP and TCP. anon
+// anonymizes client addresses in logs; nil logs them as they are.
+func New(addr string, rules *Rules, geoIP *geoip.GeoIP, anon *privacy.Anonymizer, logger zerolog.Logger) *Server {
+	s := &Server{
+		rules:  rules,
+		geoIP:  geoIP,
+		anon:   anon,
+		log:    logger,
+		serial: uint32(time.Now().Unix()),
+	}
+
+	for _, network := range []string{"udp", "tcp"} {
+		s.servers = append(s.servers, &dns.Server{
+			Addr:         addr,
+			Net:          network,
+			Handler:      s,
+			ReadTimeout:  2 * time.Second,
+			WriteTimeout: 2 * time.Second,
+		})
+	}
+
+	return s
+}
+
+// Start serves until Stop is called or a listener fails.
+func (s *Server) Start() error {
+	s.log.Info().Str("addr", s.servers[0].Addr).Int("zones", len(s.rules.Zones)).Msg("Starting GeoDNS server")
+
+	errCh := make(chan error, len(s.servers))
+	for _, srv := range s.servers {
+		go func(srv *dns.Server) {
+			errCh <- srv.ListenAndServe()
+		}(srv)
+	}
+
+	return <-errCh
+}
+
+// Stop shuts down all listeners.
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var firstErr error
+	for _, srv := range s.servers {
+		if err := srv.ShutdownContext(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ServeDNS implements dns.Handler.
+func (s *Server) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+
+	if req.Opcode != dns.OpcodeQuery || len(req.Question) != 1 {
+		m.SetRcode(req, dns.RcodeNotImplemented)
+		s.write(w, m)
+		return
+	}
+
+	q := req.Question[0]
+	qname := dns.CanonicalName(q.Name)
+
+	zone := s.rules.findZone(qname)
+	if zone == nil {
+		m.SetRcode(req, dns.RcodeRefused)
+		s.write(w, m)
+		return
+	}
+	m.Authoritative = true
+
+	clientIP, ecs := s.clientAddr(w, req)
+	if opt := req.IsEdns0(); opt != nil {
+		resOpt := m.SetEdns0(dns.DefaultMsgSize, opt.Do()).IsEdns0()
+		if ecs != nil {
+			// The answer is valid for the whole subnet the client sent.
+			ecs.SourceScope = ecs.SourceNetmask
+			resOpt.Option = append(resOpt.Option, ecs)
+		}
+	}
+
+	if qname == zone.Name {
+		switch q.Qtype {
+		case dns.TypeSOA:
+			m.Answer = append(m.Answer, s.soa(zone))
+		case dns.TypeNS:
+			m.Answer = append(m.Answer, s.nsRecords(zone)...)
+		}
+	}
+
+	rec := zone.record(qname)
+	if rec == nil {
+		if len(m.Answer) == 0 {
+			if qname != zone.Name {
+				m.Rcode = dns.RcodeNameError
+			}
+			m.Ns = append(m.Ns, s.soa(zone))
+		}
+		s.write(w, m)
+		return
+	}
+
+	if q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA {
+		loc := s.locate(clientIP)
+		poolName, pool := rec.selectPool(loc)
+		if pool != nil {
+			m.Answer = append(m.Answer, addressRecords(q.Name, q.Qtype, rec.TTL, pool)...)
+		}
+
+		evt := s.log.Debug().
+			Str("qname", qname).
+			Str("qtype", dns.TypeToString[q.Qtype]).
+			Str("client", s.anon.String(clientIP.String())).
+			Str("pool", poolName)
+		if loc != nil {
+			evt = evt.Str("country", loc.CountryCode)
+		}
+		evt.Msg("GeoDNS answer")
+	}
+
+	if len(m.Answer) == 0 {
+		m.Ns = append(m.Ns, s.soa(zone))
+	}
+
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if opt := req.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		m.Truncate(size)
+	}
+
+	s.write(w, m)
+}
+
+// clientAddr returns the address to geolocate: the EDNS Client Subnet when
+// present, otherwise the resolver address.
+func (s *Server) clientAddr(w dns.ResponseWriter, req *dns.Msg) (net.IP, *dns.EDNS0_SUBNET) {
+	if opt := req.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if subnet, ok := o.(*dns.EDNS0_SUBNET); ok && subnet.Address != nil && subnet.SourceNetmask > 0 {
+				return subnet.Address, subnet
+			}
+		}
+	}
+
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		return addr.IP, nil
+	case *net.TCPAddr:
+		return addr.IP, nil
+	}
+
+	return nil, nil
+}
+
+func (s *Server) locate(ip net.IP) *geoip.Response {
+	if ip == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, err := s.geoIP.LookupWithContext(ctx, ip.String())
+	if err != nil {
+		s.log.Debug().Err(err).Str("ip", s.anon.String(ip.String())).Msg("GeoDNS lookup failed")
+		return nil
+	}
+	return resp
+}
+
+func (s *Server) soa(z *Zone) dns.RR {
+	ns := "ns." + z.Name
+	if len(z.NS) > 0 {
+		ns = z.NS[0]
+	}
+
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: z.Name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: z.TTL},
+		Ns:      ns,
+		Mbox:    z.Mbox,
+		Serial:  s.serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  z.TTL,
+	}
+}
+
+func (s *Server) nsRecords(z *Zone) []dns.RR {
+	out := make([]dns.RR, 0, len(z.NS))
+	for _, ns := range z.NS {
+		out = append(out, &dns.NS{
+			Hdr: dns.RR_Header{Name: z.Name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: z.TTL},
+			Ns:  ns,
+		})
+	}
+	return out
+}
+
+func addressRecords(name string, qtype uint16, ttl uint32, p *Pool) []dns.RR {
+	hdr := dns.RR_Header{Name: name, Rrtype: qtype, Class: dns.ClassINET, Ttl: ttl}
+
+	var out []dns.RR
+	if qtype == dns.TypeA {
+		for _, ip := range p.a {
+			out = append(out, &dns.A{Hdr: hdr, A: ip})
+		}
+	} else {
+		for _, ip := range p.aaaa {
+			out = append(out, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	return out
+}
+
+func (s *Server) write(w dns.ResponseWriter, m *dns.Msg) {
+	if err := w.WriteMsg(m); err != nil {
+		s.log.Debug().Err(err).Msg("Failed to write DNS response")
+	}
+}
@@ -0,0 +1,122 @@
+package geodns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/geoip/geoiptest"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+)
+
+// recorder is a dns.ResponseWriter that keeps the written message.
+type recorder struct {
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func (w *recorder) LocalAddr() net.Addr         { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53} }
+func (w *recorder) RemoteAddr() net.Addr        { return w.remote }
+func (w *recorder) WriteMsg(m *dns.Msg) error   { w.msg = m; return nil }
+func (w *recorder) Write(b []byte) (int, error) { return len(b), nil }
+func (w *recorder) Close() error                { return nil }
+func (w *recorder) TsigStatus() error           { return nil }
+func (w *recorder) TsigTimersOnly(bool)         {}
+func (w *recorder) Hijack()                     {}
+
+func TestServeDNS(t *testing.T) {
+	rules, err := loadRules(t, `{"zones": [{"name": "geo.example.com", "ns": ["ns1.example.com"], "records": {
+		"www": {
+			"pools": {
+				"us":    {"a": ["192.0.2.20"], "aaaa": ["2001:db8::20"]},
+				"eu":    {"a": ["192.0.2.10"]},
+				"other": {"a": ["192.0.2.30"]}
+			},
+			"rules": [{"countries": ["US"], "pool": "us"}, {"eu": true, "pool": "eu"}],
+			"default": "other"
+		}
+	}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New("127.0.0.1:0", rules, geoiptest.New(t, time.Minute), nil, zerolog.Nop())
+
+	tests := []struct {
+		name     string
+		qname    string
+		qtype    uint16
+		resolver string
+		subnet   string // EDNS Client Subnet, CIDR
+		rcode    int
+		want     string // address answered, if any
+		scope    uint8  // ECS scope in the answer, 0 for none
+	}{
+		{name: "resolver location", qname: "www.geo.example.com.", qtype: dns.TypeA, resolver: "8.8.8.8", want: "192.0.2.20"},
+		{name: "unknown resolver", qname: "www.geo.example.com.", qtype: dns.TypeA, resolver: "198.51.100.1", want: "192.0.2.30"},
+		{name: "client subnet wins", qname: "www.geo.example.com.", qtype: dns.TypeA, resolver: "8.8.8.8", subnet: "91.198.174.0/24", want: "192.0.2.10", scope: 24},
+		{name: "IPv6 client subnet", qname: "www.geo.example.com.", qtype: dns.TypeA, resolver: "8.8.8.8", subnet: "2001:db8::/56", want: "192.0.2.10", scope: 56},
+		{name: "empty client subnet", qname: "www.geo.example.com.", qtype: dns.TypeA, resolver: "8.8.8.8", subnet: "0.0.0.0/0", want: "192.0.2.20"},
+		{name: "AAAA", qname: "www.geo.example.com.", qtype: dns.TypeAAAA, resolver: "8.8.8.8", want: "2001:db8::20"},
+		{name: "AAAA missing in pool", qname: "www.geo.example.com.", qtype: dns.TypeAAAA, resolver: "91.198.174.1"},
+		{name: "mixed case", qname: "WWW.Geo.Example.COM.", qtype: dns.TypeA, resolver: "8.8.8.8", want: "192.0.2.20"},
+		{name: "unknown name", qname: "ftp.geo.example.com.", qtype: dns.TypeA, resolver: "8.8.8.8", rcode: dns.RcodeNameError},
+		{name: "other zone", qname: "www.example.org.", qtype: dns.TypeA, resolver: "8.8.8.8", rcode: dns.RcodeRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, tt.qtype)
+			if tt.subnet != "" {
+				_, network, _ := net.ParseCIDR(tt.subnet)
+				ones, bits := network.Mask.Size()
+				family := uint16(1)
+				if bits == 128 {
+					family = 2
+				}
+				req.SetEdns0(4096, false)
+				opt := req.IsEdns0()
+				opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: family, SourceNetmask: uint8(ones), Address: network.IP})
+			}
+
+			w := &recorder{remote: &net.UDPAddr{IP: net.ParseIP(tt.resolver), Port: 5353}}
+			s.ServeDNS(w, req)
+			m := w.msg
+			if m == nil {
+				t.Fatal("no response written")
+			}
+
+			if m.Rcode != tt.rcode {
+				t.Fatalf("rcode = %s, want %s", dns.RcodeToString[m.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			got := ""
+			for _, rr := range m.Answer {
+				switch rr := rr.(type) {
+				case *dns.A:
+					got = rr.A.String()
+				case *dns.AAAA:
+					got = rr.AAAA.String()
+				}
+			}
+			if got != tt.want || len(m.Answer) > 1 {
+				t.Errorf("answer = %v, want %q", m.Answer, tt.want)
+			}
+			if tt.want == "" && tt.rcode != dns.RcodeRefused && len(m.Ns) != 1 {
+				t.Errorf("authority = %v, want the SOA", m.Ns)
+			}
+
+			var scope uint8
+			if opt := m.IsEdns0(); opt != nil {
+				for _, o := range opt.Option {
+					if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+						scope = subnet.SourceScope
+					}
+				}
+			}
+			if scope != tt.scope {
+				t.Errorf("ECS scope = %d, want %d", scope, tt.scope)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package geoip
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// anonymousDBFile is optional; anonymity checks are skipped when it is absent.
+const anonymousDBFile = "GeoIP2-Anonymous-IP.mmdb"
+
+// AnonymousFlags reports how an address is known to hide its origin.
+type AnonymousFlags struct {
+	Anonymous        bool `json:"anonymous"`
+	VPN              bool `json:"vpn"`
+	Hosting          bool `json:"hosting"`
+	PublicProxy      bool `json:"publicProxy"`
+	ResidentialProxy bool `json:"residentialProxy"`
+	Tor              bool `json:"tor"`
+}
+
+func openAnonymousDB(dbPath string) (*geoip2.Reader, error) {
+	db, err := geoip2.Open(filepath.Join(dbPath, anonymousDBFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return db, err
+}
+
+// HasAnonymousDB reports whether the optional Anonymous IP database is loaded.
+func (g *GeoIP) HasAnonymousDB() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.dbs != nil && g.dbs.anon != nil
+}
+
+// AnonymousIP returns anonymity flags for ip, or nil when the Anonymous IP
+// database is not available.
+func (g *GeoIP) AnonymousIP(ip net.IP) (*AnonymousFlags, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.dbs == nil || g.dbs.anon == nil {
+		return nil, nil
+	}
+
+	rec, err := g.dbs.anon.AnonymousIP(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AnonymousFlags{
+		Anonymous:        rec.IsAnonymous,
+		VPN:              rec.IsAnonymousVPN,
+		Hosting:          rec.IsHostingProvider,
+		PublicProxy:      rec.IsPublicProxy,
+		ResidentialProxy: rec.IsResidentialProxy,
+		Tor:              rec.IsTorExitNode,
+	}, nil
+}
+
+// ASNumber extracts the numeric autonomous system from the ip-api style AS field.
+func (r *Response) ASNumber() uint {
+	if !strings.HasPrefix(r.AS, "AS") {
+		return 0
+	}
+	num := strings.TrimPrefix(r.AS, "AS")
+	if i := strings.IndexByte(num, ' '); i >= 0 {
+		num = num[:i]
+	}
+	n, err := strconv.ParseUint(num, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint(n)
+}
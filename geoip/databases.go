@@ -0,0 +1,176 @@
+package geoip
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Database files expected in the database directory.
+const (
+	cityDBFile = "GeoLite2-City.mmdb"
+	asnDBFile  = "GeoLite2-ASN.mmdb"
+)
+
+// ErrNotLoaded is returned by lookups until the databases have been loaded.
+var ErrNotLoaded = errors.New("GeoIP databases are not loaded yet")
+
+// modTimes holds the modification times of the City, ASN and Anonymous IP
+// files; zero for a missing file.
+type modTimes [3]time.Time
+
+func statDatabases(dbPath string) modTimes {
+	var mt modTimes
+	for i, name := range []string{cityDBFile, asnDBFile, anonymousDBFile} {
+		if fi, err := os.Stat(filepath.Join(dbPath, name)); err == nil {
+			mt[i] = fi.ModTime()
+		}
+	}
+	return mt
+}
+
+func (m modTimes) equal(o modTimes) bool {
+	for i := range m {
+		if !m[i].Equal(o[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// databases is one loaded generation of the MaxMind readers.
+type databases struct {
+	city     *geoip2.Reader
+	asn      *geoip2.Reader
+	anon     *geoip2.Reader // optional
+	modTimes modTimes
+}
+
+func openDatabases(dbPath string) (*databases, error) {
+	mt := statDatabases(dbPath)
+
+	city, err := geoip2.Open(filepath.Join(dbPath, cityDBFile))
+	if err != nil {
+		return nil, err
+	}
+
+	asn, err := geoip2.Open(filepath.Join(dbPath, asnDBFile))
+	if err != nil {
+		city.Close()
+		return nil, err
+	}
+
+	anon, err := openAnonymousDB(dbPath)
+	if err != nil {
+		city.Close()
+		asn.Close()
+		return nil, err
+	}
+
+	return &databases{city: city, asn: asn, anon: anon, modTimes: mt}, nil
+}
+
+func (d *databases) close() error {
+	err := errors.Join(d.city.Close(), d.asn.Close())
+	if d.anon != nil {
+		err = errors.Join(err, d.anon.Close())
+	}
+	return err
+}
+
+// Loaded reports whether the databases have been loaded.
+func (g *GeoIP) Loaded() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.dbs != nil
+}
+
+// WhenLoaded returns a channel that is closed once the databases have been
+// loaded for the first time.
+func (g *GeoIP) WhenLoaded() <-chan struct{} {
+	return g.loaded
+}
+
+// Reload opens the databases again and swaps them in once no lookup is
+// using the previous ones. The response cache is cleared, as its entries
+// came from the old data. On error the current databases stay in use.
+func (g *GeoIP) Reload() error {
+	dbs, err := openDatabases(g.dbPath)
+	if err != nil {
+		return err
+	}
+
+	// Clearing under the lock keeps lookups of the old data from caching
+	// their results afterwards, see LookupWithContext.
+	g.mu.Lock()
+	old := g.dbs
+	g.dbs = dbs
+	g.cache.Clear()
+	g.mu.Unlock()
+
+	if old != nil {
+		if err := old.close(); err != nil {
+			g.logger.Warn().Err(err).Msg("Failed to close previous MaxMind databases")
+		}
+	}
+	g.loadedOnce.Do(func() { close(g.loaded) })
+
+	evt := g.logger.Info().Str("dbPath", g.dbPath).Bool("anonymousIP", dbs.anon != nil)
+	for _, db := range []*geoip2.Reader{dbs.city, dbs.asn} {
+		md := db.Metadata()
+		evt = evt.Time(md.DatabaseType, time.Unix(int64(md.BuildEpoch), 0).UTC())
+	}
+	evt.Msg("Loaded MaxMind databases")
+
+	return nil
+}
+
+// Start checks the database files every interval until ctx is cancelled.
+// It loads them once they appear, and reloads them when they are replaced,
+// e.g. by geoipupdate. Files that fail to load are retried when they change
+// again.
+func (g *GeoIP) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var failed modTimes
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current := statDatabases(g.dbPath)
+			if current.equal(failed) {
+				continue
+			}
+
+			g.mu.RLock()
+			dbs := g.dbs
+			g.mu.RUnlock()
+			if dbs != nil && current.equal(dbs.modTimes) {
+				continue
+			}
+
+			if err := g.Reload(); err != nil {
+				failed = current
+				if dbs == nil {
+					g.logger.Warn().Err(err).Str("dbPath", g.dbPath).Msg("MaxMind databases not loadable yet, still waiting")
+				} else {
+					// Keep serving the previous databases until the files are fixed.
+					g.logger.Error().Err(err).Str("dbPath", g.dbPath).Msg("Failed to reload MaxMind databases")
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,41 @@
+package geoip
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ParseFields splits an ip-api style comma separated field list.
+func ParseFields(spec string) []string {
+	var out []string
+	for _, f := range strings.Split(spec, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Select returns only the requested JSON fields of the response. The query
+// and status fields are always included so callers can match and check results.
+func (r *Response) Select(fields []string) map[string]interface{} {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return map[string]interface{}{"query": r.Query, "status": r.Status}
+	}
+
+	var all map[string]interface{}
+	if err := json.Unmarshal(raw, &all); err != nil {
+		return map[string]interface{}{"query": r.Query, "status": r.Status}
+	}
+
+	out := make(map[string]interface{}, len(fields)+2)
+	out["query"] = r.Query
+	out["status"] = r.Status
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			out[f] = v
+		}
+	}
+	return out
+}
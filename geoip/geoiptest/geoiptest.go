@@ -0,0 +1,41 @@
+// Package geoiptest opens small MaxMind test databases, so that packages
+// using geoip can be tested without the GeoLite2 downloads.
+//
+// The databases know these networks:
+//
+//	8.8.8.0/24       US, Mountain View, AS15169 GOOGLE
+//	81.2.69.0/24     GB, London, no ASN
+//	91.198.174.0/24  DE, Berlin, AS14907 Wikimedia Foundation Inc.
+//	1.1.1.0/24       AU, Sydney, AS13335 CLOUDFLARENET
+//	2001:db8::/32    FR, Paris, no ASN
+//
+// Other addresses resolve to an empty location.
+package geoiptest
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+	"github.com/rs/zerolog"
+)
+
+// Dir returns the directory holding the test databases.
+func Dir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata")
+}
+
+// New opens the test databases with a response cache of cacheTTL, and
+// closes them when the test ends.
+func New(tb testing.TB, cacheTTL time.Duration) *geoip.GeoIP {
+	tb.Helper()
+	g, err := geoip.New(Dir(), nil, nil, nil, zerolog.Nop(), cacheTTL)
+	if err != nil {
+		tb.Fatalf("open test databases: %v", err)
+	}
+	tb.Cleanup(func() { g.Close() })
+	return g
+}
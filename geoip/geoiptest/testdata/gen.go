@@ -0,0 +1,83 @@
+//go:build ignore
+
+// gen writes the test databases in this directory. It needs
+// github.com/maxmind/mmdbwriter, which the module does not depend on, so
+// run it from a scratch module:
+//
+//	go run gen.go .
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func city(cc, country, continent, name, region string, lat, lon float64, tz string) mmdbtype.Map {
+	return mmdbtype.Map{
+		"continent": mmdbtype.Map{"code": mmdbtype.String(continent), "names": mmdbtype.Map{"en": mmdbtype.String(continent)}},
+		"country":   mmdbtype.Map{"iso_code": mmdbtype.String(cc), "names": mmdbtype.Map{"en": mmdbtype.String(country)}},
+		"city":      mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String(name)}},
+		"postal":    mmdbtype.Map{"code": mmdbtype.String("12345")},
+		"location": mmdbtype.Map{
+			"latitude":  mmdbtype.Float64(lat),
+			"longitude": mmdbtype.Float64(lon),
+			"time_zone": mmdbtype.String(tz),
+		},
+		"subdivisions": mmdbtype.Slice{mmdbtype.Map{"iso_code": mmdbtype.String(region), "names": mmdbtype.Map{"en": mmdbtype.String(region)}}},
+	}
+}
+
+func asn(number uint32, org string) mmdbtype.Map {
+	return mmdbtype.Map{
+		"autonomous_system_number":       mmdbtype.Uint32(number),
+		"autonomous_system_organization": mmdbtype.String(org),
+	}
+}
+
+func write(path, dbType string, records map[string]mmdbtype.Map) {
+	w, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: dbType, IncludeReservedNetworks: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for cidr, rec := range records {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := w.Insert(n, rec); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := w.WriteTo(f); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	dir := os.Args[1]
+
+	write(filepath.Join(dir, "GeoLite2-City.mmdb"), "GeoLite2-City", map[string]mmdbtype.Map{
+		"8.8.8.0/24":      city("US", "United States", "NA", "Mountain View", "CA", 37.4056, -122.0775, "America/Los_Angeles"),
+		"81.2.69.0/24":    city("GB", "United Kingdom", "EU", "London", "ENG", 51.5142, -0.0931, "Europe/London"),
+		"91.198.174.0/24": city("DE", "Germany", "EU", "Berlin", "BE", 52.5200, 13.4050, "Europe/Berlin"),
+		"1.1.1.0/24":      city("AU", "Australia", "OC", "Sydney", "NSW", -33.8688, 151.2093, "Australia/Sydney"),
+		"2001:db8::/32":   city("FR", "France", "EU", "Paris", "IDF", 48.8566, 2.3522, "Europe/Paris"),
+	})
+
+	write(filepath.Join(dir, "GeoLite2-ASN.mmdb"), "GeoLite2-ASN", map[string]mmdbtype.Map{
+		"8.8.8.0/24":      asn(15169, "GOOGLE"),
+		"91.198.174.0/24": asn(14907, "Wikimedia Foundation Inc."),
+		"1.1.1.0/24":      asn(13335, "CLOUDFLARENET"),
+	})
+}
@@ -1,10 +1,12 @@
+// Package geoip resolves IP addresses against the MaxMind GeoLite2 City and
+// ASN databases and enriches the result with currency, EU membership and,
+// when stores are provided, neighbours and languages.
 package geoip
 
 import (
 	"context"
 	"errors"
 	"net"
-	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -12,24 +14,33 @@ import (
 	"github.com/andreybrigunet/IpContext/cache"
 	"github.com/andreybrigunet/IpContext/neighbours"
 	"github.com/andreybrigunet/IpContext/languages"
-	"github.com/oschwald/geoip2-golang"
+	"github.com/andreybrigunet/IpContext/privacy"
+	"github.com/andreybrigunet/IpContext/tracing"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type GeoIP struct {
-	cityDB    *geoip2.Reader
-	asnDB     *geoip2.Reader
+	dbPath    string
+	mu        sync.RWMutex // held for reading while a lookup uses dbs
+	dbs       *databases   // nil until loaded
 	neigh     *neighbours.Store
 	langs     *languages.Store
+	anon      *privacy.Anonymizer // for addresses in logs and spans
 	logger    zerolog.Logger
-	closeOnce sync.Once
 	cache     *cache.Cache
+	errCounts lookupErrors
+
+	loaded     chan struct{} // closed by the first successful Reload
+	loadedOnce sync.Once
 }
 
 // Response represents the IP lookup response structure
 type Response struct {
 	Query         string              `json:"query"`
 	Status        string              `json:"status"`
+	Message       string              `json:"message,omitempty"`
 	Continent     string              `json:"continent,omitempty"`
 	ContinentCode string              `json:"continentCode,omitempty"`
 	Country       string              `json:"country,omitempty"`
@@ -54,27 +65,29 @@ type Response struct {
 	Languages     []string            `json:"languages,omitempty"`
 }
 
-// New creates a new GeoIP service instance
-func New(dbPath string, neigh *neighbours.Store, langs *languages.Store, logger zerolog.Logger, cacheTTL time.Duration) (*GeoIP, error) {
-	cityDB, err := geoip2.Open(filepath.Join(dbPath, "GeoLite2-City.mmdb"))
-	if err != nil {
-		return nil, err
-	}
-
-	asnDB, err := geoip2.Open(filepath.Join(dbPath, "GeoLite2-ASN.mmdb"))
-	if err != nil {
-		cityDB.Close()
+// New creates a new GeoIP service instance. anon anonymizes addresses in
+// logs and spans; nil leaves them as they are.
+func New(dbPath string, neigh *neighbours.Store, langs *languages.Store, anon *privacy.Anonymizer, logger zerolog.Logger, cacheTTL time.Duration) (*GeoIP, error) {
+	g := NewUnloaded(dbPath, neigh, langs, anon, logger, cacheTTL)
+	if err := g.Reload(); err != nil {
 		return nil, err
 	}
+	return g, nil
+}
 
+// NewUnloaded creates a GeoIP service without opening the databases, so
+// that a server can start before they are downloaded. Lookups fail with
+// ErrNotLoaded until Reload succeeds; Start retries it in the background.
+func NewUnloaded(dbPath string, neigh *neighbours.Store, langs *languages.Store, anon *privacy.Anonymizer, logger zerolog.Logger, cacheTTL time.Duration) *GeoIP {
 	return &GeoIP{
-		cityDB: cityDB,
-		asnDB:  asnDB,
+		dbPath: dbPath,
 		neigh:  neigh,
 		langs:  langs,
+		anon:   anon,
 		logger: logger,
 		cache:  cache.New(cacheTTL),
-	}, nil
+		loaded: make(chan struct{}),
+	}
 }
 
 // Lookup performs an IP address lookup
@@ -83,14 +96,34 @@ func (g *GeoIP) Lookup(ipStr string) (*Response, error) {
 }
 
 // LookupWithContext performs an IP address lookup with context
-func (g *GeoIP) LookupWithContext(ctx context.Context, ipStr string) (*Response, error) {
+func (g *GeoIP) LookupWithContext(ctx context.Context, ipStr string) (resp *Response, err error) {
+	ctx, span := tracer.Start(ctx, "geoip.Lookup", trace.WithAttributes(attribute.String("ipcontext.query", g.anon.String(ipStr))))
+	defer func() { tracing.EndSpan(span, err) }()
+
 	ip := net.ParseIP(ipStr)
 	if ip == nil {
+		g.errCounts.invalidIP.Add(1)
 		return nil, errors.New("invalid IP address")
 	}
 
+	stats := LookupStatsFrom(ctx)
+	if stats != nil {
+		start := time.Now()
+		defer func() {
+			stats.Lookups++
+			stats.Duration += time.Since(start)
+		}()
+	}
+
 	// Check cache first for ultra-fast response
-	if cached, found := g.cache.Get(ipStr); found {
+	_, cacheSpan := tracer.Start(ctx, "cache.Get")
+	cached, found := g.cache.Get(ipStr)
+	cacheSpan.SetAttributes(attribute.Bool("cache.hit", found))
+	cacheSpan.End()
+	if found && stats != nil {
+		stats.CacheHits++
+	}
+	if found {
 		if resp, ok := cached.(*Response); ok {
 			return resp, nil
 		}
@@ -99,25 +132,46 @@ func (g *GeoIP) LookupWithContext(ctx context.Context, ipStr string) (*Response,
 	// Check if context is cancelled
 	select {
 	case <-ctx.Done():
+		g.errCounts.canceled.Add(1)
 		return nil, ctx.Err()
 	default:
 	}
 
+	g.mu.RLock()
+	dbs := g.dbs
+	if dbs == nil {
+		g.mu.RUnlock()
+		g.errCounts.notLoaded.Add(1)
+		return nil, ErrNotLoaded
+	}
+
 	// Lookup city data
-	city, err := g.cityDB.City(ip)
+	_, citySpan := tracer.Start(ctx, "geoip.City")
+	city, err := dbs.city.City(ip)
+	tracing.EndSpan(citySpan, err)
 	if err != nil {
+		g.mu.RUnlock()
+		g.errCounts.city.Add(1)
 		return nil, err
 	}
 
 	// Lookup ASN data
-	asn, err := g.asnDB.ASN(ip)
-	if err != nil {
+	_, asnSpan := tracer.Start(ctx, "geoip.ASN")
+	asn, asnErr := dbs.asn.ASN(ip)
+	tracing.EndSpan(asnSpan, asnErr)
+
+	// Records are decoded into their own memory, so the readers may be
+	// replaced from here on.
+	g.mu.RUnlock()
+
+	if asnErr != nil {
 		// Non-fatal error, we can continue without ASN data
-		g.logger.Warn().Err(err).Str("ip", ipStr).Msg("Failed to lookup ASN data")
+		g.errCounts.asn.Add(1)
+		g.logger.Warn().Err(asnErr).Str("ip", g.anon.String(ipStr)).Msg("Failed to lookup ASN data")
 	}
 
 	// Build response
-	resp := &Response{
+	resp = &Response{
 		Query:      ipStr,
 		Status:     "success",
 		Continent:  city.Continent.Names["en"],
@@ -161,7 +215,9 @@ func (g *GeoIP) LookupWithContext(ctx context.Context, ipStr string) (*Response,
 
 	// Attach neighbours if available
 	if g.neigh != nil && resp.CountryCode != "" {
+		_, nSpan := tracer.Start(ctx, "geoip.Neighbours")
 		resp.Neighbours = g.neigh.Get(resp.CountryCode)
+		nSpan.End()
 	}
 
 	// Determine EU membership
@@ -171,29 +227,34 @@ func (g *GeoIP) LookupWithContext(ctx context.Context, ipStr string) (*Response,
 
 	// Attach languages if available
 	if g.langs != nil && resp.CountryCode != "" {
+		_, lSpan := tracer.Start(ctx, "geoip.Languages")
 		resp.Languages = g.langs.Get(resp.CountryCode)
+		lSpan.End()
 	}
 
 	// Compute timezone offset in seconds (relative to UTC) as in ip-api
 	resp.Offset = GetTimezoneOffset(resp.Timezone)
 
-	// Cache the response for future requests
-	g.cache.Set(ipStr, resp)
+	// Cache the response for future requests, unless a reload swapped the
+	// databases and cleared the cache since it was looked up.
+	g.mu.RLock()
+	if g.dbs == dbs {
+		g.cache.Set(ipStr, resp)
+	}
+	g.mu.RUnlock()
 
 	return resp, nil
 }
 
 // Close releases resources used by the GeoIP databases
 func (g *GeoIP) Close() error {
-	var err1, err2 error
-	
-	g.closeOnce.Do(func() {
-		err1 = g.cityDB.Close()
-		err2 = g.asnDB.Close()
-	})
+	g.mu.Lock()
+	defer g.mu.Unlock()
 
-	if err1 != nil {
-		return err1
+	if g.dbs == nil {
+		return nil
 	}
-	return err2
+	err := g.dbs.close()
+	g.dbs = nil
+	return err
 }
@@ -0,0 +1,118 @@
+package geoip
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/cache"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Lookup error kinds reported by LookupErrors.
+const (
+	ErrKindInvalidIP = "invalid_ip"
+	ErrKindCanceled  = "canceled"
+	ErrKindCity      = "city"
+	ErrKindASN       = "asn" // non-fatal, the response is served without ASN data
+	ErrKindNotLoaded = "not_loaded"
+)
+
+type lookupErrors struct {
+	invalidIP atomic.Uint64
+	canceled  atomic.Uint64
+	city      atomic.Uint64
+	asn       atomic.Uint64
+	notLoaded atomic.Uint64
+}
+
+type lookupStatsKey struct{}
+
+// LookupStats accumulates the lookups made with a context returned by
+// WithLookupStats, e.g. for access logging. Lookups sharing it must not run
+// concurrently.
+type LookupStats struct {
+	Lookups   int
+	CacheHits int
+	Duration  time.Duration
+}
+
+// WithLookupStats returns a context that records lookups into the returned stats.
+func WithLookupStats(ctx context.Context) (context.Context, *LookupStats) {
+	stats := &LookupStats{}
+	return context.WithValue(ctx, lookupStatsKey{}, stats), stats
+}
+
+// LookupStatsFrom returns the stats ctx records lookups into, or nil.
+func LookupStatsFrom(ctx context.Context) *LookupStats {
+	stats, _ := ctx.Value(lookupStatsKey{}).(*LookupStats)
+	return stats
+}
+
+// DatabaseInfo describes a loaded MaxMind database.
+type DatabaseInfo struct {
+	Edition   string // e.g. GeoLite2-City
+	BuildTime time.Time
+}
+
+// CacheStats returns the response cache counters.
+func (g *GeoIP) CacheStats() cache.Stats {
+	return g.cache.Stats()
+}
+
+// SetCacheTTL changes how long responses cached from now on are kept.
+func (g *GeoIP) SetCacheTTL(ttl time.Duration) {
+	g.cache.SetTTL(ttl)
+}
+
+// PurgeCache drops the cached responses for addresses in any of nets, or
+// every cached response when nets is empty, and returns how many it dropped.
+func (g *GeoIP) PurgeCache(nets []*net.IPNet) int {
+	return g.cache.DeleteFunc(func(key string) bool {
+		if len(nets) == 0 {
+			return true
+		}
+		ip := net.ParseIP(key)
+		for _, n := range nets {
+			if ip != nil && n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// LookupErrors returns the number of lookup errors by kind since start.
+func (g *GeoIP) LookupErrors() map[string]uint64 {
+	return map[string]uint64{
+		ErrKindInvalidIP: g.errCounts.invalidIP.Load(),
+		ErrKindCanceled:  g.errCounts.canceled.Load(),
+		ErrKindCity:      g.errCounts.city.Load(),
+		ErrKindASN:       g.errCounts.asn.Load(),
+		ErrKindNotLoaded: g.errCounts.notLoaded.Load(),
+	}
+}
+
+// Databases describes the loaded databases.
+func (g *GeoIP) Databases() []DatabaseInfo {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.dbs == nil {
+		return nil
+	}
+
+	var out []DatabaseInfo
+	for _, db := range []*geoip2.Reader{g.dbs.city, g.dbs.asn, g.dbs.anon} {
+		if db == nil {
+			continue
+		}
+		md := db.Metadata()
+		out = append(out, DatabaseInfo{
+			Edition:   md.DatabaseType,
+			BuildTime: time.Unix(int64(md.BuildEpoch), 0).UTC(),
+		})
+	}
+	return out
+}
@@ -0,0 +1,5 @@
+package geoip
+
+import "go.opentelemetry.io/otel"
+
+var tracer = otel.Tracer("github.com/andreybrigunet/IpContext/geoip")
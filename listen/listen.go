@@ -0,0 +1,142 @@
+// Package listen opens stream listeners from address strings, supporting
+// TCP ("host:port"), unix domain sockets ("unix:///run/ipcontext.sock") and
+// systemd socket activation ("systemd:" or "systemd:<FileDescriptorName>").
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	unixPrefix    = "unix://"
+	systemdPrefix = "systemd:"
+
+	// sdListenFdsStart is the first file descriptor passed by systemd.
+	sdListenFdsStart = 3
+)
+
+// Listen opens a listener for addr. mode sets the permissions of unix sockets.
+func Listen(addr string, mode os.FileMode) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, unixPrefix):
+		return listenUnix(strings.TrimPrefix(addr, unixPrefix), mode)
+	case strings.HasPrefix(addr, systemdPrefix):
+		return systemdListener(strings.TrimPrefix(addr, systemdPrefix))
+	default:
+		return net.Listen("tcp", addr)
+	}
+}
+
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("listen: empty unix socket path")
+	}
+
+	// A socket left behind by an unclean exit would make bind fail.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("listen: %s is in use by another process", path)
+		}
+		os.Remove(path)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("listen: chmod %s: %w", path, err)
+		}
+	}
+
+	return ln, nil
+}
+
+var (
+	activationOnce sync.Once
+	activated      []activatedFile
+	activationErr  error
+	activationUsed = map[int]bool{}
+	activationMu   sync.Mutex
+)
+
+type activatedFile struct {
+	name string
+	file *os.File
+}
+
+// systemdListener returns the activated socket with the given name, or the
+// first unused one when name is empty.
+func systemdListener(name string) (net.Listener, error) {
+	activationOnce.Do(loadActivation)
+	if activationErr != nil {
+		return nil, activationErr
+	}
+
+	activationMu.Lock()
+	defer activationMu.Unlock()
+
+	for i, f := range activated {
+		if activationUsed[i] || (name != "" && f.name != name) {
+			continue
+		}
+
+		ln, err := net.FileListener(f.file)
+		if err != nil {
+			return nil, fmt.Errorf("listen: systemd socket %d: %w", sdListenFdsStart+i, err)
+		}
+		f.file.Close() // FileListener holds its own duplicate
+		activationUsed[i] = true
+		return ln, nil
+	}
+
+	if name != "" {
+		return nil, fmt.Errorf("listen: no systemd socket named %q", name)
+	}
+	return nil, errors.New("listen: no unused systemd sockets (is the .socket unit configured?)")
+}
+
+// loadActivation reads LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES as described
+// in sd_listen_fds(3), then clears them so child processes do not inherit them.
+func loadActivation() {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		activationErr = errors.New("listen: not started by systemd socket activation (LISTEN_PID mismatch)")
+		return
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		activationErr = errors.New("listen: LISTEN_FDS is not set")
+		return
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		fd := sdListenFdsStart + i
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		activated = append(activated, activatedFile{
+			name: name,
+			file: os.NewFile(uintptr(fd), "systemd-socket-"+strconv.Itoa(fd)),
+		})
+	}
+}
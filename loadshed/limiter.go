@@ -0,0 +1,148 @@
+// Package loadshed bounds the number of requests served concurrently and,
+// optionally, adapts that bound to observed latency so that overload turns
+// into fast rejections instead of a latency collapse.
+package loadshed
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options configures a Limiter.
+type Options struct {
+	MaxInFlight int // hard upper bound on concurrent requests
+
+	// Adaptive lowers the bound while the p99 latency of a window exceeds
+	// TargetLatency and raises it again while latency is healthy.
+	Adaptive      bool
+	TargetLatency time.Duration
+	MinInFlight   int           // the adaptive bound never drops below this
+	Window        time.Duration // how often the bound is reconsidered
+}
+
+// maxSamples caps the latency samples kept per window.
+const maxSamples = 2048
+
+// Limiter admits requests while fewer than Limit are in flight.
+type Limiter struct {
+	opts     Options
+	limit    atomic.Int64
+	inFlight atomic.Int64
+	shed     atomic.Uint64
+
+	mu          sync.Mutex
+	samples     []time.Duration
+	peak        int64
+	windowStart time.Time
+	onChange    func(old, new int, p99 time.Duration)
+}
+
+// New returns a limiter. MinInFlight defaults to a tenth of MaxInFlight and
+// Window to one second.
+func New(opts Options) *Limiter {
+	if opts.MinInFlight <= 0 {
+		opts.MinInFlight = max(1, opts.MaxInFlight/10)
+	}
+	if opts.MinInFlight > opts.MaxInFlight {
+		opts.MinInFlight = opts.MaxInFlight
+	}
+	if opts.Window <= 0 {
+		opts.Window = time.Second
+	}
+
+	l := &Limiter{opts: opts, windowStart: time.Now()}
+	l.limit.Store(int64(opts.MaxInFlight))
+	return l
+}
+
+// OnChange registers a callback for adaptive limit changes, e.g. for logging.
+func (l *Limiter) OnChange(fn func(old, new int, p99 time.Duration)) {
+	l.mu.Lock()
+	l.onChange = fn
+	l.mu.Unlock()
+}
+
+// Acquire reserves a slot. It returns false, without blocking, when the
+// limit is reached; otherwise Release must be called when the request ends.
+func (l *Limiter) Acquire() bool {
+	n := l.inFlight.Add(1)
+	if n > l.limit.Load() {
+		l.inFlight.Add(-1)
+		l.shed.Add(1)
+		return false
+	}
+	return true
+}
+
+// Release frees a slot and records the latency the request observed. A zero
+// latency, e.g. from a request that did no work worth measuring, is not
+// sampled.
+func (l *Limiter) Release(latency time.Duration) {
+	n := l.inFlight.Add(-1) + 1
+	if !l.opts.Adaptive {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if latency > 0 && len(l.samples) < maxSamples {
+		l.samples = append(l.samples, latency)
+	}
+	if n > l.peak {
+		l.peak = n
+	}
+
+	if now := time.Now(); now.Sub(l.windowStart) >= l.opts.Window {
+		l.adjust()
+		l.samples = l.samples[:0]
+		l.peak = 0
+		l.windowStart = now
+	}
+}
+
+// adjust applies additive-increase/multiplicative-decrease to the limit.
+func (l *Limiter) adjust() {
+	if len(l.samples) == 0 {
+		return
+	}
+
+	sorted := append([]time.Duration(nil), l.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	p99 := sorted[len(sorted)*99/100]
+
+	old := l.limit.Load()
+	next := old
+	switch {
+	case p99 > l.opts.TargetLatency:
+		next = old * 9 / 10
+	case l.peak >= old*8/10:
+		// Only grow while the current limit is actually being used.
+		next = old + max(1, old/10)
+	}
+	next = min(max(next, int64(l.opts.MinInFlight)), int64(l.opts.MaxInFlight))
+
+	if next != old {
+		l.limit.Store(next)
+		if l.onChange != nil {
+			l.onChange(int(old), int(next), p99)
+		}
+	}
+}
+
+// Limit returns the current concurrency limit.
+func (l *Limiter) Limit() int {
+	return int(l.limit.Load())
+}
+
+// InFlight returns the number of admitted requests still being served.
+func (l *Limiter) InFlight() int {
+	return int(l.inFlight.Load())
+}
+
+// Shed returns how many requests have been rejected.
+func (l *Limiter) Shed() uint64 {
+	return l.shed.Load()
+}
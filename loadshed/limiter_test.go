@@ -0,0 +1,119 @@
+package loadshed
+
+import (
+	"testing"
+	"time"
+)
+
+// window feeds one window of samples, slow of them above the target, and
+// adjusts the limit as Release does when the window ends.
+func window(l *Limiter, n, slow int, peak int64) {
+	l.samples = l.samples[:0]
+	for i := 0; i < n; i++ {
+		latency := 10 * time.Millisecond
+		if i < slow {
+			latency = time.Second
+		}
+		l.samples = append(l.samples, latency)
+	}
+	l.peak = peak
+	l.adjust()
+}
+
+func newAdaptive() *Limiter {
+	return New(Options{MaxInFlight: 100, MinInFlight: 10, Adaptive: true, TargetLatency: 100 * time.Millisecond})
+}
+
+func TestAdjust(t *testing.T) {
+	l := newAdaptive()
+
+	var changes [][2]int
+	l.OnChange(func(old, new int, p99 time.Duration) {
+		if p99 <= 0 {
+			t.Errorf("OnChange p99 = %v", p99)
+		}
+		changes = append(changes, [2]int{old, new})
+	})
+
+	// Slow windows shrink the limit by a tenth, down to MinInFlight.
+	prev := l.Limit()
+	for i := 0; i < 30; i++ {
+		window(l, 100, 100, 100)
+		if got := l.Limit(); got > prev || got < 10 {
+			t.Fatalf("slow window %d: limit %d after %d, want it shrinking to at least 10", i, got, prev)
+		}
+		prev = l.Limit()
+	}
+	if prev != 10 {
+		t.Fatalf("limit = %d after slow windows, want 10", prev)
+	}
+	if len(changes) == 0 || changes[0] != [2]int{100, 90} || changes[len(changes)-1][1] != 10 {
+		t.Errorf("changes = %v, want 100 -> 90 first and 10 last", changes)
+	}
+
+	// Healthy windows that use the limit grow it by a tenth, up to MaxInFlight.
+	for i := 0; i < 50; i++ {
+		window(l, 100, 0, int64(l.Limit()))
+		if got := l.Limit(); got < prev || got > 100 {
+			t.Fatalf("healthy window %d: limit %d after %d, want it growing to at most 100", i, got, prev)
+		}
+		prev = l.Limit()
+	}
+	if prev != 100 {
+		t.Errorf("limit = %d after healthy windows, want 100", prev)
+	}
+}
+
+func TestAdjustHolds(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     int
+		slow  int
+		peak  int64
+		limit int
+	}{
+		{name: "no samples", n: 0, peak: 100, limit: 100},
+		{name: "healthy but idle", n: 100, peak: 79, limit: 100},
+		{name: "one slow request in 200", n: 200, slow: 1, peak: 50, limit: 100},
+		{name: "two slow requests in 200", n: 200, slow: 2, peak: 50, limit: 90},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newAdaptive()
+			window(l, tt.n, tt.slow, tt.peak)
+			if got := l.Limit(); got != tt.limit {
+				t.Errorf("limit = %d, want %d", got, tt.limit)
+			}
+		})
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	l := New(Options{MaxInFlight: 2})
+
+	if !l.Acquire() || !l.Acquire() {
+		t.Fatal("request within the limit rejected")
+	}
+	if l.Acquire() {
+		t.Fatal("request over the limit admitted")
+	}
+	if l.InFlight() != 2 || l.Shed() != 1 {
+		t.Errorf("in flight %d, shed %d, want 2 and 1", l.InFlight(), l.Shed())
+	}
+
+	l.Release(time.Millisecond)
+	if !l.Acquire() {
+		t.Error("released slot not reused")
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	l := New(Options{MaxInFlight: 50, Adaptive: true})
+	if l.opts.MinInFlight != 5 || l.opts.Window != time.Second || l.Limit() != 50 {
+		t.Errorf("options = %+v, limit %d, want MinInFlight 5, a 1s window and limit 50", l.opts, l.Limit())
+	}
+	if l := New(Options{MaxInFlight: 5, MinInFlight: 10}); l.opts.MinInFlight != 5 {
+		t.Errorf("MinInFlight = %d, want it capped at MaxInFlight", l.opts.MinInFlight)
+	}
+}
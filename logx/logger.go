@@ -1,9 +1,11 @@
 package logx
 
 import (
+	"errors"
 	"io"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -11,13 +13,18 @@ import (
 
 // Options describes logger configuration inputs.
 type Options struct {
-	Level     string // debug, info, warn, error, fatal
-	Format    string // json | console
+	Level      string // debug, info, warn, error, fatal
+	Format     string // json | console
 	TimeFormat string // RFC3339, unix, or Go layout
+
+	// Outputs lists sink specs (see parseSpec); empty logs to stdout with
+	// Level and Format
+	Outputs []string
 }
 
-// New returns a configured zerolog.Logger based on options.
-func New(opts Options) zerolog.Logger {
+// New returns a configured zerolog.Logger based on options. The closer
+// releases files and connections held by the outputs.
+func New(opts Options) (zerolog.Logger, io.Closer, error) {
 	lvl, err := zerolog.ParseLevel(opts.Level)
 	if err != nil {
 		lvl = zerolog.InfoLevel
@@ -43,12 +50,71 @@ func New(opts Options) zerolog.Logger {
 		zerolog.TimeFieldFormat = opts.TimeFormat
 	}
 
-	var out io.Writer = os.Stdout
-	if strings.ToLower(opts.Format) == "console" {
-		cw := zerolog.ConsoleWriter{Out: os.Stdout}
-		cw.TimeFormat = zerolog.TimeFieldFormat
-		out = cw
+	if len(opts.Outputs) == 0 {
+		var out io.Writer = os.Stdout
+		if strings.ToLower(opts.Format) == "console" {
+			cw := zerolog.ConsoleWriter{Out: os.Stdout}
+			cw.TimeFormat = zerolog.TimeFieldFormat
+			out = cw
+		}
+
+		sinkFloor.Store(int32(zerolog.Disabled))
+		SetLevel(lvl)
+		return zerolog.New(out).Level(zerolog.TraceLevel).With().Timestamp().Logger(), closers(nil), nil
+	}
+
+	// The global level lets through the most verbose sink's level; each
+	// sink drops what is below its own.
+	var (
+		writers []io.Writer
+		cs      closers
+		minLvl  = zerolog.Disabled
+	)
+	for _, spec := range opts.Outputs {
+		s, c, err := parseSink(spec, opts)
+		if err != nil {
+			cs.Close()
+			return zerolog.New(os.Stdout).With().Timestamp().Logger(), closers(nil), err
+		}
+		writers = append(writers, s)
+		if c != nil {
+			cs = append(cs, c)
+		}
+		if !s.follow {
+			minLvl = min(minLvl, s.level)
+		}
 	}
+	sinkFloor.Store(int32(minLvl))
+	SetLevel(lvl)
+
+	return zerolog.New(zerolog.MultiLevelWriter(writers...)).Level(zerolog.TraceLevel).With().Timestamp().Logger(), cs, nil
+}
+
+var (
+	level     atomic.Int32 // default level, see SetLevel
+	sinkFloor atomic.Int32 // most verbose level set by a sink spec itself
+)
+
+// SetLevel changes the level of the logger returned by New at runtime.
+// Outputs whose spec sets a level keep it.
+func SetLevel(l zerolog.Level) {
+	level.Store(int32(l))
+	zerolog.SetGlobalLevel(min(l, zerolog.Level(sinkFloor.Load())))
+}
 
-	return zerolog.New(out).Level(lvl).With().Timestamp().Logger()
+// Level returns the level set by New or SetLevel.
+func Level() zerolog.Level {
+	return zerolog.Level(level.Load())
+}
+
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var errs []error
+	for _, c := range cs {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,274 @@
+package logx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// sink is one log output with its own minimum level, or the default level
+// set by SetLevel when its spec sets none.
+type sink struct {
+	w      io.Writer
+	level  zerolog.Level
+	follow bool
+}
+
+// WriteLevel drops events below the sink's level.
+func (s sink) WriteLevel(l zerolog.Level, p []byte) (int, error) {
+	floor := s.level
+	if s.follow {
+		floor = Level()
+	}
+	if l < floor {
+		return len(p), nil
+	}
+	if lw, ok := s.w.(zerolog.LevelWriter); ok {
+		return lw.WriteLevel(l, p)
+	}
+	return s.w.Write(p)
+}
+
+func (s sink) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+// sinkSpec is a parsed output spec, see parseSpec.
+type sinkSpec struct {
+	kind   string // stdout, stderr, file or syslog
+	format string // empty uses the kind's default
+	level  zerolog.Level
+	follow bool
+
+	// file
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	// syslog
+	network  string
+	addr     string
+	facility string
+	tag      string
+}
+
+// parseSpec parses an output spec such as
+//
+//	stdout?format=console&level=info
+//	file:///var/log/ipcontext.log?level=debug&max_size_mb=100&max_age=24h&max_backups=7
+//	syslog+udp://logs.example.com:514?facility=local0&level=warn
+//	syslog+unix:///dev/log
+//
+// Level defaults to def's.
+func parseSpec(spec string, def Options) (sinkSpec, error) {
+	s, err := parseSpecURL(spec, def)
+	if err != nil {
+		return sinkSpec{}, fmt.Errorf("log output %q: %w", spec, err)
+	}
+	return s, nil
+}
+
+func parseSpecURL(spec string, def Options) (sinkSpec, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return sinkSpec{}, err
+	}
+	q := u.Query()
+
+	s := sinkSpec{kind: u.Scheme, format: q.Get("format"), follow: q.Get("level") == ""}
+	if s.kind == "" {
+		s.kind = u.Path
+	}
+	if s.level, err = parseLevel(q.Get("level"), def.Level); err != nil {
+		return sinkSpec{}, err
+	}
+	switch strings.ToLower(s.format) {
+	case "", "json", "console":
+	default:
+		return sinkSpec{}, fmt.Errorf("unknown format %q", s.format)
+	}
+
+	switch s.kind {
+	case "stdout", "stderr":
+		return s, nil
+	case "file":
+		return s, parseFileSpec(&s, u, q)
+	case "syslog", "syslog+udp", "syslog+tcp", "syslog+unix":
+		return s, parseSyslogSpec(&s, u, q)
+	}
+	return sinkSpec{}, errors.New("unknown output, expected stdout, stderr, file or syslog")
+}
+
+func parseFileSpec(s *sinkSpec, u *url.URL, q url.Values) error {
+	s.path = u.Path
+	if u.Opaque != "" {
+		s.path = u.Opaque // file:relative/path.log
+	}
+	if s.path == "" {
+		return errors.New("missing file path")
+	}
+
+	maxSizeMB, err := intParam(q, "max_size_mb", 100)
+	if err != nil {
+		return err
+	}
+	s.maxSize = int64(maxSizeMB) << 20
+	if s.maxBackups, err = intParam(q, "max_backups", 7); err != nil {
+		return err
+	}
+	if v := q.Get("max_age"); v != "" {
+		if s.maxAge, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("max_age: %w", err)
+		}
+	}
+	return nil
+}
+
+func parseSyslogSpec(s *sinkSpec, u *url.URL, q url.Values) error {
+	s.network = strings.TrimPrefix(strings.TrimPrefix(u.Scheme, "syslog"), "+")
+	s.addr = u.Host
+	switch s.network {
+	case "":
+		s.network = "udp"
+	case "unix":
+		s.addr = u.Path
+	}
+	if s.addr == "" {
+		return errors.New("missing syslog address")
+	}
+	if s.network != "unix" && u.Port() == "" {
+		s.addr += ":514"
+	}
+
+	s.facility = q.Get("facility")
+	if s.facility == "" {
+		s.facility = "local0"
+	}
+	if _, ok := facilities[strings.ToLower(s.facility)]; !ok {
+		return fmt.Errorf("unknown syslog facility %q", s.facility)
+	}
+	s.tag = q.Get("tag")
+	if s.tag == "" {
+		s.tag = "ipcontext"
+	}
+	return nil
+}
+
+// CheckOutputs reports invalid output specs without opening them.
+func CheckOutputs(specs []string) error {
+	var errs []error
+	for _, spec := range specs {
+		if _, err := parseSpec(spec, Options{}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parseSink opens the output described by spec, see parseSpec. Format
+// defaults to def's.
+func parseSink(spec string, def Options) (sink, io.Closer, error) {
+	s, err := parseSpec(spec, def)
+	if err != nil {
+		return sink{}, nil, err
+	}
+
+	var (
+		w      io.Writer
+		closer io.Closer
+	)
+	switch s.kind {
+	case "stdout", "stderr":
+		out := os.Stdout
+		if s.kind == "stderr" {
+			out = os.Stderr
+		}
+		w, err = withFormat(out, s.format, def.Format, false)
+	case "file":
+		w, closer, err = fileSink(s)
+	default:
+		w, closer, err = syslogSink(s)
+	}
+	if err != nil {
+		return sink{}, nil, fmt.Errorf("log output %q: %w", spec, err)
+	}
+
+	return sink{w: w, level: s.level, follow: s.follow}, closer, nil
+}
+
+func fileSink(s sinkSpec) (io.Writer, io.Closer, error) {
+	f, err := openRotatingFile(s.path, s.maxSize, s.maxAge, s.maxBackups)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Files default to JSON so they stay machine-readable.
+	w, err := withFormat(f, s.format, "json", true)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return w, f, nil
+}
+
+func syslogSink(s sinkSpec) (io.Writer, io.Closer, error) {
+	w, err := newSyslogWriter(s.network, s.addr, s.facility, s.tag)
+	if err != nil {
+		return nil, nil, err
+	}
+	if strings.EqualFold(s.format, "console") {
+		w.console = &zerolog.ConsoleWriter{Out: &w.body, NoColor: true, PartsExclude: []string{zerolog.TimestampFieldName}}
+	}
+	return w, w, nil
+}
+
+// withFormat wraps out in a console writer for the console format.
+func withFormat(out io.Writer, format, def string, noColor bool) (io.Writer, error) {
+	if format == "" {
+		format = def
+	}
+	switch strings.ToLower(format) {
+	case "", "json":
+		return out, nil
+	case "console":
+		cw := zerolog.ConsoleWriter{Out: out, NoColor: noColor}
+		cw.TimeFormat = zerolog.TimeFieldFormat
+		return cw, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func parseLevel(v, def string) (zerolog.Level, error) {
+	if v == "" {
+		lvl, err := zerolog.ParseLevel(def)
+		if err != nil {
+			return zerolog.InfoLevel, nil
+		}
+		return lvl, nil
+	}
+	lvl, err := zerolog.ParseLevel(v)
+	if err != nil {
+		return zerolog.NoLevel, fmt.Errorf("unknown level %q", v)
+	}
+	return lvl, nil
+}
+
+func intParam(q url.Values, key string, def int) (int, error) {
+	v := q.Get(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", key)
+	}
+	return n, nil
+}
@@ -0,0 +1,241 @@
+package logx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogQueueSize is how many messages may wait for a slow or unreachable
+// syslog server before new ones are dropped.
+const syslogQueueSize = 1024
+
+// syslogRetry is how long sending waits after a failed connection attempt
+// before trying again; messages in between are dropped.
+const syslogRetry = 5 * time.Second
+
+// dropped counts the messages dropped by all syslog outputs.
+var dropped atomic.Uint64
+
+// Dropped returns the number of log messages dropped because an output could
+// not keep up or was unreachable.
+func Dropped() uint64 {
+	return dropped.Load()
+}
+
+// syslogWriter sends each log event as an RFC 5424 message. Messages over
+// TCP use octet-counting framing (RFC 6587). Logging only queues the message:
+// a background goroutine sends it, so a slow or unreachable server never
+// blocks the caller.
+type syslogWriter struct {
+	network  string // udp, tcp or unix
+	addr     string
+	facility int
+	hostname string
+	appName  string
+	console  *zerolog.ConsoleWriter // formats the message body; nil sends JSON
+
+	mu     sync.Mutex // guards body, msg and closed
+	body   bytes.Buffer
+	msg    bytes.Buffer
+	closed bool
+
+	queue chan []byte
+	done  chan struct{} // closed when the sender has drained queue
+
+	// used by the sender only
+	conn    net.Conn
+	stream  bool // whether conn is a unix stream socket
+	retryAt time.Time
+}
+
+func newSyslogWriter(network, addr, facility, appName string) (*syslogWriter, error) {
+	fac, ok := facilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	w := &syslogWriter{
+		network:  network,
+		addr:     addr,
+		facility: fac,
+		hostname: hostname,
+		appName:  appName,
+	}
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+
+	w.queue = make(chan []byte, syslogQueueSize)
+	w.done = make(chan struct{})
+	go w.run()
+	return w, nil
+}
+
+func (w *syslogWriter) connect() error {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if w.network == "unix" {
+		// Local daemons listen on a datagram socket such as /dev/log;
+		// fall back to a stream socket for those that do not.
+		w.stream = false
+		if conn, err = net.Dial("unixgram", w.addr); err != nil {
+			conn, err = net.Dial("unix", w.addr)
+			w.stream = true
+		}
+	} else {
+		conn, err = net.DialTimeout(w.network, w.addr, 5*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+// severity maps zerolog levels to syslog severities.
+func severity(l zerolog.Level) int {
+	switch l {
+	case zerolog.PanicLevel:
+		return 1 // alert
+	case zerolog.FatalLevel:
+		return 2 // crit
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.InfoLevel:
+		return 6
+	case zerolog.DebugLevel, zerolog.TraceLevel:
+		return 7
+	}
+	return 5 // notice, for events logged without a level
+}
+
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *syslogWriter) WriteLevel(l zerolog.Level, p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, errors.New("syslog output closed")
+	}
+
+	body := p
+	if w.console != nil {
+		w.body.Reset()
+		if _, err := w.console.Write(p); err != nil {
+			return 0, err
+		}
+		body = w.body.Bytes()
+	}
+	body = bytes.TrimRight(body, "\n")
+
+	w.msg.Reset()
+	fmt.Fprintf(&w.msg, "<%d>1 %s %s %s %d - - ",
+		w.facility*8+severity(l),
+		time.Now().Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname, w.appName, os.Getpid())
+	w.msg.Write(body)
+
+	select {
+	case w.queue <- bytes.Clone(w.msg.Bytes()):
+	default:
+		dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// run sends queued messages until the queue is closed.
+func (w *syslogWriter) run() {
+	defer close(w.done)
+	for msg := range w.queue {
+		if err := w.send(msg); err != nil {
+			dropped.Add(1)
+		}
+	}
+	if w.conn != nil {
+		w.conn.Close()
+	}
+}
+
+// send writes msg, reconnecting once if the connection was lost. After a
+// failed connection attempt it gives up until syslogRetry has passed.
+func (w *syslogWriter) send(msg []byte) error {
+	if w.conn != nil {
+		w.conn.SetWriteDeadline(time.Now().Add(syslogRetry))
+		if _, err := w.conn.Write(w.frame(msg)); err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	if time.Now().Before(w.retryAt) {
+		return errors.New("syslog server unreachable")
+	}
+	if err := w.connect(); err != nil {
+		w.retryAt = time.Now().Add(syslogRetry)
+		return err
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(syslogRetry))
+	_, err := w.conn.Write(w.frame(msg))
+	return err
+}
+
+// frame delimits msg for stream transports, which can change on reconnect.
+func (w *syslogWriter) frame(msg []byte) []byte {
+	switch {
+	case w.network == "tcp":
+		return append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	case w.stream:
+		return append(msg, '\n')
+	}
+	return msg
+}
+
+// Close sends the queued messages and closes the connection. It waits at
+// most syslogRetry for a slow server.
+func (w *syslogWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-time.After(syslogRetry):
+		return fmt.Errorf("syslog output: %d messages not sent", len(w.queue))
+	}
+}
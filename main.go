@@ -2,74 +2,619 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/andreybrigunet/IpContext/access"
+	"github.com/andreybrigunet/IpContext/admin"
+	"github.com/andreybrigunet/IpContext/apikey"
+	"github.com/andreybrigunet/IpContext/clientip"
 	"github.com/andreybrigunet/IpContext/config"
 	"github.com/andreybrigunet/IpContext/coordinator"
+	"github.com/andreybrigunet/IpContext/geodns"
 	"github.com/andreybrigunet/IpContext/geoip"
 	"github.com/andreybrigunet/IpContext/languages"
+	"github.com/andreybrigunet/IpContext/loadshed"
 	"github.com/andreybrigunet/IpContext/logx"
+	"github.com/andreybrigunet/IpContext/metrics"
 	"github.com/andreybrigunet/IpContext/neighbours"
+	"github.com/andreybrigunet/IpContext/precision"
+	"github.com/andreybrigunet/IpContext/privacy"
+	"github.com/andreybrigunet/IpContext/proxy"
+	"github.com/andreybrigunet/IpContext/ratelimit"
 	"github.com/andreybrigunet/IpContext/server"
+	"github.com/andreybrigunet/IpContext/tlsx"
+	"github.com/andreybrigunet/IpContext/tracing"
+	"github.com/andreybrigunet/IpContext/usage"
 	"github.com/rs/zerolog"
 )
 
+const version = "v1.0.3"
+
 func main() {
-	cfg := config.Load()
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
+
+	cfg, err := config.Load(os.Args[1:], configChecks())
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		printConfigError(err)
+		os.Exit(1)
+	}
+	var current atomic.Pointer[config.Config]
+	current.Store(cfg)
 
-	logger := logx.New(logx.Options{
+	logger, logOutputs, err := logx.New(logx.Options{
 		Level:      cfg.LogLevel,
 		Format:     cfg.LogFormat,
 		TimeFormat: cfg.LogTimeFmt,
+		Outputs:    cfg.LogOutputs,
 	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid LOG_OUTPUTS")
+	}
+	defer logOutputs.Close()
 
-	logger.Info().Msg("Starting IP API service v1.0.3")
+	anon := initializePrivacy(cfg, logger)
+
+	logger.Info().Msg("Starting IP API service " + version)
+	if cfg.File != "" {
+		logger.Info().Str("file", cfg.File).Msg("Configuration file loaded")
+	}
 
 	neighStore, langStore := initializeStores(cfg, logger)
 
 	cacheTTL := time.Duration(cfg.CacheTTLMinutes) * time.Minute
-	geoIP, err := geoip.New(cfg.DBPath, neighStore, langStore, logger, cacheTTL)
-	if err != nil {
-		logger.Fatal().
+	geoIP := geoip.NewUnloaded(cfg.DBPath, neighStore, langStore, anon, logger, cacheTTL)
+	if err := geoIP.Reload(); err != nil {
+		// Serve 503s until the databases show up, e.g. from geoipupdate,
+		// instead of crash-looping.
+		logger.Warn().
 			Err(err).
 			Str("dbPath", cfg.DBPath).
-			Msg("Failed to initialize GeoIP service. Ensure MaxMind databases exist at DB_PATH env (e.g., /app/data)")
+			Msg("MaxMind databases not available yet; lookups fail until they are loaded from DB_PATH")
 	}
 
-	srv := server.NewServer(cfg.ListenAddr, geoIP, logger)
-
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	geoIP.Start(ctx, time.Duration(cfg.DBReloadSeconds)*time.Second)
+
+	shutdownTracing, tracingEnabled := initializeTracing(ctx, cfg, logger)
+
+	resolver := initializeClientIP(cfg, logger)
+	ppTrusted := initializeProxyProtocol(cfg)
+
+	socketMode := parseSocketMode(cfg)
+	meter := initializeUsage(ctx, cfg, logger)
+	shedder := initializeLoadShed(cfg, logger)
+	metricsReg := initializeMetrics(cfg, geoIP, neighStore, langStore, shedder)
+	precisionPolicy := initializePrecision(cfg, logger)
+	apiKeys := initializeAPIKeys(ctx, cfg, precisionPolicy, logger)
+
+	srv := server.NewServer(server.Options{
+		Addr:            cfg.ListenAddr,
+		SocketMode:      socketMode,
+		Access:          initializeAccess(ctx, cfg, geoIP, logger),
+		ClientIP:        resolver,
+		APIKeys:         apiKeys,
+		APIKeysRequired: cfg.APIKeysRequired,
+		Usage:           meter,
+		RateLimits:      initializeRateLimits(cfg, logger),
+		LoadShed:        shedder,
+		Metrics:         metricsReg,
+		Tracing:         tracingEnabled,
+		AccessLog:       initializeAccessLog(cfg, logger),
+		Precision:       precisionPolicy,
+		Privacy:         anon,
+		Version:         version,
+		Stores:          statusStores(neighStore, langStore),
+		Readiness: server.Readiness{
+			MaxDatabaseAge:   time.Duration(cfg.ReadyMaxDBAgeDays) * 24 * time.Hour,
+			MinStoreCoverage: float64(cfg.ReadyMinGeoNamesCoverage) / 100,
+		},
+		ProxyProtocol:        cfg.ProxyProtocolEnabled("api"),
+		ProxyProtocolTrusted: ppTrusted,
+		ProxyProtocolUnix:    cfg.TrustUnixSockets,
+		TLSAddr:              cfg.TLSListenAddr,
+		TLS:                  initializeTLS(ctx, cfg, logger),
+		TLSProxyProtocol:     cfg.ProxyProtocolEnabled("tls"),
+		CORS:                 corsPolicy(cfg),
+	}, geoIP, logger)
+
+	var coord *coordinator.Coordinator
 	if neighStore != nil || langStore != nil {
-		intervals := coordinator.Intervals{
-			Neighbours: calculateInterval(cfg.NeighboursUpdateHours),
-			Languages:  calculateInterval(cfg.LanguagesUpdateHours),
-		}
-		coord := coordinator.New(neighStore, langStore, intervals, logger)
+		coord = coordinator.New(neighStore, langStore, refreshIntervals(cfg), logger)
 		coord.Start(ctx)
 	}
 
+	go watchConfig(ctx, cfg, logger, func(next *config.Config, live []string) {
+		current.Store(next)
+		applyConfig(next, live, geoIP, srv, coord, logger)
+	})
+
 	go func() {
-		if err := srv.Start(); err != nil {
+		if err := srv.Start(); err != nil && err != http.ErrServerClosed {
 			logger.Fatal().Err(err).Msg("Server error")
 		}
 	}()
 
+	dnsSrv := startGeoDNS(cfg, geoIP, anon, logger)
+	proxySrv := startProxy(cfg, resolver, ppTrusted, socketMode, geoIP, precisionPolicy, anon, logger)
+	metricsSrv := startMetrics(cfg, socketMode, metricsReg, logger)
+	adminSrv := startAdmin(cfg, socketMode, apiKeys, meter, geoIP, neighStore, langStore, func() interface{} { return current.Load().Redacted() }, anon, logger)
+
 	<-ctx.Done()
 	logger.Info().Msg("Shutting down...")
 
+	if dnsSrv != nil {
+		if err := dnsSrv.Stop(); err != nil {
+			logger.Error().Err(err).Msg("Error during GeoDNS shutdown")
+		}
+	}
+
+	if proxySrv != nil {
+		if err := proxySrv.Stop(); err != nil {
+			logger.Error().Err(err).Msg("Error during proxy shutdown")
+		}
+	}
+
+	if metricsSrv != nil {
+		if err := metricsSrv.Stop(); err != nil {
+			logger.Error().Err(err).Msg("Error during metrics server shutdown")
+		}
+	}
+
+	if adminSrv != nil {
+		if err := adminSrv.Stop(); err != nil {
+			logger.Error().Err(err).Msg("Error during admin server shutdown")
+		}
+	}
 
 	if err := srv.Stop(); err != nil {
 		logger.Error().Err(err).Msg("Error during server shutdown")
 	} else {
 		logger.Info().Msg("Server stopped gracefully")
 	}
+
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(tracingCtx); err != nil {
+		logger.Error().Err(err).Msg("Error flushing traces")
+	}
+	cancelTracing()
+
+	// After the server so that counts from drained requests are persisted
+	if meter != nil {
+		if err := meter.Close(); err != nil {
+			logger.Error().Err(err).Msg("Error saving usage counters")
+		}
+	}
+}
+
+// initializePrivacy returns the anonymizer for addresses in logs, nil when
+// PRIVACY_MODE is off.
+func initializePrivacy(cfg *config.Config, logger zerolog.Logger) *privacy.Anonymizer {
+	anon, err := privacy.New(privacy.Options{
+		Mode:        cfg.PrivacyMode,
+		IPv4Prefix:  cfg.PrivacyIPv4Prefix,
+		IPv6Prefix:  cfg.PrivacyIPv6Prefix,
+		KeyRotation: time.Duration(cfg.PrivacyHashRotationHours) * time.Hour,
+	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid PRIVACY_MODE settings")
+	}
+	if anon == nil {
+		return nil
+	}
+
+	evt := logger.Info().Str("mode", anon.Mode())
+	if anon.Mode() == privacy.ModeTruncate {
+		evt = evt.Int("ipv4Prefix", cfg.PrivacyIPv4Prefix).Int("ipv6Prefix", cfg.PrivacyIPv6Prefix)
+	} else {
+		evt = evt.Int("keyRotationHours", cfg.PrivacyHashRotationHours)
+	}
+	evt.Msg("IP anonymization enabled")
+	return anon
+}
+
+func initializeClientIP(cfg *config.Config, logger zerolog.Logger) *clientip.Resolver {
+	trusted, err := clientip.ParseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid TRUSTED_PROXIES")
+	}
+
+	if cfg.ClientIPHeader == "" {
+		// No header may carry the client address, so nobody needs to be trusted.
+		trusted = nil
+	}
+
+	return clientip.New(clientip.Options{
+		Trusted:          trusted,
+		Header:           cfg.ClientIPHeader,
+		TrustUnixSockets: cfg.TrustUnixSockets,
+	})
+}
+
+// parseSocketMode converts UNIX_SOCKET_MODE, which config.Load has checked.
+func parseSocketMode(cfg *config.Config) os.FileMode {
+	mode, _ := strconv.ParseUint(cfg.SocketMode, 8, 32)
+	return os.FileMode(mode)
+}
+
+// initializeProxyProtocol converts PROXY_PROTOCOL_TRUSTED, which config.Load
+// has checked.
+func initializeProxyProtocol(cfg *config.Config) []*net.IPNet {
+	trusted, _ := clientip.ParseCIDRs(cfg.ProxyProtocolTrusted)
+	return trusted
+}
+
+func initializeTLS(ctx context.Context, cfg *config.Config, logger zerolog.Logger) *tls.Config {
+	if cfg.TLSListenAddr == "" {
+		return nil
+	}
+
+	reloader, err := tlsx.New(tlsx.Options{
+		CertFile:     cfg.TLSCertFile,
+		KeyFile:      cfg.TLSKeyFile,
+		ClientCAFile: cfg.TLSClientCAFile,
+		ClientAuth:   cfg.TLSClientAuth,
+		MinVersion:   cfg.TLSMinVersion,
+	}, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to load TLS certificate")
+	}
+
+	tlsConfig, err := reloader.Config()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid TLS configuration")
+	}
+
+	reloader.Start(ctx, time.Duration(cfg.TLSReloadSeconds)*time.Second)
+	return tlsConfig
+}
+
+func initializeAccess(ctx context.Context, cfg *config.Config, geoIP *geoip.GeoIP, logger zerolog.Logger) *access.Engine {
+	if cfg.AccessRulesFile == "" {
+		return nil
+	}
+
+	reload := time.Duration(cfg.AccessReloadSeconds) * time.Second
+	engine, err := access.New(cfg.AccessRulesFile, reload, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Str("file", cfg.AccessRulesFile).Msg("Failed to load access rules")
+	}
+
+	if engine.NeedsAnonymousDB() {
+		// The databases may still be on their way, so check once they load.
+		go func() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-geoIP.WhenLoaded():
+			}
+			if !geoIP.HasAnonymousDB() {
+				logger.Warn().Msg("Access rules use anonymity flags but GeoIP2-Anonymous-IP.mmdb is not present; those rules will never match")
+			}
+		}()
+	}
+
+	engine.Start(ctx)
+	return engine
+}
+
+func initializeAPIKeys(ctx context.Context, cfg *config.Config, policy *precision.Policy, logger zerolog.Logger) *apikey.Store {
+	if cfg.APIKeysFile == "" {
+		return nil
+	}
+
+	reload := time.Duration(cfg.APIKeysReloadSeconds) * time.Second
+	store, err := apikey.New(cfg.APIKeysFile, reload, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Str("file", cfg.APIKeysFile).Msg("Failed to load API keys")
+	}
+
+	err = store.SetCheck(func(k *apikey.Key) error {
+		if name := k.Scopes.Precision; name != "" {
+			if _, ok := policy.Profile(name); !ok {
+				return fmt.Errorf("unknown precision profile %q", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid API keys")
+	}
+
+	store.Start(ctx)
+	return store
+}
+
+func initializePrecision(cfg *config.Config, logger zerolog.Logger) *precision.Policy {
+	// Checked by config.Load, but the profiles file may have changed since.
+	policy, err := precision.NewPolicy(cfg.PrecisionProfilesFile, cfg.PrecisionProfile, cfg.PrecisionRouteProfiles())
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid precision profiles")
+	}
+
+	logger.Info().
+		Str("default", cfg.PrecisionProfile).
+		Strs("routes", cfg.PrecisionRoutes).
+		Strs("profiles", policy.Names()).
+		Msg("Precision profiles loaded")
+
+	return policy
+}
+
+func initializeUsage(ctx context.Context, cfg *config.Config, logger zerolog.Logger) *usage.Meter {
+	if cfg.UsageDBFile == "" {
+		return nil
+	}
+	if cfg.APIKeysFile == "" {
+		logger.Warn().Msg("USAGE_DB_FILE is set but API keys are disabled; usage metering needs API_KEYS_FILE")
+		return nil
+	}
+
+	meter, err := usage.Open(cfg.UsageDBFile, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to open usage database")
+	}
+
+	meter.Start(ctx, time.Duration(cfg.UsageFlushSeconds)*time.Second)
+	return meter
+}
+
+func initializeTracing(ctx context.Context, cfg *config.Config, logger zerolog.Logger) (func(context.Context) error, bool) {
+	shutdown, err := tracing.Setup(ctx, tracing.Options{
+		Exporter:    cfg.TracingExporter,
+		Endpoint:    cfg.TracingEndpoint,
+		ServiceName: "ipcontext",
+		Version:     version,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to set up tracing")
+	}
+
+	exporter := strings.ToLower(cfg.TracingExporter)
+	if exporter == "" || exporter == "none" {
+		return shutdown, false
+	}
+
+	logger.Info().
+		Str("exporter", exporter).
+		Float64("sampleRatio", cfg.TracingSampleRatio).
+		Msg("Tracing enabled")
+
+	return shutdown, true
+}
+
+// statusStores lists the enabled GeoNames stores for /ready and /status.
+func statusStores(neighStore *neighbours.Store, langStore *languages.Store) map[string]server.StoreStatus {
+	stores := make(map[string]server.StoreStatus)
+	if neighStore != nil {
+		stores["neighbours"] = neighStore
+	}
+	if langStore != nil {
+		stores["languages"] = langStore
+	}
+	return stores
 }
 
+func initializeMetrics(cfg *config.Config, geoIP *geoip.GeoIP, neighStore *neighbours.Store, langStore *languages.Store, shedder *loadshed.Limiter) *metrics.Metrics {
+	if cfg.MetricsListenAddr == "" {
+		return nil
+	}
+
+	m := metrics.New()
+	m.RegisterGeoIP(geoIP)
+	if neighStore != nil {
+		m.RegisterStore("neighbours", neighStore)
+	}
+	if langStore != nil {
+		m.RegisterStore("languages", langStore)
+	}
+
+	if shedder != nil {
+		m.RegisterLoadShed(shedder)
+	}
+	if len(cfg.LogOutputs) > 0 {
+		m.RegisterLogDrops(logx.Dropped)
+	}
+
+	return m
+}
+
+func startMetrics(cfg *config.Config, socketMode os.FileMode, m *metrics.Metrics, logger zerolog.Logger) *metrics.Server {
+	if m == nil {
+		return nil
+	}
+
+	metricsSrv := metrics.NewServer(cfg.MetricsListenAddr, socketMode, m, logger)
+	go func() {
+		if err := metricsSrv.Start(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal().Err(err).Msg("Metrics server error")
+		}
+	}()
+
+	return metricsSrv
+}
+
+func startAdmin(cfg *config.Config, socketMode os.FileMode, apiKeys *apikey.Store, meter *usage.Meter, geoIP *geoip.GeoIP, neighStore *neighbours.Store, langStore *languages.Store, configView func() interface{}, anon *privacy.Anonymizer, logger zerolog.Logger) *admin.Server {
+	if cfg.AdminListenAddr == "" {
+		return nil
+	}
+
+	allowed, _ := clientip.ParseCIDRs(cfg.AdminAllowedCIDRs) // checked by config.Load
+	if apiKeys == nil {
+		logger.Warn().Msg("API keys are disabled; admin API callers are not authenticated, only restricted by ADMIN_ALLOWED_CIDRS")
+	}
+
+	stores := make(map[string]admin.Store)
+	if neighStore != nil {
+		stores["neighbours"] = neighStore
+	}
+	if langStore != nil {
+		stores["languages"] = langStore
+	}
+
+	adminSrv := admin.NewServer(admin.Options{
+		Addr:       cfg.AdminListenAddr,
+		SocketMode: socketMode,
+		APIKeys:    apiKeys,
+		Allowed:    allowed,
+		Usage:      meter,
+		Stores:     stores,
+		Config:     configView,
+		Pprof:      cfg.AdminPprof,
+		Privacy:    anon,
+	}, geoIP, logger)
+	go func() {
+		if err := adminSrv.Start(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal().Err(err).Msg("Admin server error")
+		}
+	}()
+	return adminSrv
+}
+
+func initializeLoadShed(cfg *config.Config, logger zerolog.Logger) *loadshed.Limiter {
+	if cfg.MaxInFlight <= 0 {
+		return nil
+	}
+
+	limiter := loadshed.New(loadshed.Options{
+		MaxInFlight:   cfg.MaxInFlight,
+		Adaptive:      cfg.AdaptiveConcurrency,
+		TargetLatency: time.Duration(cfg.TargetLatencyMs) * time.Millisecond,
+		MinInFlight:   cfg.MinInFlight,
+	})
+	limiter.OnChange(func(old, new int, p99 time.Duration) {
+		logger.Info().
+			Int("from", old).
+			Int("to", new).
+			Dur("p99", p99).
+			Msg("Adjusted concurrency limit")
+	})
+
+	logger.Info().
+		Int("maxInFlight", cfg.MaxInFlight).
+		Bool("adaptive", cfg.AdaptiveConcurrency).
+		Msg("Load shedding enabled")
+
+	return limiter
+}
+
+func initializeAccessLog(cfg *config.Config, logger zerolog.Logger) *server.AccessLog {
+	if !cfg.AccessLog {
+		return nil
+	}
+
+	fields := cfg.AccessLogFields
+	if len(fields) == 0 {
+		fields = server.DefaultAccessLogFields
+	}
+	logger.Info().
+		Strs("fields", fields).
+		Float64("sampleRate", cfg.AccessLogSampleRate).
+		Msg("Access logging enabled")
+
+	return &server.AccessLog{Fields: fields, SampleRate: cfg.AccessLogSampleRate}
+}
+
+func initializeRateLimits(cfg *config.Config, logger zerolog.Logger) *server.RateLimits {
+	// The specs are checked by config.Load.
+	parse := func(spec string) ratelimit.Limit {
+		limit, _ := ratelimit.ParseLimit(spec)
+		return limit
+	}
+
+	limits := &server.RateLimits{
+		IP:         parse(cfg.RateLimitIP),
+		CIDR:       parse(cfg.RateLimitCIDR),
+		Key:        parse(cfg.RateLimitKey),
+		IPv4Prefix: cfg.RateLimitIPv4Prefix,
+		IPv6Prefix: cfg.RateLimitIPv6Prefix,
+		MaxEntries: cfg.RateLimitMaxEntries,
+	}
+
+	// Keys may carry their own limits, so keep the limiter when API keys are on.
+	if !limits.IP.Enabled() && !limits.CIDR.Enabled() && !limits.Key.Enabled() && cfg.APIKeysFile == "" {
+		return nil
+	}
+
+	logger.Info().
+		Str("ip", limits.IP.String()).
+		Str("cidr", limits.CIDR.String()).
+		Str("key", limits.Key.String()).
+		Msg("Rate limiting enabled")
+
+	return limits
+}
+
+func startGeoDNS(cfg *config.Config, geoIP *geoip.GeoIP, anon *privacy.Anonymizer, logger zerolog.Logger) *geodns.Server {
+	if cfg.DNSListenAddr == "" {
+		return nil
+	}
+
+	rules, err := geodns.LoadRules(cfg.DNSRulesFile)
+	if err != nil {
+		logger.Fatal().Err(err).Str("file", cfg.DNSRulesFile).Msg("Failed to load GeoDNS rules")
+	}
+
+	dnsSrv := geodns.New(cfg.DNSListenAddr, rules, geoIP, anon, logger)
+	go func() {
+		if err := dnsSrv.Start(); err != nil {
+			logger.Fatal().Err(err).Msg("GeoDNS server error")
+		}
+	}()
+
+	return dnsSrv
+}
+
+func startProxy(cfg *config.Config, resolver *clientip.Resolver, ppTrusted []*net.IPNet, socketMode os.FileMode, geoIP *geoip.GeoIP, policy *precision.Policy, anon *privacy.Anonymizer, logger zerolog.Logger) *proxy.Server {
+	if cfg.ProxyUpstream == "" {
+		return nil
+	}
+
+	// Both are checked by config.Load.
+	upstream, _ := url.Parse(cfg.ProxyUpstream)
+	headers, _ := proxy.ParseHeaders(cfg.ProxyHeaders)
+
+	proxySrv := proxy.New(proxy.Options{
+		Addr:                 cfg.ProxyListenAddr,
+		SocketMode:           socketMode,
+		Upstream:             upstream,
+		Headers:              headers,
+		ClientIP:             resolver,
+		Precision:            policy,
+		Privacy:              anon,
+		ProxyProtocol:        cfg.ProxyProtocolEnabled("proxy"),
+		ProxyProtocolTrusted: ppTrusted,
+		ProxyProtocolUnix:    cfg.TrustUnixSockets,
+	}, geoIP, logger)
+	go func() {
+		if err := proxySrv.Start(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal().Err(err).Msg("Proxy server error")
+		}
+	}()
+
+	return proxySrv
+}
 
 func initializeStores(cfg *config.Config, logger zerolog.Logger) (*neighbours.Store, *languages.Store) {
 	if cfg.GeoNamesUser == "" {
@@ -78,7 +623,7 @@ func initializeStores(cfg *config.Config, logger zerolog.Logger) (*neighbours.St
 	}
 
 	countryCodes := geoip.CountryCodes()
-	
+
 	neighInterval := calculateInterval(cfg.NeighboursUpdateHours)
 	neighStore := neighbours.New(cfg.GeoNamesUser, neighInterval, countryCodes, logger)
 
@@ -88,6 +633,102 @@ func initializeStores(cfg *config.Config, logger zerolog.Logger) (*neighbours.St
 	return neighStore, langStore
 }
 
+func corsPolicy(cfg *config.Config) *server.CORS {
+	return &server.CORS{AllowedOrigins: cfg.CORSAllowedOrigins, MaxAge: cfg.CORSMaxAgeSeconds}
+}
+
+func refreshIntervals(cfg *config.Config) coordinator.Intervals {
+	return coordinator.Intervals{
+		Neighbours: calculateInterval(cfg.NeighboursUpdateHours),
+		Languages:  calculateInterval(cfg.LanguagesUpdateHours),
+	}
+}
+
+// watchConfig reloads the configuration on SIGHUP and when the config file
+// changes. apply receives it with the changed settings that take effect
+// live; the others are reported as needing a restart.
+func watchConfig(ctx context.Context, cfg *config.Config, logger zerolog.Logger, apply func(next *config.Config, live []string)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var poll <-chan time.Time
+	if cfg.File != "" && cfg.ConfigReloadSeconds > 0 {
+		ticker := time.NewTicker(time.Duration(cfg.ConfigReloadSeconds) * time.Second)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	modTime := fileModTime(cfg.File)
+
+	// Live settings are compared with the last applied configuration, the
+	// others with the one the service started with.
+	applied := cfg
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logger.Info().Msg("SIGHUP received, reloading configuration")
+		case <-poll:
+			mt := fileModTime(cfg.File)
+			if mt.Equal(modTime) {
+				continue
+			}
+			modTime = mt
+			logger.Info().Str("file", cfg.File).Msg("Configuration file changed, reloading")
+		}
+
+		next, err := cfg.Reload()
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to reload configuration; keeping the current one")
+			continue
+		}
+
+		live, _ := applied.Changes(next)
+		_, restart := cfg.Changes(next)
+		apply(next, live)
+		applied = next
+
+		logger.Info().Strs("applied", live).Msg("Configuration reloaded")
+		if len(restart) > 0 {
+			logger.Warn().Strs("settings", restart).Msg("Changed settings take effect after a restart")
+		}
+	}
+}
+
+func fileModTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// applyConfig applies the settings in live, which changed in cfg.
+func applyConfig(cfg *config.Config, live []string, geoIP *geoip.GeoIP, srv *server.Server, coord *coordinator.Coordinator, logger zerolog.Logger) {
+	for _, env := range live {
+		switch env {
+		case "LOG_LEVEL":
+			lvl, err := zerolog.ParseLevel(cfg.LogLevel)
+			if err != nil {
+				logger.Warn().Str("level", cfg.LogLevel).Msg("Invalid LOG_LEVEL; keeping the current level")
+				continue
+			}
+			logx.SetLevel(lvl)
+		case "CACHE_TTL_MINUTES":
+			geoIP.SetCacheTTL(time.Duration(cfg.CacheTTLMinutes) * time.Minute)
+		case "CORS_ALLOWED_ORIGINS", "CORS_MAX_AGE_SECONDS":
+			srv.SetCORS(*corsPolicy(cfg))
+		case "NEIGHBOURS_UPDATE_HOURS", "LANGUAGES_UPDATE_HOURS":
+			if coord != nil {
+				coord.SetIntervals(refreshIntervals(cfg))
+			}
+		}
+	}
+}
 
 func calculateInterval(hours int) time.Duration {
 	if hours > 0 {
@@ -96,3 +737,69 @@ func calculateInterval(hours int) time.Duration {
 
 	return 168 * time.Hour
 }
+
+// configChecks validates the settings whose formats belong to other
+// packages.
+func configChecks() config.Checks {
+	return config.Checks{
+		LogOutputs:      logx.CheckOutputs,
+		AccessLogFields: server.CheckAccessLogFields,
+		TracingEndpoint: tracing.CheckEndpoint,
+		RateLimit: func(spec string) error {
+			_, err := ratelimit.ParseLimit(spec)
+			return err
+		},
+		ProxyHeaders: func(spec string) error {
+			_, err := proxy.ParseHeaders(spec)
+			return err
+		},
+		PrecisionRoutes: server.PrecisionRoutes,
+		Precision: func(profilesFile, profile string, routes map[string]string) error {
+			_, err := precision.NewPolicy(profilesFile, profile, routes)
+			return err
+		},
+		TLS: func(certFile, keyFile, clientCAFile string) error {
+			return tlsx.Check(tlsx.Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCAFile})
+		},
+	}
+}
+
+// configCommand runs "ipcontext config check [flags]", which validates the
+// configuration and prints every setting with its value and source.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "Usage: ipcontext config check [flags]")
+		return 2
+	}
+
+	cfg, err := config.Load(args[1:], configChecks())
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		printConfigError(err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tENV\tVALUE\tSOURCE")
+	for _, v := range cfg.Values() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Key, v.Env, v.Value, v.Source)
+	}
+	w.Flush()
+
+	if cfg.File != "" {
+		fmt.Printf("\nConfiguration is valid (config file %s)\n", cfg.File)
+	} else {
+		fmt.Println("\nConfiguration is valid")
+	}
+	return 0
+}
+
+// printConfigError lists configuration errors, one per line.
+func printConfigError(err error) {
+	fmt.Fprintln(os.Stderr, "Invalid configuration:")
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintln(os.Stderr, "  "+line)
+	}
+}
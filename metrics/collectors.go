@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cacheHitsDesc = prometheus.NewDesc(namespace+"_cache_hits_total",
+		"Lookups answered from the response cache.", nil, nil)
+	cacheMissesDesc = prometheus.NewDesc(namespace+"_cache_misses_total",
+		"Lookups not found in the response cache.", nil, nil)
+	cacheEvictionsDesc = prometheus.NewDesc(namespace+"_cache_evictions_total",
+		"Expired entries removed from the response cache.", nil, nil)
+	cacheSizeDesc = prometheus.NewDesc(namespace+"_cache_items",
+		"Entries currently in the response cache.", nil, nil)
+	lookupErrorsDesc = prometheus.NewDesc(namespace+"_lookup_errors_total",
+		"Lookup errors by kind.", []string{"kind"}, nil)
+	dbBuildDesc = prometheus.NewDesc(namespace+"_database_build_timestamp_seconds",
+		"Build time of each loaded MaxMind database.", []string{"edition"}, nil)
+	dbAgeDesc = prometheus.NewDesc(namespace+"_database_age_seconds",
+		"Time since each loaded MaxMind database was built.", []string{"edition"}, nil)
+
+	refreshDesc = prometheus.NewDesc(namespace+"_geonames_refresh_total",
+		"Per-country GeoNames refreshes by store and result.", []string{"store", "result"}, nil)
+	lastSuccessDesc = prometheus.NewDesc(namespace+"_geonames_last_success_timestamp_seconds",
+		"Time of the last successful GeoNames refresh by store (0 if none).", []string{"store"}, nil)
+)
+
+type geoipCollector struct {
+	g *geoip.GeoIP
+}
+
+func (c *geoipCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{cacheHitsDesc, cacheMissesDesc, cacheEvictionsDesc, cacheSizeDesc, lookupErrorsDesc, dbBuildDesc, dbAgeDesc} {
+		ch <- d
+	}
+}
+
+func (c *geoipCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.g.CacheStats()
+	ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(st.Hits))
+	ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(st.Misses))
+	ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(st.Evictions))
+	ch <- prometheus.MustNewConstMetric(cacheSizeDesc, prometheus.GaugeValue, float64(st.Size))
+
+	for kind, n := range c.g.LookupErrors() {
+		ch <- prometheus.MustNewConstMetric(lookupErrorsDesc, prometheus.CounterValue, float64(n), kind)
+	}
+
+	now := time.Now()
+	for _, db := range c.g.Databases() {
+		ch <- prometheus.MustNewConstMetric(dbBuildDesc, prometheus.GaugeValue, float64(db.BuildTime.Unix()), db.Edition)
+		ch <- prometheus.MustNewConstMetric(dbAgeDesc, prometheus.GaugeValue, now.Sub(db.BuildTime).Seconds(), db.Edition)
+	}
+}
+
+type storeCollector struct {
+	name string
+	s    RefreshStatter
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- refreshDesc
+	ch <- lastSuccessDesc
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	ok, failed, last := c.s.RefreshStats()
+	ch <- prometheus.MustNewConstMetric(refreshDesc, prometheus.CounterValue, float64(ok), c.name, "success")
+	ch <- prometheus.MustNewConstMetric(refreshDesc, prometheus.CounterValue, float64(failed), c.name, "failure")
+
+	var ts float64
+	if !last.IsZero() {
+		ts = float64(last.Unix())
+	}
+	ch <- prometheus.MustNewConstMetric(lastSuccessDesc, prometheus.GaugeValue, ts, c.name)
+}
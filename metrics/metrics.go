@@ -0,0 +1,134 @@
+// Package metrics exposes service metrics in the Prometheus format. Request
+// metrics are recorded by middleware; everything else is read from the
+// components at scrape time, so they do not depend on this package.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+	"github.com/andreybrigunet/IpContext/loadshed"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ipcontext"
+
+// Metrics owns a registry with the service's collectors.
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+}
+
+// New creates a registry with HTTP, Go runtime and process metrics.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route and status code.",
+		}, []string{"route", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests currently being served.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.inFlight,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records request counts, latency and in-flight requests. route
+// maps a request to a label of bounded cardinality.
+func (m *Metrics) Middleware(route func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.inFlight.Inc()
+			defer m.inFlight.Dec()
+
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+
+			label := route(r)
+			m.requests.WithLabelValues(label, strconv.Itoa(sw.status)).Inc()
+			m.duration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// RegisterGeoIP exports cache, lookup error and database metrics of g.
+func (m *Metrics) RegisterGeoIP(g *geoip.GeoIP) {
+	m.registry.MustRegister(&geoipCollector{g: g})
+}
+
+// RefreshStatter is implemented by the GeoNames stores.
+type RefreshStatter interface {
+	RefreshStats() (succeeded, failed uint64, lastSuccess time.Time)
+}
+
+// RegisterStore exports the refresh counters of a GeoNames store.
+func (m *Metrics) RegisterStore(name string, s RefreshStatter) {
+	m.registry.MustRegister(&storeCollector{name: name, s: s})
+}
+
+// RegisterLoadShed exports the current concurrency limit and shed requests.
+func (m *Metrics) RegisterLoadShed(l *loadshed.Limiter) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "concurrency_limit",
+			Help:      "Current in-flight request limit.",
+		}, func() float64 { return float64(l.Limit()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_shed_total",
+			Help:      "Requests rejected because the in-flight limit was reached.",
+		}, func() float64 { return float64(l.Shed()) }),
+	)
+}
+
+// RegisterLogDrops exports the number of log messages that outputs dropped
+// because they could not keep up, as reported by dropped.
+func (m *Metrics) RegisterLogDrops(dropped func() uint64) {
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "log_messages_dropped_total",
+		Help:      "Log messages dropped because an output was slow or unreachable.",
+	}, func() float64 { return float64(dropped()) }))
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/listen"
+	"github.com/rs/zerolog"
+)
+
+// Server serves /metrics on its own address so it can stay off the public port.
+type Server struct {
+	server     *http.Server
+	socketMode os.FileMode
+	log        zerolog.Logger
+}
+
+// NewServer returns a server exposing m on addr (host:port, unix:// or systemd:).
+func NewServer(addr string, socketMode os.FileMode, m *Metrics, logger zerolog.Logger) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+
+	return &Server{
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		socketMode: socketMode,
+		log:        logger,
+	}
+}
+
+// Start serves until Stop is called.
+func (s *Server) Start() error {
+	s.log.Info().Str("addr", s.server.Addr).Msg("Starting metrics server")
+
+	ln, err := listen.Listen(s.server.Addr, s.socketMode)
+	if err != nil {
+		return err
+	}
+	return s.server.Serve(ln)
+}
+
+// Stop shuts the server down gracefully.
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
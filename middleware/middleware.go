@@ -0,0 +1,123 @@
+// Package middleware provides net/http middleware that geolocates the client
+// of every request in-process, using the geoip package directly instead of
+// calling the IpContext HTTP API.
+//
+//	g, _ := geoip.New("/data", nil, nil, nil, logger, 5*time.Minute)
+//	handler := middleware.Middleware(g, middleware.WithTrustedProxies(lbNets...))(mux)
+//
+//	func handle(w http.ResponseWriter, r *http.Request) {
+//		if loc, ok := middleware.FromContext(r.Context()); ok {
+//			fmt.Fprintln(w, loc.CountryCode)
+//		}
+//	}
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/clientip"
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+type contextKey struct{}
+
+var errInvalidClientIP = errors.New("middleware: cannot determine client IP")
+
+// Option customises the middleware.
+type Option func(*options)
+
+type options struct {
+	resolver     *clientip.Resolver
+	timeout      time.Duration
+	errorHandler func(http.ResponseWriter, *http.Request, error)
+}
+
+// WithTrustedProxies trusts X-Forwarded-For entries added by proxies in nets.
+// Without it, the peer address is always used as the client IP.
+func WithTrustedProxies(nets ...*net.IPNet) Option {
+	return func(o *options) {
+		o.resolver = clientip.NewResolver(nets, clientip.HeaderXForwardedFor)
+	}
+}
+
+// WithResolver uses a preconfigured client IP resolver.
+func WithResolver(r *clientip.Resolver) Option {
+	return func(o *options) {
+		o.resolver = r
+	}
+}
+
+// WithLookupTimeout bounds the time spent on a single lookup (default 100ms).
+func WithLookupTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithErrorHandler is called instead of the next handler when the lookup
+// fails. By default the request continues without location data.
+func WithErrorHandler(fn func(http.ResponseWriter, *http.Request, error)) Option {
+	return func(o *options) {
+		o.errorHandler = fn
+	}
+}
+
+// Middleware geolocates the client of each request and attaches the result
+// to the request context, retrievable with FromContext.
+func Middleware(g *geoip.GeoIP, opts ...Option) func(http.Handler) http.Handler {
+	o := options{
+		resolver: clientip.NewResolver(nil, ""),
+		timeout:  100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resp, err := lookup(r, g, &o)
+			if err != nil {
+				if o.errorHandler != nil {
+					o.errorHandler(w, r, err)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), resp)))
+		})
+	}
+}
+
+func lookup(r *http.Request, g *geoip.GeoIP, o *options) (*geoip.Response, error) {
+	ctx := r.Context()
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	ip := o.resolver.ClientIP(r)
+	if ip == nil {
+		return nil, errInvalidClientIP
+	}
+
+	return g.LookupWithContext(ctx, ip.String())
+}
+
+// NewContext returns a copy of ctx carrying resp.
+func NewContext(ctx context.Context, resp *geoip.Response) context.Context {
+	return context.WithValue(ctx, contextKey{}, resp)
+}
+
+// FromContext returns the location attached by Middleware, if any.
+// The returned response is shared with the lookup cache and must not be modified.
+func FromContext(ctx context.Context) (*geoip.Response, bool) {
+	resp, ok := ctx.Value(contextKey{}).(*geoip.Response)
+	return resp, ok && resp != nil
+}
@@ -1,23 +1,32 @@
 package neighbours
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/andreybrigunet/IpContext/tracing"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/andreybrigunet/IpContext/neighbours")
+
 type Neighbour struct {
 	CountryCode string `json:"countryCode"`
 	CountryName string `json:"countryName"`
 }
 
 func (s *Store) RefreshAllOnce() {
-    s.refreshAll()
+    s.refreshAll(context.Background())
 }
 
 type Store struct {
@@ -30,6 +39,18 @@ type Store struct {
 	data map[string][]Neighbour
 
 	countries []string
+
+	refreshOK     atomic.Uint64
+	refreshFailed atomic.Uint64
+	lastSuccess   atomic.Int64 // unix nanoseconds
+	lastRun       atomic.Pointer[runResult]
+}
+
+// runResult is the outcome of the last completed refresh of all countries.
+type runResult struct {
+	finished  time.Time
+	succeeded int
+	failed    int
 }
 
 func New(username string, interval time.Duration, countries []string, logger zerolog.Logger) *Store {
@@ -59,14 +80,14 @@ func (s *Store) Start(stop <-chan struct{}) {
 	go func() {
 		s.log.Info().Msg("Starting neighbours updater")
 
-		s.refreshAll()
+		s.refreshAll(context.Background())
 		ticker := time.NewTicker(s.interval)
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ticker.C:
-				s.refreshAll()
+				s.refreshAll(context.Background())
 			case <-stop:
 				s.log.Info().Msg("Stopping neighbours updater")
 				return
@@ -75,26 +96,52 @@ func (s *Store) Start(stop <-chan struct{}) {
 	}()
 }
 
-func (s *Store) refreshAll() {
+func (s *Store) refreshAll(ctx context.Context) {
 	if s.username == "" { return }
 
-	for _, cc := range s.countries {
-		if err := s.refresh(cc); err != nil {
+	run := s.refreshEach(ctx, s.countries)
+	run.finished = time.Now()
+	s.lastRun.Store(&run)
+
+	s.log.Info().Int("succeeded", run.succeeded).Int("failed", run.failed).Msg("Neighbours updated")
+}
+
+// refreshEach refreshes countries one by one, pacing the GeoNames requests.
+func (s *Store) refreshEach(ctx context.Context, countries []string) runResult {
+	var run runResult
+	for _, cc := range countries {
+		spanCtx, span := tracer.Start(ctx, "geonames.neighbours.country", trace.WithAttributes(attribute.String("country", cc)))
+		err := s.refresh(spanCtx, cc)
+		tracing.EndSpan(span, err)
+
+		if err != nil {
+			run.failed++
+			s.refreshFailed.Add(1)
 			s.log.Warn().Err(err).Str("country", cc).Msg("Failed to refresh neighbours")
+		} else {
+			run.succeeded++
+			s.refreshOK.Add(1)
+			s.lastSuccess.Store(time.Now().UnixNano())
 		}
 
 		s.log.Debug().Str("country", cc).Msg("Refreshed neighbours")
 
-		time.Sleep(1100 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return run
+		case <-time.After(1100 * time.Millisecond):
+		}
 	}
-
-	s.log.Info().Msg("Neighbours updated")
+	return run
 }
 
-func (s *Store) refresh(countryCode string) error {
+func (s *Store) refresh(ctx context.Context, countryCode string) error {
 	url := fmt.Sprintf("http://api.geonames.org/neighboursJSON?country=%s&username=%s", countryCode, s.username)
 
-	resp, err := s.client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil { return err }
+
+	resp, err := s.client.Do(req)
 	if err != nil { return err }
 
 	defer resp.Body.Close()
@@ -138,3 +185,60 @@ func (s *Store) Get(countryCode string) []Neighbour {
 	copy(result, data)
 	return result
 }
+
+// RefreshStats returns per-country refresh outcomes since start and the time
+// of the last successful refresh (zero if none).
+func (s *Store) RefreshStats() (succeeded, failed uint64, lastSuccess time.Time) {
+	if ns := s.lastSuccess.Load(); ns > 0 {
+		lastSuccess = time.Unix(0, ns)
+	}
+	return s.refreshOK.Load(), s.refreshFailed.Load(), lastSuccess
+}
+
+// Coverage returns how many of the configured countries have data.
+func (s *Store) Coverage() (loaded, total int) {
+	s.mu.RLock()
+	loaded = len(s.data)
+	s.mu.RUnlock()
+	return loaded, len(s.countries)
+}
+
+// LastRefresh returns the outcome of the last completed refresh of all
+// countries; finished is zero before the first one completes.
+func (s *Store) LastRefresh() (finished time.Time, succeeded, failed int) {
+	run := s.lastRun.Load()
+	if run == nil {
+		return time.Time{}, 0, 0
+	}
+	return run.finished, run.succeeded, run.failed
+}
+
+// RefreshAllContext refreshes every country once, tracing each request as a
+// child of the span in ctx.
+func (s *Store) RefreshAllContext(ctx context.Context) {
+	s.refreshAll(ctx)
+}
+
+// HasCountry reports whether countryCode is one of the countries the store
+// refreshes.
+func (s *Store) HasCountry(countryCode string) bool {
+	_, ok := slices.BinarySearch(s.countries, countryCode)
+	return ok
+}
+
+// RefreshCountries refreshes the given countries once, skipping those the
+// store does not refresh. Unlike RefreshAllContext it does not update
+// LastRefresh, which describes full runs.
+func (s *Store) RefreshCountries(ctx context.Context, countries []string) {
+	if s.username == "" { return }
+
+	known := make([]string, 0, len(countries))
+	for _, cc := range countries {
+		if s.HasCountry(cc) {
+			known = append(known, cc)
+		}
+	}
+
+	run := s.refreshEach(ctx, known)
+	s.log.Info().Strs("countries", known).Int("succeeded", run.succeeded).Int("failed", run.failed).Msg("Neighbours refreshed")
+}
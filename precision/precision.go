@@ -0,0 +1,182 @@
+// Package precision coarsens lookup responses for consumers that should not
+// receive more location detail than they need.
+package precision
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+// Region handling.
+const (
+	RegionFull = "full" // keep region code and name
+	RegionCode = "code" // keep only the ISO 3166-2 subdivision code
+	RegionNone = "none" // drop the region
+)
+
+// Profile describes how much detail a response keeps.
+type Profile struct {
+	Name string `json:"-"`
+
+	// Coordinates is the number of decimals kept in lat/lon, or -1 to drop
+	// them. nil keeps full precision.
+	Coordinates *int `json:"coordinates,omitempty"`
+
+	DropCity bool   `json:"dropCity,omitempty"` // drop city, district and zip
+	Region   string `json:"region,omitempty"`   // full (default), code or none
+}
+
+func decimals(n int) *int { return &n }
+
+// Built-in profiles, from most to least detailed.
+var builtin = []Profile{
+	{Name: "full"},
+	{Name: "city", Coordinates: decimals(2)},                   // ~1 km
+	{Name: "region", Coordinates: decimals(1), DropCity: true}, // ~11 km
+	{Name: "country", Coordinates: decimals(-1), DropCity: true, Region: RegionNone},
+}
+
+// Strictest is the profile used when a configured one cannot be found.
+const Strictest = "country"
+
+// File is the JSON layout of a profiles file.
+type File struct {
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// Apply returns r coarsened to the profile. r is not modified, as it may be
+// shared through the response cache; it is returned as is when the profile
+// keeps everything.
+func (p *Profile) Apply(r *geoip.Response) *geoip.Response {
+	if p == nil || (p.Coordinates == nil && !p.DropCity && (p.Region == "" || p.Region == RegionFull)) {
+		return r
+	}
+
+	out := *r
+	if p.Coordinates != nil {
+		if d := *p.Coordinates; d < 0 {
+			out.Lat, out.Lon = 0, 0
+		} else {
+			out.Lat, out.Lon = round(out.Lat, d), round(out.Lon, d)
+		}
+	}
+	if p.DropCity {
+		out.City, out.District, out.Zip = "", "", ""
+	}
+	switch p.Region {
+	case RegionCode:
+		out.RegionName = ""
+	case RegionNone:
+		out.Region, out.RegionName = "", ""
+	}
+	return &out
+}
+
+func round(v float64, d int) float64 {
+	scale := math.Pow(10, float64(d))
+	return math.Round(v*scale) / scale
+}
+
+func (p *Profile) validate() error {
+	if p.Coordinates != nil && (*p.Coordinates < -1 || *p.Coordinates > 6) {
+		return fmt.Errorf("coordinates: at most 6 decimals, or -1 to drop them")
+	}
+	switch p.Region {
+	case "", RegionFull, RegionCode, RegionNone:
+	default:
+		return fmt.Errorf("region: unknown value %q (expected full, code or none)", p.Region)
+	}
+	return nil
+}
+
+// Policy picks the profile of a request: the API key's, else the route's,
+// else the default.
+type Policy struct {
+	profiles  map[string]*Profile
+	def       *Profile
+	routes    map[string]*Profile
+	strictest *Profile
+}
+
+// NewPolicy loads the built-in profiles and those of file, if set, which may
+// override built-ins. routes maps route names to profile names.
+func NewPolicy(file, def string, routes map[string]string) (*Policy, error) {
+	profiles := make(map[string]*Profile, len(builtin))
+	for i := range builtin {
+		p := builtin[i]
+		profiles[p.Name] = &p
+	}
+	// Taken before the file is read, which may redefine the built-ins.
+	strictest := profiles[Strictest]
+
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var f File
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", file, err)
+		}
+		for name, p := range f.Profiles {
+			p := p
+			if err := p.validate(); err != nil {
+				return nil, fmt.Errorf("%s: profile %q: %w", file, name, err)
+			}
+			p.Name = name
+			profiles[name] = &p
+		}
+	}
+
+	pol := &Policy{profiles: profiles, routes: make(map[string]*Profile, len(routes)), strictest: strictest}
+
+	var ok bool
+	if pol.def, ok = profiles[def]; !ok {
+		return nil, fmt.Errorf("unknown default profile %q", def)
+	}
+	for route, name := range routes {
+		p, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("route %s: unknown profile %q", route, name)
+		}
+		pol.routes[route] = p
+	}
+	return pol, nil
+}
+
+// Profile returns the named profile.
+func (p *Policy) Profile(name string) (*Profile, bool) {
+	prof, ok := p.profiles[name]
+	return prof, ok
+}
+
+// Names returns the known profile names, sorted.
+func (p *Policy) Names() []string {
+	names := make([]string, 0, len(p.profiles))
+	for name := range p.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select returns the profile for a request to route made with a key whose
+// precision scope is keyProfile ("" when the key sets none or there is no
+// key). An unknown key profile selects the strictest built-in one.
+func (p *Policy) Select(keyProfile, route string) *Profile {
+	if keyProfile != "" {
+		if prof, ok := p.profiles[keyProfile]; ok {
+			return prof
+		}
+		return p.strictest
+	}
+	if prof, ok := p.routes[route]; ok {
+		return prof
+	}
+	return p.def
+}
@@ -0,0 +1,170 @@
+package precision
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+func testResponse() *geoip.Response {
+	return &geoip.Response{
+		Query:       "8.8.8.8",
+		Status:      "success",
+		CountryCode: "US",
+		Region:      "CA",
+		RegionName:  "California",
+		City:        "Mountain View",
+		District:    "Shoreline",
+		Zip:         "94043",
+		Lat:         37.405992,
+		Lon:         -122.078515,
+		Languages:   []string{"en"},
+	}
+}
+
+func TestApply(t *testing.T) {
+	pol, err := NewPolicy("", "full", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		profile  string
+		lat, lon float64
+		city     string
+		zip      string
+		region   string
+		name     string // region name
+	}{
+		{profile: "full", lat: 37.405992, lon: -122.078515, city: "Mountain View", zip: "94043", region: "CA", name: "California"},
+		{profile: "city", lat: 37.41, lon: -122.08, city: "Mountain View", zip: "94043", region: "CA", name: "California"},
+		{profile: "region", lat: 37.4, lon: -122.1, region: "CA", name: "California"},
+		{profile: "country"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			prof, ok := pol.Profile(tt.profile)
+			if !ok {
+				t.Fatalf("no built-in profile %q", tt.profile)
+			}
+
+			in := testResponse()
+			out := prof.Apply(in)
+
+			if out.Lat != tt.lat || out.Lon != tt.lon || out.City != tt.city || out.Zip != tt.zip ||
+				out.Region != tt.region || out.RegionName != tt.name {
+				t.Errorf("Apply() = %v,%v %q %q %q %q, want %v,%v %q %q %q %q",
+					out.Lat, out.Lon, out.City, out.Zip, out.Region, out.RegionName,
+					tt.lat, tt.lon, tt.city, tt.zip, tt.region, tt.name)
+			}
+			if tt.city == "" && out.District != "" {
+				t.Errorf("District = %q, want it dropped with the city", out.District)
+			}
+			if out.CountryCode != "US" || out.Query != "8.8.8.8" {
+				t.Errorf("Apply() dropped country or query: %+v", out)
+			}
+
+			// The input may be shared through the response cache.
+			if !reflect.DeepEqual(in, testResponse()) {
+				t.Errorf("Apply() modified its input: %+v", in)
+			}
+			if (out == in) != (tt.profile == "full") {
+				t.Errorf("Apply() returned the input: %v, want a copy unless nothing changes", out == in)
+			}
+		})
+	}
+
+	var none *Profile
+	if in := testResponse(); none.Apply(in) != in {
+		t.Error("nil profile did not return the input")
+	}
+}
+
+func TestRegionCode(t *testing.T) {
+	prof := &Profile{Region: RegionCode}
+	out := prof.Apply(testResponse())
+	if out.Region != "CA" || out.RegionName != "" || out.City != "Mountain View" {
+		t.Errorf("Apply() = %q %q %q, want the region code only", out.Region, out.RegionName, out.City)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "profiles.json")
+	content := `{"profiles": {"partner": {"coordinates": 3}, "city": {"coordinates": 0}}}`
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pol, err := NewPolicy(file, "city", map[string]string{"/batch": "country", "/proxy": "region"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		keyProfile string
+		route      string
+		want       string
+		lat        float64
+		city       string
+	}{
+		{name: "default", route: "/{ip}", want: "city", lat: 37, city: "Mountain View"}, // redefined by the file
+		{name: "route", route: "/batch", want: "country"},
+		{name: "other route", route: "/proxy", want: "region", lat: 37.4},
+		{name: "key wins over route", keyProfile: "partner", route: "/batch", want: "partner", lat: 37.406, city: "Mountain View"},
+		{name: "key with a built-in", keyProfile: "full", route: "/batch", want: "full", lat: 37.405992, city: "Mountain View"},
+		{name: "unknown key profile", keyProfile: "gone", route: "/{ip}", want: "country"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prof := pol.Select(tt.keyProfile, tt.route)
+			if prof.Name != tt.want {
+				t.Fatalf("Select(%q, %q) = %s, want %s", tt.keyProfile, tt.route, prof.Name, tt.want)
+			}
+			if out := prof.Apply(testResponse()); out.Lat != tt.lat || out.City != tt.city {
+				t.Errorf("%s applied = %v %q, want %v %q", prof.Name, out.Lat, out.City, tt.lat, tt.city)
+			}
+		})
+	}
+
+	if got := strings.Join(pol.Names(), ","); got != "city,country,full,partner,region" {
+		t.Errorf("Names() = %s", got)
+	}
+}
+
+func TestNewPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		def     string
+		routes  map[string]string
+		wantErr string
+	}{
+		{name: "unknown default", def: "street", wantErr: `unknown default profile "street"`},
+		{name: "unknown route profile", def: "full", routes: map[string]string{"/batch": "street"}, wantErr: `route /batch: unknown profile "street"`},
+		{name: "too many decimals", content: `{"profiles": {"p": {"coordinates": 7}}}`, def: "full", wantErr: `profile "p": coordinates`},
+		{name: "bad region", content: `{"profiles": {"p": {"region": "state"}}}`, def: "full", wantErr: `profile "p": region`},
+		{name: "invalid JSON", content: `{"profiles": `, def: "full", wantErr: "parse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := ""
+			if tt.content != "" {
+				file = filepath.Join(t.TempDir(), "profiles.json")
+				if err := os.WriteFile(file, []byte(tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := NewPolicy(file, tt.def, tt.routes); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewPolicy() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Package privacy anonymizes client IP addresses before they are written to
+// logs, traces or metrics. Lookups always use the full address.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Anonymization modes.
+const (
+	ModeOff      = "off"
+	ModeTruncate = "truncate" // keep the network prefix, zero the host bits
+	ModeHash     = "hash"     // HMAC-SHA256 with a random key that rotates
+)
+
+// Options configures an Anonymizer.
+type Options struct {
+	Mode       string
+	IPv4Prefix int // bits kept by ModeTruncate
+	IPv6Prefix int
+
+	// KeyRotation is how long a hash key is used. Hashes of the same
+	// address can be correlated only while the key is unchanged.
+	KeyRotation time.Duration
+}
+
+type hashKey struct {
+	key     []byte
+	expires time.Time
+}
+
+// Anonymizer rewrites IP addresses. A nil Anonymizer returns them unchanged.
+type Anonymizer struct {
+	mode   string
+	v4Mask net.IPMask
+	v6Mask net.IPMask
+	rotate time.Duration
+	key    atomic.Pointer[hashKey]
+	now    func() time.Time
+}
+
+// New validates opts and returns an Anonymizer, or nil when the mode is off.
+func New(opts Options) (*Anonymizer, error) {
+	mode := strings.ToLower(opts.Mode)
+	switch mode {
+	case "", ModeOff:
+		return nil, nil
+	case ModeTruncate, ModeHash:
+	default:
+		return nil, fmt.Errorf("unknown privacy mode %q (expected off, truncate or hash)", opts.Mode)
+	}
+
+	if opts.IPv4Prefix < 0 || opts.IPv4Prefix > 32 {
+		return nil, fmt.Errorf("IPv4 prefix %d out of range 0-32", opts.IPv4Prefix)
+	}
+	if opts.IPv6Prefix < 0 || opts.IPv6Prefix > 128 {
+		return nil, fmt.Errorf("IPv6 prefix %d out of range 0-128", opts.IPv6Prefix)
+	}
+	if mode == ModeHash && opts.KeyRotation <= 0 {
+		return nil, fmt.Errorf("hash key rotation must be positive")
+	}
+
+	return &Anonymizer{
+		mode:   mode,
+		v4Mask: net.CIDRMask(opts.IPv4Prefix, 32),
+		v6Mask: net.CIDRMask(opts.IPv6Prefix, 128),
+		rotate: opts.KeyRotation,
+		now:    time.Now,
+	}, nil
+}
+
+// Mode returns the anonymization mode.
+func (a *Anonymizer) Mode() string {
+	if a == nil {
+		return ModeOff
+	}
+	return a.mode
+}
+
+// IP returns the anonymized form of ip.
+func (a *Anonymizer) IP(ip net.IP) string {
+	if a == nil || ip == nil {
+		return ip.String()
+	}
+
+	if a.mode == ModeHash {
+		mac := hmac.New(sha256.New, a.currentKey())
+		mac.Write(ip.To16())
+		return hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(a.v4Mask).String()
+	}
+	return ip.Mask(a.v6Mask).String()
+}
+
+// String anonymizes an address given as "ip" or "host:port". Anything else,
+// e.g. an invalid query, is returned unchanged as it identifies nobody.
+func (a *Anonymizer) String(s string) string {
+	if a == nil {
+		return s
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return a.IP(ip)
+	}
+	if host, port, err := net.SplitHostPort(s); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			return net.JoinHostPort(a.IP(ip), port)
+		}
+	}
+	return s
+}
+
+// currentKey returns the hash key, replacing it once it has expired.
+func (a *Anonymizer) currentKey() []byte {
+	now := a.now()
+	for {
+		k := a.key.Load()
+		if k != nil && now.Before(k.expires) {
+			return k.key
+		}
+
+		next := &hashKey{key: make([]byte, 32), expires: now.Add(a.rotate)}
+		if _, err := rand.Read(next.key); err != nil {
+			panic("privacy: reading random key: " + err.Error())
+		}
+		if a.key.CompareAndSwap(k, next) {
+			return next.key
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package privacy
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		v4, v6 int
+		ip     string
+		want   string
+	}{
+		{v4: 24, v6: 48, ip: "203.0.113.77", want: "203.0.113.0"},
+		{v4: 16, v6: 48, ip: "203.0.113.77", want: "203.0.0.0"},
+		{v4: 32, v6: 128, ip: "203.0.113.77", want: "203.0.113.77"},
+		{v4: 0, v6: 0, ip: "203.0.113.77", want: "0.0.0.0"},
+		{v4: 24, v6: 48, ip: "2001:db8:abcd:12:1:2:3:4", want: "2001:db8:abcd::"},
+		{v4: 24, v6: 64, ip: "2001:db8:abcd:12:1:2:3:4", want: "2001:db8:abcd:12::"},
+		{v4: 24, v6: 56, ip: "2001:db8:abcd:12ff::1", want: "2001:db8:abcd:1200::"},
+		{v4: 24, v6: 48, ip: "::ffff:203.0.113.77", want: "203.0.113.0"}, // IPv4-mapped uses the IPv4 prefix
+	}
+
+	for _, tt := range tests {
+		a, err := New(Options{Mode: ModeTruncate, IPv4Prefix: tt.v4, IPv6Prefix: tt.v6})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.IP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("/%d, /%d: IP(%s) = %s, want %s", tt.v4, tt.v6, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	a, err := New(Options{Mode: "HASH", KeyRotation: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return now }
+
+	first := a.IP(net.ParseIP("203.0.113.7"))
+	if len(first) != 16 || strings.Contains(first, "203") {
+		t.Fatalf("IP() = %q, want 16 hex digits", first)
+	}
+	if got := a.String("203.0.113.7"); got != first {
+		t.Errorf("same address, same key: %s, want %s", got, first)
+	}
+	if got := a.String("::ffff:203.0.113.7"); got != first {
+		t.Errorf("IPv4-mapped form hashed to %s, want %s", got, first)
+	}
+	if got := a.IP(net.ParseIP("203.0.113.8")); got == first {
+		t.Error("different addresses share a hash")
+	}
+
+	now = now.Add(59 * time.Minute)
+	if got := a.IP(net.ParseIP("203.0.113.7")); got != first {
+		t.Errorf("hash changed before the key rotated: %s, want %s", got, first)
+	}
+
+	now = now.Add(time.Minute)
+	if got := a.IP(net.ParseIP("203.0.113.7")); got == first {
+		t.Error("hash unchanged after the key rotated")
+	}
+}
+
+func TestString(t *testing.T) {
+	a, err := New(Options{Mode: ModeTruncate, IPv4Prefix: 24, IPv6Prefix: 48})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in, want string
+	}{
+		{in: "203.0.113.77", want: "203.0.113.0"},
+		{in: "203.0.113.77:443", want: "203.0.113.0:443"},
+		{in: "[2001:db8:1:2::1]:443", want: "[2001:db8:1::]:443"},
+		{in: "not-an-ip", want: "not-an-ip"},
+		{in: "example.com:80", want: "example.com:80"},
+		{in: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := a.String(tt.in); got != tt.want {
+			t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNilAnonymizer(t *testing.T) {
+	a, err := New(Options{Mode: ModeOff})
+	if err != nil || a != nil {
+		t.Fatalf("New(off) = %v, %v, want nil", a, err)
+	}
+	if a, err := New(Options{}); err != nil || a != nil {
+		t.Fatalf("New() = %v, %v, want nil", a, err)
+	}
+
+	if got := a.Mode(); got != ModeOff {
+		t.Errorf("Mode() = %s, want off", got)
+	}
+	if got := a.IP(net.ParseIP("203.0.113.7")); got != "203.0.113.7" {
+		t.Errorf("IP() = %s, want the address unchanged", got)
+	}
+	if got := a.IP(nil); got != "<nil>" {
+		t.Errorf("IP(nil) = %s", got)
+	}
+	if got := a.String("203.0.113.7:80"); got != "203.0.113.7:80" {
+		t.Errorf("String() = %s, want the address unchanged", got)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		opts    Options
+		wantErr string
+	}{
+		{opts: Options{Mode: "scramble"}, wantErr: "unknown privacy mode"},
+		{opts: Options{Mode: ModeTruncate, IPv4Prefix: 33}, wantErr: "IPv4 prefix 33"},
+		{opts: Options{Mode: ModeTruncate, IPv6Prefix: -1}, wantErr: "IPv6 prefix -1"},
+		{opts: Options{Mode: ModeHash}, wantErr: "rotation must be positive"},
+	}
+
+	for _, tt := range tests {
+		if _, err := New(tt.opts); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("New(%+v) error = %v, want one containing %q", tt.opts, err, tt.wantErr)
+		}
+	}
+}
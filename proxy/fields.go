@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+// fields maps response field names (as in the JSON API) to their header value.
+var fields = map[string]func(*geoip.Response) string{
+	"continent":      func(r *geoip.Response) string { return r.Continent },
+	"continentCode":  func(r *geoip.Response) string { return r.ContinentCode },
+	"country":        func(r *geoip.Response) string { return r.Country },
+	"countryCode":    func(r *geoip.Response) string { return r.CountryCode },
+	"region":         func(r *geoip.Response) string { return r.Region },
+	"regionName":     func(r *geoip.Response) string { return r.RegionName },
+	"city":           func(r *geoip.Response) string { return r.City },
+	"district":       func(r *geoip.Response) string { return r.District },
+	"zip":            func(r *geoip.Response) string { return r.Zip },
+	"lat":            func(r *geoip.Response) string { return formatCoord(r.Lat) },
+	"lon":            func(r *geoip.Response) string { return formatCoord(r.Lon) },
+	"timezone":       func(r *geoip.Response) string { return r.Timezone },
+	"offset":         func(r *geoip.Response) string { return strconv.Itoa(r.Offset) },
+	"currencyCode":   func(r *geoip.Response) string { return r.CurrencyCode },
+	"currencySymbol": func(r *geoip.Response) string { return r.CurrencySymbol },
+	"isp":            func(r *geoip.Response) string { return r.ISP },
+	"org":            func(r *geoip.Response) string { return r.Org },
+	"as":             func(r *geoip.Response) string { return r.AS },
+	"asn":            func(r *geoip.Response) string { return formatASN(r.ASNumber()) },
+	"asname":         func(r *geoip.Response) string { return r.ASName },
+	"isEUCountry":    func(r *geoip.Response) string { return strconv.FormatBool(r.IsEUCountry) },
+}
+
+// DefaultHeaders is used when no header mapping is configured.
+var DefaultHeaders = map[string]string{
+	"X-Geo-Country":  "countryCode",
+	"X-Geo-City":     "city",
+	"X-Geo-ASN":      "asn",
+	"X-Geo-Timezone": "timezone",
+	"X-Geo-Currency": "currencyCode",
+}
+
+// ParseHeaders parses a "Header=field,Header=field" mapping.
+// An empty string yields DefaultHeaders.
+func ParseHeaders(spec string) (map[string]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return DefaultHeaders, nil
+	}
+
+	out := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		header, field, ok := strings.Cut(pair, "=")
+		header, field = strings.TrimSpace(header), strings.TrimSpace(field)
+		if !ok || header == "" || field == "" {
+			return nil, fmt.Errorf("invalid header mapping %q, expected Header=field", pair)
+		}
+		if _, known := fields[field]; !known {
+			return nil, fmt.Errorf("unknown field %q (known: %s)", field, strings.Join(fieldNames(), ", "))
+		}
+
+		out[http.CanonicalHeaderKey(header)] = field
+	}
+
+	return out, nil
+}
+
+func fieldNames() []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatCoord leaves out 0, as the JSON API does, so coordinates dropped by
+// a precision profile send no header.
+func formatCoord(v float64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatASN(n uint) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(n), 10)
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/clientip"
+	"github.com/andreybrigunet/IpContext/geoip"
+	"github.com/andreybrigunet/IpContext/listen"
+	"github.com/andreybrigunet/IpContext/precision"
+	"github.com/andreybrigunet/IpContext/privacy"
+	"github.com/andreybrigunet/IpContext/proxyproto"
+	"github.com/rs/zerolog"
+)
+
+// Server is a reverse proxy that adds geolocation headers to every request
+// before forwarding it to the upstream.
+type Server struct {
+	server *http.Server
+	geoIP  *geoip.GeoIP
+	opts   Options
+	log    zerolog.Logger
+}
+
+// Options configures the reverse proxy.
+type Options struct {
+	Addr       string      // host:port, unix:///path or systemd:[name]
+	SocketMode os.FileMode // permissions for unix socket listeners
+	Upstream   *url.URL
+	Headers    map[string]string   // canonical header name -> field
+	ClientIP   *clientip.Resolver  // decides which forwarding headers to trust
+	Precision  *precision.Policy   // coarsens the lookup behind Headers; nil keeps everything
+	Privacy    *privacy.Anonymizer // anonymizes client addresses in logs; nil logs them as they are
+
+	// ProxyProtocol accepts HAProxy PROXY v1/v2 headers from ProxyProtocolTrusted peers
+	ProxyProtocol        bool
+	ProxyProtocolTrusted []*net.IPNet
+	ProxyProtocolUnix    bool // accept PROXY headers from unix socket peers
+}
+
+// PrecisionRoute is the route name that selects the precision profile of
+// the proxy in PRECISION_ROUTES.
+const PrecisionRoute = "proxy"
+
+// New creates a reverse proxy forwarding to opts.Upstream.
+func New(opts Options, geoIP *geoip.GeoIP, logger zerolog.Logger) *Server {
+	if opts.ClientIP == nil {
+		opts.ClientIP = clientip.NewResolver(nil, "")
+	}
+
+	s := &Server{
+		geoIP: geoIP,
+		opts:  opts,
+		log:   logger,
+	}
+	upstream := opts.Upstream
+
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(upstream)
+			pr.Out.Host = pr.In.Host
+
+			// Keep the existing chain; SetXForwarded appends the peer address.
+			pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+			pr.SetXForwarded()
+
+			s.setGeoHeaders(pr.Out, pr.In)
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			s.log.Error().Err(err).Str("path", r.URL.Path).Msg("Upstream request failed")
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	s.server = &http.Server{
+		Addr:              opts.Addr,
+		Handler:           rp,
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	return s
+}
+
+// setGeoHeaders strips any inbound copies of the configured headers so clients
+// cannot spoof them, then sets them from the lookup of the client address.
+func (s *Server) setGeoHeaders(out, in *http.Request) {
+	for header := range s.opts.Headers {
+		out.Header.Del(header)
+	}
+
+	ipStr := s.opts.ClientIP.ClientIP(in).String()
+	resp, err := s.geoIP.LookupWithContext(in.Context(), ipStr)
+	if err != nil {
+		s.log.Debug().Err(err).Str("ip", s.opts.Privacy.String(ipStr)).Msg("Proxy lookup failed")
+		return
+	}
+
+	if s.opts.Precision != nil {
+		resp = s.opts.Precision.Select("", PrecisionRoute).Apply(resp)
+	}
+
+	for header, field := range s.opts.Headers {
+		if v := fields[field](resp); v != "" {
+			out.Header.Set(header, v)
+		}
+	}
+}
+
+// Start serves until Stop is called.
+func (s *Server) Start() error {
+	s.log.Info().
+		Str("addr", s.server.Addr).
+		Str("upstream", s.opts.Upstream.String()).
+		Bool("proxyProtocol", s.opts.ProxyProtocol).
+		Msg("Starting geo reverse proxy")
+
+	ln, err := listen.Listen(s.server.Addr, s.opts.SocketMode)
+	if err != nil {
+		return err
+	}
+
+	if s.opts.ProxyProtocol {
+		pl := proxyproto.NewListener(ln, s.opts.ProxyProtocolTrusted, s.server.ReadHeaderTimeout)
+		pl.TrustUnixSockets = s.opts.ProxyProtocolUnix
+		ln = pl
+	}
+
+	return s.server.Serve(ln)
+}
+
+// Stop gracefully shuts the proxy down.
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
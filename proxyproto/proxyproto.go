@@ -0,0 +1,237 @@
+// Package proxyproto implements the receiving side of the HAProxy PROXY
+// protocol (v1 text and v2 binary), so that servers behind TCP load balancers
+// see the original client address as the connection's remote address.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+)
+
+// v1MaxLen is the longest valid v1 header including CRLF.
+const v1MaxLen = 107
+
+// Listener wraps a net.Listener and strips PROXY protocol headers sent by
+// trusted peers. Connections from untrusted peers are passed through untouched,
+// so their headers are treated as ordinary payload and cannot spoof addresses.
+type Listener struct {
+	net.Listener
+
+	// TrustUnixSockets honours headers from peers on unix sockets, which
+	// have no address to check against the trusted networks.
+	TrustUnixSockets bool
+
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+// NewListener wraps inner. Headers are only honoured from peers in trusted;
+// timeout bounds how long a trusted peer may take to send its header.
+func NewListener(inner net.Listener, trusted []*net.IPNet, timeout time.Duration) *Listener {
+	return &Listener{Listener: inner, trusted: trusted, timeout: timeout}
+}
+
+// Accept returns the next connection. The header is read lazily on first use
+// so a slow peer cannot stall the accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.isTrusted(c.RemoteAddr()) {
+		return c, nil
+	}
+
+	return &Conn{Conn: c, r: bufio.NewReader(c), timeout: l.timeout}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	if _, ok := addr.(*net.UnixAddr); ok {
+		return l.TrustUnixSockets
+	}
+
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection whose remote address comes from the PROXY header, if any.
+type Conn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	src    net.Addr
+	dst    net.Addr
+	hdrErr error
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.hdrErr != nil {
+		return 0, c.hdrErr
+	}
+	return c.r.Read(p)
+}
+
+// RemoteAddr returns the client address carried in the header, or the peer
+// address when the header was absent or of the LOCAL/UNKNOWN kind.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address carried in the header, if any.
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *Conn) readHeader() {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+
+	peek, err := c.r.Peek(len(v1Prefix))
+	if err != nil {
+		// Too short to carry a header; let the caller see the data or EOF.
+		return
+	}
+
+	switch {
+	case bytes.Equal(peek, v1Prefix):
+		c.src, c.dst, c.hdrErr = readV1(c.r)
+	case bytes.Equal(peek, v2Signature[:len(v1Prefix)]):
+		c.src, c.dst, c.hdrErr = readV2(c.r)
+	}
+
+	if c.hdrErr != nil {
+		c.hdrErr = fmt.Errorf("proxyproto: %w", c.hdrErr)
+		c.Conn.Close()
+	}
+}
+
+// readV1 parses "PROXY TCP4 src dst sport dport\r\n".
+func readV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("v1 header too long or not CRLF terminated")
+	}
+
+	parts := strings.Fields(string(line[:len(line)-2]))
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("malformed v1 header %q", line)
+	}
+
+	src, err := tcpAddr(parts[2], parts[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := tcpAddr(parts[3], parts[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func tcpAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 parses the binary header: 12-byte signature, version/command,
+// family/protocol, 16-bit length, then addresses and optional TLVs.
+func readV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(hdr[:12], v2Signature) {
+		return nil, nil, errors.New("invalid v2 signature")
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported version %d", hdr[12]>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	switch hdr[12] & 0x0F {
+	case 0x0: // LOCAL: health checks from the balancer itself
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("unsupported command %d", hdr[12]&0x0F)
+	}
+
+	switch hdr[13] >> 4 {
+	case 0x1: // AF_INET
+		if len(payload) < 12 {
+			return nil, nil, errors.New("short v2 IPv4 address block")
+		}
+		src := &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		dst := &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+		return src, dst, nil
+	case 0x2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, nil, errors.New("short v2 IPv6 address block")
+		}
+		src := &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		dst := &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+		return src, dst, nil
+	default: // AF_UNSPEC or AF_UNIX carry no usable client IP
+		return nil, nil, nil
+	}
+}
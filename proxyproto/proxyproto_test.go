@@ -0,0 +1,144 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		src     string // empty when no address is carried
+		wantErr bool
+	}{
+		{name: "tcp4", header: "PROXY TCP4 203.0.113.1 192.0.2.1 56324 443\r\n", src: "203.0.113.1:56324"},
+		{name: "tcp6", header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", src: "[2001:db8::1]:56324"},
+		{name: "unknown", header: "PROXY UNKNOWN\r\n"},
+		{name: "unknown with addresses", header: "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"},
+		{name: "truncated", header: "PROXY TCP4 203.0.113.1 192.0", wantErr: true},
+		{name: "oversized", header: "PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n", wantErr: true},
+		{name: "LF only", header: "PROXY TCP4 203.0.113.1 192.0.2.1 56324 443\n", wantErr: true},
+		{name: "unsupported protocol", header: "PROXY UDP4 203.0.113.1 192.0.2.1 56324 443\r\n", wantErr: true},
+		{name: "missing field", header: "PROXY TCP4 203.0.113.1 192.0.2.1 56324\r\n", wantErr: true},
+		{name: "invalid address", header: "PROXY TCP4 203.0.113.999 192.0.2.1 56324 443\r\n", wantErr: true},
+		{name: "invalid port", header: "PROXY TCP4 203.0.113.1 192.0.2.1 70000 443\r\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, _, err := readV1(bufio.NewReader(strings.NewReader(tt.header)))
+			checkResult(t, src, err, tt.src, tt.wantErr)
+		})
+	}
+}
+
+func v2Header(verCmd, famProto byte, payload []byte) []byte {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, verCmd, famProto)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	return append(b, payload...)
+}
+
+func TestReadV2(t *testing.T) {
+	ipv4 := []byte{203, 0, 113, 1, 192, 0, 2, 1, 0xDC, 0x04, 0x01, 0xBB}
+	ipv6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0xDC, 0x04, 0x01, 0xBB)
+	full := v2Header(0x21, 0x11, ipv4)
+
+	tests := []struct {
+		name    string
+		header  []byte
+		src     string
+		wantErr bool
+	}{
+		{name: "ipv4", header: full, src: "203.0.113.1:56324"},
+		{name: "ipv6", header: v2Header(0x21, 0x21, ipv6), src: "[2001:db8::1]:56324"},
+		{name: "ipv4 with TLVs", header: v2Header(0x21, 0x11, append(ipv4, 0x04, 0x00, 0x01, 0x00)), src: "203.0.113.1:56324"},
+		{name: "local", header: v2Header(0x20, 0x00, nil)},
+		{name: "unspec family", header: v2Header(0x21, 0x00, nil)},
+		{name: "truncated fixed header", header: full[:14], wantErr: true},
+		{name: "truncated payload", header: full[:len(full)-4], wantErr: true},
+		{name: "short ipv4 block", header: v2Header(0x21, 0x11, ipv4[:8]), wantErr: true},
+		{name: "short ipv6 block", header: v2Header(0x21, 0x21, ipv6[:20]), wantErr: true},
+		{name: "bad version", header: v2Header(0x11, 0x11, ipv4), wantErr: true},
+		{name: "bad command", header: v2Header(0x22, 0x11, ipv4), wantErr: true},
+		{name: "bad signature", header: append([]byte("\r\n\r\n\x00\r\nXUIT\n"), full[12:]...), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, _, err := readV2(bufio.NewReader(bytes.NewReader(tt.header)))
+			checkResult(t, src, err, tt.src, tt.wantErr)
+		})
+	}
+}
+
+func checkResult(t *testing.T, src net.Addr, err error, want string, wantErr bool) {
+	t.Helper()
+	if wantErr {
+		if err == nil {
+			t.Fatalf("got %v, want an error", src)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := ""
+	if src != nil {
+		got = src.String()
+	}
+	if got != want {
+		t.Errorf("src = %q, want %q", got, want)
+	}
+}
+
+func TestConn(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		remote  string // empty keeps the pipe's address
+		payload string
+		wantErr bool
+	}{
+		{name: "v1 header", data: "PROXY TCP4 203.0.113.1 192.0.2.1 56324 443\r\nGET / HTTP/1.1\r\n", remote: "203.0.113.1:56324", payload: "GET / HTTP/1.1\r\n"},
+		{name: "no header", data: "GET / HTTP/1.1\r\n", payload: "GET / HTTP/1.1\r\n"},
+		{name: "short data", data: "GET", payload: "GET"},
+		{name: "malformed header", data: "PROXY garbage\r\nGET / HTTP/1.1\r\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			go func() {
+				client.Write([]byte(tt.data))
+				client.Close()
+			}()
+
+			c := &Conn{Conn: server, r: bufio.NewReader(server)}
+			defer c.Close()
+
+			got, err := io.ReadAll(c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("read %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.payload {
+				t.Errorf("payload = %q, want %q", got, tt.payload)
+			}
+			if tt.remote != "" && c.RemoteAddr().String() != tt.remote {
+				t.Errorf("RemoteAddr() = %s, want %s", c.RemoteAddr(), tt.remote)
+			}
+		})
+	}
+}
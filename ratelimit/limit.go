@@ -0,0 +1,116 @@
+// Package ratelimit implements token bucket rate limiting keyed by arbitrary
+// strings (client IPs, network prefixes, API keys) with bounded memory.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second up to Burst.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled reports whether the limit restricts anything.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// String formats the limit in the form accepted by ParseLimit.
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	// Use the smallest unit in which the rate is a whole number.
+	for _, u := range []struct {
+		name string
+		per  time.Duration
+	}{{"s", time.Second}, {"m", time.Minute}, {"h", time.Hour}, {"d", 24 * time.Hour}} {
+		if n := l.Rate * u.per.Seconds(); math.Abs(n-math.Round(n)) < 1e-6 {
+			return strconv.FormatFloat(math.Round(n), 'f', -1, 64) + "/" + u.name + ":" + strconv.Itoa(l.Burst)
+		}
+	}
+	return strconv.FormatFloat(l.Rate, 'f', -1, 64) + "/s:" + strconv.Itoa(l.Burst)
+}
+
+// ParseLimit parses "<count>/<unit>[:<burst>]", e.g. "10/s", "600/m:50" or
+// "10000/h". The unit is s, m, h or d; the burst defaults to count.
+// An empty string, "0" or "off" disables the limit.
+func ParseLimit(spec string) (Limit, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "0" || strings.EqualFold(spec, "off") {
+		return Limit{}, nil
+	}
+
+	rateSpec, burstSpec, hasBurst := strings.Cut(spec, ":")
+	countSpec, unit, ok := strings.Cut(rateSpec, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: expected <count>/<unit>", spec)
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(countSpec))
+	if err != nil || count <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: count must be a positive integer", spec)
+	}
+
+	var per time.Duration
+	switch strings.ToLower(strings.TrimSpace(unit)) {
+	case "s", "sec", "second":
+		per = time.Second
+	case "m", "min", "minute":
+		per = time.Minute
+	case "h", "hour":
+		per = time.Hour
+	case "d", "day":
+		per = 24 * time.Hour
+	default:
+		return Limit{}, fmt.Errorf("invalid rate limit %q: unit must be s, m, h or d", spec)
+	}
+
+	burst := count
+	if hasBurst {
+		burst, err = strconv.Atoi(strings.TrimSpace(burstSpec))
+		if err != nil || burst <= 0 {
+			return Limit{}, fmt.Errorf("invalid rate limit %q: burst must be a positive integer", spec)
+		}
+	}
+
+	return Limit{Rate: float64(count) / per.Seconds(), Burst: burst}, nil
+}
+
+// bucket is the state of one token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill returns the tokens available at now without modifying the bucket.
+func (b *bucket) refill(l Limit, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.Rate
+	return math.Min(tokens, float64(l.Burst))
+}
+
+// untilFull returns how long the bucket takes to refill completely from tokens.
+func untilFull(l Limit, tokens float64) time.Duration {
+	return secondsToDuration((float64(l.Burst) - tokens) / l.Rate)
+}
+
+// untilAvailable returns how long it takes until one token is available.
+func untilAvailable(l Limit, tokens float64) time.Duration {
+	if tokens >= 1 {
+		return 0
+	}
+	return secondsToDuration((1 - tokens) / l.Rate)
+}
+
+func secondsToDuration(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
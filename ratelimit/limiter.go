@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Bucket names one token bucket and the limit that applies to it.
+type Bucket struct {
+	Key   string
+	Limit Limit
+}
+
+// Result describes the outcome of a request against one or more buckets.
+// Limit, Remaining and Reset describe the most restrictive bucket.
+type Result struct {
+	Allowed    bool
+	Limit      int           // bucket capacity
+	Remaining  int           // whole tokens left after this request
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until a denied request may succeed
+}
+
+// Limiter tracks token buckets. At most maxEntries buckets are kept; when
+// more clients show up, the least recently used buckets are forgotten, which
+// only ever hands those clients a fresh burst.
+type Limiter struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+	now        func() time.Time
+}
+
+type entry struct {
+	key string
+	b   bucket
+}
+
+// New returns a limiter keeping at most maxEntries buckets.
+func New(maxEntries int) *Limiter {
+	if maxEntries <= 0 {
+		maxEntries = 100000
+	}
+	return &Limiter{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+// Allow takes one token from every enabled bucket if all of them have one.
+// A request denied by any bucket takes nothing, so a client blocked by one
+// limit does not drain the others. Nor does it create buckets: a flood of
+// denied requests from new addresses cannot evict the buckets of others.
+func (l *Limiter) Allow(buckets ...Bucket) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	res := Result{Allowed: true, Remaining: -1}
+
+	type state struct {
+		e      *entry
+		limit  Limit
+		tokens float64
+		stored bool
+	}
+	states := make([]state, 0, len(buckets))
+	for _, bk := range buckets {
+		if !bk.Limit.Enabled() {
+			continue
+		}
+		e, stored := l.get(bk.Key)
+		if !stored {
+			e = &entry{key: bk.Key, b: bucket{tokens: float64(bk.Limit.Burst), last: now}}
+		}
+		tokens := e.b.refill(bk.Limit, now)
+		states = append(states, state{e: e, limit: bk.Limit, tokens: tokens, stored: stored})
+		if tokens < 1 {
+			res.Allowed = false
+			if wait := untilAvailable(bk.Limit, tokens); wait > res.RetryAfter {
+				res.RetryAfter = wait
+			}
+		}
+	}
+
+	for _, st := range states {
+		if res.Allowed {
+			st.tokens--
+		}
+		st.e.b.tokens, st.e.b.last = st.tokens, now
+		if res.Allowed && !st.stored {
+			l.add(st.e)
+		}
+
+		remaining := int(st.tokens)
+		if res.Remaining < 0 || remaining < res.Remaining {
+			res.Limit = st.limit.Burst
+			res.Remaining = remaining
+			res.Reset = untilFull(st.limit, st.tokens)
+		}
+	}
+
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	return res
+}
+
+// Len returns the number of tracked buckets.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ll.Len()
+}
+
+func (l *Limiter) get(key string) (*entry, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.ll.MoveToFront(el)
+	return el.Value.(*entry), true
+}
+
+func (l *Limiter) add(e *entry) {
+	l.items[e.key] = l.ll.PushFront(e)
+	for l.ll.Len() > l.maxEntries {
+		oldest := l.ll.Back()
+		l.ll.Remove(oldest)
+		delete(l.items, oldest.Value.(*entry).key)
+	}
+}
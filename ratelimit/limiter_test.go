@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(maxEntries int) (*Limiter, *time.Time) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	l := New(maxEntries)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestAllowBurstAndRefill(t *testing.T) {
+	l, now := newTestLimiter(0)
+	b := Bucket{Key: "a", Limit: Limit{Rate: 2, Burst: 3}} // a token every 500ms
+
+	steps := []struct {
+		name       string
+		advance    time.Duration
+		allowed    bool
+		remaining  int
+		reset      time.Duration
+		retryAfter time.Duration
+	}{
+		{name: "burst 1", allowed: true, remaining: 2, reset: 500 * time.Millisecond},
+		{name: "burst 2", allowed: true, remaining: 1, reset: time.Second},
+		{name: "burst 3", allowed: true, remaining: 0, reset: 1500 * time.Millisecond},
+		{name: "empty", allowed: false, remaining: 0, reset: 1500 * time.Millisecond, retryAfter: 500 * time.Millisecond},
+		{name: "half a token", advance: 250 * time.Millisecond, allowed: false, remaining: 0, reset: 1250 * time.Millisecond, retryAfter: 250 * time.Millisecond},
+		{name: "refilled one", advance: 250 * time.Millisecond, allowed: true, remaining: 0, reset: 1500 * time.Millisecond},
+		{name: "refill stops at burst", advance: time.Hour, allowed: true, remaining: 2, reset: 500 * time.Millisecond},
+	}
+
+	for _, st := range steps {
+		*now = now.Add(st.advance)
+		res := l.Allow(b)
+		want := Result{Allowed: st.allowed, Limit: 3, Remaining: st.remaining, Reset: st.reset, RetryAfter: st.retryAfter}
+		if res != want {
+			t.Fatalf("%s: Allow() = %+v, want %+v", st.name, res, want)
+		}
+	}
+}
+
+func TestAllowMostRestrictive(t *testing.T) {
+	l, _ := newTestLimiter(0)
+	ip := Bucket{Key: "ip", Limit: Limit{Rate: 1, Burst: 5}}
+	network := Bucket{Key: "net", Limit: Limit{Rate: 1, Burst: 2}}
+	off := Bucket{Key: "off"}
+
+	for i := 0; i < 2; i++ {
+		if res := l.Allow(ip, network, off); !res.Allowed || res.Limit != 2 {
+			t.Fatalf("request %d: Allow() = %+v, want allowed with the network's limit", i, res)
+		}
+	}
+	if res := l.Allow(ip, network); res.Allowed || res.RetryAfter != time.Second {
+		t.Fatalf("Allow() = %+v, want denied by the network bucket", res)
+	}
+
+	// The denied request took nothing from the IP bucket.
+	if res := l.Allow(ip); !res.Allowed || res.Remaining != 2 {
+		t.Errorf("Allow(ip) = %+v, want 2 tokens left", res)
+	}
+	if l.Len() != 2 {
+		t.Errorf("Len() = %d, want 2: disabled limits need no bucket", l.Len())
+	}
+}
+
+func TestDeniedRequestsCreateNoBuckets(t *testing.T) {
+	l, _ := newTestLimiter(3)
+	network := Bucket{Key: "net", Limit: Limit{Rate: 1, Burst: 1}}
+	client := func(i int) Bucket {
+		return Bucket{Key: fmt.Sprintf("ip:%d", i), Limit: Limit{Rate: 1, Burst: 1}}
+	}
+
+	if res := l.Allow(client(0), network); !res.Allowed {
+		t.Fatal("first request denied")
+	}
+	// The same client is out of tokens, and keeps being denied.
+	if res := l.Allow(client(0)); res.Allowed {
+		t.Fatal("empty bucket allowed a request")
+	}
+
+	// A flood from new addresses in the same network is denied by the
+	// network bucket and must not push client 0 out of the limiter.
+	for i := 1; i <= 100; i++ {
+		if res := l.Allow(client(i), network); res.Allowed {
+			t.Fatalf("request from client %d allowed", i)
+		}
+	}
+	if l.Len() != 2 {
+		t.Errorf("Len() = %d after the flood, want 2", l.Len())
+	}
+	if res := l.Allow(client(0)); res.Allowed {
+		t.Error("client 0 was evicted and handed a fresh burst")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	l, _ := newTestLimiter(2)
+	limit := Limit{Rate: 1, Burst: 1}
+
+	l.Allow(Bucket{Key: "a", Limit: limit})
+	l.Allow(Bucket{Key: "b", Limit: limit})
+	l.Allow(Bucket{Key: "a", Limit: limit}) // denied, but a is now the most recent
+	l.Allow(Bucket{Key: "c", Limit: limit})
+
+	if l.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", l.Len())
+	}
+	if res := l.Allow(Bucket{Key: "a", Limit: limit}); res.Allowed {
+		t.Error("recently used bucket a was evicted")
+	}
+	if res := l.Allow(Bucket{Key: "b", Limit: limit}); !res.Allowed {
+		t.Error("least recently used bucket b was kept")
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Limit
+		wantErr bool
+	}{
+		{spec: "", want: Limit{}},
+		{spec: "off", want: Limit{}},
+		{spec: "10/s", want: Limit{Rate: 10, Burst: 10}},
+		{spec: "600/m:50", want: Limit{Rate: 10, Burst: 50}},
+		{spec: "3600/h", want: Limit{Rate: 1, Burst: 3600}},
+		{spec: "10", wantErr: true},
+		{spec: "10/w", wantErr: true},
+		{spec: "-1/s", wantErr: true},
+		{spec: "10/s:0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.spec)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v, %v, want %+v, error %v", tt.spec, got, err, tt.want, tt.wantErr)
+		}
+		if err == nil && got.Enabled() {
+			if again, _ := ParseLimit(got.String()); again != got {
+				t.Errorf("ParseLimit(%q) = %+v, want %+v back", got.String(), again, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/apikey"
+	"github.com/andreybrigunet/IpContext/geoip"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request ID to and from clients.
+const RequestIDHeader = "X-Request-ID"
+
+// Access log fields.
+const (
+	FieldMethod         = "method"
+	FieldPath           = "path"
+	FieldQuery          = "query"
+	FieldRoute          = "route"
+	FieldStatus         = "status"
+	FieldBytes          = "bytes"
+	FieldDuration       = "duration"
+	FieldClientIP       = "client_ip"
+	FieldRemoteAddr     = "remote_addr"
+	FieldRequestID      = "request_id"
+	FieldTraceID        = "trace_id"
+	FieldAPIKey         = "api_key"
+	FieldUserAgent      = "user_agent"
+	FieldReferer        = "referer"
+	FieldProto          = "proto"
+	FieldCache          = "cache"
+	FieldLookups        = "lookups"
+	FieldLookupDuration = "lookup_duration"
+)
+
+// DefaultAccessLogFields are logged when no fields are configured.
+var DefaultAccessLogFields = []string{
+	FieldMethod, FieldPath, FieldStatus, FieldDuration, FieldClientIP,
+	FieldRequestID, FieldCache, FieldLookupDuration, FieldUserAgent,
+}
+
+var accessLogFields = map[string]bool{
+	FieldMethod: true, FieldPath: true, FieldQuery: true, FieldRoute: true,
+	FieldStatus: true, FieldBytes: true, FieldDuration: true, FieldClientIP: true,
+	FieldRemoteAddr: true, FieldRequestID: true, FieldTraceID: true, FieldAPIKey: true,
+	FieldUserAgent: true, FieldReferer: true, FieldProto: true, FieldCache: true,
+	FieldLookups: true, FieldLookupDuration: true,
+}
+
+// AccessLog configures access logging.
+type AccessLog struct {
+	Fields []string // defaults to DefaultAccessLogFields
+
+	// SampleRate is the fraction of requests logged, between 0 and 1.
+	// Server errors are always logged.
+	SampleRate float64
+}
+
+// CheckAccessLogFields reports the first unknown access log field.
+func CheckAccessLogFields(fields []string) error {
+	for _, f := range fields {
+		if !accessLogFields[f] {
+			return fmt.Errorf("unknown access log field %q", f)
+		}
+	}
+	return nil
+}
+
+type requestIDKey struct{}
+type accessRecordKey struct{}
+
+// accessRecord collects details that inner handlers know about a request.
+type accessRecord struct {
+	apiKey string
+}
+
+// RequestID returns the ID of the request carried by ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDMiddleware keeps a well-formed X-Request-ID from the client or
+// generates one, and echoes it in the response.
+func (s *Server) requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("ipcontext.request_id", id))
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// setAccessKey records the API key name of the request for the access log.
+func setAccessKey(r *http.Request, name string) {
+	if rec, ok := r.Context().Value(accessRecordKey{}).(*accessRecord); ok {
+		rec.apiKey = name
+	}
+}
+
+// accessLogMiddleware writes one info-level line per sampled request.
+func (s *Server) accessLogMiddleware(next http.Handler) http.Handler {
+	cfg := s.opts.AccessLog
+	fields := cfg.Fields
+	if len(fields) == 0 {
+		fields = DefaultAccessLogFields
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rec := &accessRecord{}
+		ctx, stats := geoip.WithLookupStats(context.WithValue(r.Context(), accessRecordKey{}, rec))
+		r = r.WithContext(ctx)
+
+		wrapped := &accessWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(wrapped, r)
+
+		if wrapped.status < 500 && cfg.SampleRate < 1 && mathrand.Float64() >= cfg.SampleRate {
+			return
+		}
+
+		ev := s.log.Info()
+		for _, f := range fields {
+			s.addAccessField(ev, f, r, wrapped, rec, stats, time.Since(start))
+		}
+		ev.Msg("HTTP request")
+	})
+}
+
+func (s *Server) addAccessField(ev *zerolog.Event, field string, r *http.Request, w *accessWriter, rec *accessRecord, stats *geoip.LookupStats, elapsed time.Duration) {
+	switch field {
+	case FieldMethod:
+		ev.Str(field, r.Method)
+	case FieldPath:
+		ev.Str(field, s.logPath(r.URL.Path))
+	case FieldQuery:
+		ev.Str(field, s.logQuery(r.URL.RawQuery))
+	case FieldRoute:
+		ev.Str(field, routeFor(r))
+	case FieldStatus:
+		ev.Int(field, w.status)
+	case FieldBytes:
+		ev.Int(field, w.bytes)
+	case FieldDuration:
+		ev.Dur(field, elapsed)
+	case FieldClientIP:
+		ev.Str(field, s.opts.Privacy.String(s.extractClientIP(r)))
+	case FieldRemoteAddr:
+		ev.Str(field, s.opts.Privacy.String(r.RemoteAddr))
+	case FieldRequestID:
+		ev.Str(field, RequestID(r.Context()))
+	case FieldTraceID:
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			ev.Str(field, sc.TraceID().String())
+		}
+	case FieldAPIKey:
+		if rec.apiKey != "" {
+			ev.Str(field, rec.apiKey)
+		}
+	case FieldUserAgent:
+		ev.Str(field, r.UserAgent())
+	case FieldReferer:
+		if ref := r.Referer(); ref != "" {
+			ev.Str(field, ref)
+		}
+	case FieldProto:
+		ev.Str(field, r.Proto)
+	case FieldCache:
+		if stats.Lookups > 0 {
+			ev.Str(field, cacheResult(stats))
+		}
+	case FieldLookups:
+		ev.Int(field, stats.Lookups)
+	case FieldLookupDuration:
+		if stats.Lookups > 0 {
+			ev.Dur(field, stats.Duration)
+		}
+	}
+}
+
+// logPath anonymizes the address in lookup paths such as /8.8.8.8.
+func (s *Server) logPath(path string) string {
+	if len(path) > 1 && net.ParseIP(path[1:]) != nil {
+		return "/" + s.opts.Privacy.String(path[1:])
+	}
+	return path
+}
+
+// logQuery drops API keys from a query and anonymizes addresses in it.
+func (s *Server) logQuery(rawQuery string) string {
+	q, err := url.ParseQuery(apikey.StripQuery(rawQuery))
+	if err != nil || len(q) == 0 {
+		return ""
+	}
+	for _, values := range q {
+		for i, v := range values {
+			if net.ParseIP(v) != nil {
+				values[i] = s.opts.Privacy.String(v)
+			}
+		}
+	}
+	return q.Encode()
+}
+
+// cacheResult is "hit" or "miss", or "partial" when only some of a batch's
+// lookups were cached.
+func cacheResult(stats *geoip.LookupStats) string {
+	switch stats.CacheHits {
+	case stats.Lookups:
+		return "hit"
+	case 0:
+		return "miss"
+	}
+	return "partial"
+}
+
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *accessWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/andreybrigunet/IpContext/apikey"
+)
+
+// endpointUsage is open to every valid key regardless of its endpoint scopes.
+const endpointUsage = "usage"
+
+// chainFields are the lookup fields /chain reveals for each hop.
+var chainFields = []string{"country", "countryCode", "as", "asname"}
+
+// endpointFor maps a request path to the API key scope it needs. An empty
+// result means the path is open: health checks, status and forward auth are
+// called by infrastructure that cannot present a key.
+func endpointFor(path string) string {
+	switch {
+	case path == "/health", path == "/live", path == "/ready", path == "/status", path == "/auth":
+		return ""
+	case path == "/usage":
+		return endpointUsage
+	case path == "/batch":
+		return apikey.EndpointBatch
+	default:
+		return apikey.EndpointSingle
+	}
+}
+
+// routeFor returns the route label used in metrics. Lookups of specific
+// addresses share one label to keep cardinality bounded.
+func routeFor(r *http.Request) string {
+	switch p := r.URL.Path; p {
+	case "/", "/health", "/live", "/ready", "/status", "/batch", "/chain", "/auth", "/usage":
+		return p
+	default:
+		return "/{ip}"
+	}
+}
+
+// authMiddleware checks the API key of every request to a protected endpoint
+// and stores the key in the request context for the handlers.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := endpointFor(r.URL.Path)
+		if endpoint == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		raw := apikey.FromRequest(r)
+		if raw == "" {
+			if !s.opts.APIKeysRequired {
+				next.ServeHTTP(w, r)
+				return
+			}
+			s.respondUnauthorized(w, "API key required")
+			return
+		}
+
+		key, ok := s.apiKeys.Authenticate(raw)
+		if !ok {
+			s.log.Debug().Str("path", r.URL.Path).Msg("Rejected invalid API key")
+			s.respondUnauthorized(w, "Invalid API key")
+			return
+		}
+		setAccessKey(r, key.Name)
+
+		if endpoint != endpointUsage && !key.AllowsEndpoint(endpoint) {
+			s.respondError(w, "API key is not permitted to use this endpoint", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/chain" {
+			if _, denied := key.Fields(chainFields); denied != "" {
+				s.respondError(w, "API key is not permitted to read field "+denied, http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(apikey.NewContext(r.Context(), key)))
+	})
+}
+
+func (s *Server) respondUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="ipcontext"`)
+	s.respondError(w, message, http.StatusUnauthorized)
+}
+
+// scopeFields applies the field scope of the request's API key, if any, to
+// the requested fields. denied names the first field the key may not read.
+func scopeFields(r *http.Request, requested []string) (fields []string, denied string) {
+	key, ok := apikey.FromContext(r.Context())
+	if !ok {
+		return requested, ""
+	}
+	return key.Fields(requested)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/apikey"
+	"github.com/andreybrigunet/IpContext/geoip/geoiptest"
+	"github.com/rs/zerolog"
+)
+
+// newTestHandler returns the handler of a server backed by the test
+// databases.
+func newTestHandler(t *testing.T, opts Options) http.Handler {
+	t.Helper()
+	return NewServer(opts, geoiptest.New(t, time.Minute), zerolog.Nop()).server.Handler
+}
+
+// serve sends one request to h. header holds extra request headers.
+func serve(h http.Handler, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:1234"
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func newTestKeys(t *testing.T, keys string) *apikey.Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := apikey.New(path, 0, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAuth(t *testing.T) {
+	keys := newTestKeys(t, `{"keys": [
+		{"name": "full", "hash": "`+apikey.Hash("full")+`"},
+		{"name": "batch-only", "hash": "`+apikey.Hash("batch-only")+`", "scopes": {"endpoints": ["batch"]}},
+		{"name": "country", "hash": "`+apikey.Hash("country")+`", "scopes": {"fields": ["country", "countryCode"]}},
+		{"name": "small", "hash": "`+apikey.Hash("small")+`", "scopes": {"maxBatch": 2}}
+	]}`)
+
+	tests := []struct {
+		name     string
+		required bool
+		method   string
+		target   string
+		body     string
+		header   map[string]string
+		status   int
+	}{
+		{name: "anonymous allowed", target: "/8.8.8.8", status: http.StatusOK},
+		{name: "anonymous required", required: true, target: "/8.8.8.8", status: http.StatusUnauthorized},
+		{name: "anonymous health", required: true, target: "/health", status: http.StatusOK},
+		{name: "valid key in header", required: true, target: "/8.8.8.8", header: map[string]string{"X-API-Key": "full"}, status: http.StatusOK},
+		{name: "valid bearer key", required: true, target: "/8.8.8.8", header: map[string]string{"Authorization": "Bearer full"}, status: http.StatusOK},
+		{name: "valid key in query", required: true, target: "/8.8.8.8?key=full", status: http.StatusOK},
+		{name: "wrong key in header", target: "/8.8.8.8", header: map[string]string{"X-API-Key": "wrong"}, status: http.StatusUnauthorized},
+		{name: "wrong key in query", target: "/8.8.8.8?key=wrong", status: http.StatusUnauthorized},
+		{name: "header wins over query", required: true, target: "/8.8.8.8?key=wrong", header: map[string]string{"X-API-Key": "full"}, status: http.StatusOK},
+		{name: "endpoint out of scope", target: "/8.8.8.8", header: map[string]string{"X-API-Key": "batch-only"}, status: http.StatusForbidden},
+		{name: "endpoint in scope", method: http.MethodPost, target: "/batch", body: `["8.8.8.8"]`, header: map[string]string{"X-API-Key": "batch-only"}, status: http.StatusOK},
+		{name: "denied field", target: "/8.8.8.8?fields=city", header: map[string]string{"X-API-Key": "country"}, status: http.StatusForbidden},
+		{name: "allowed field", target: "/8.8.8.8?fields=country", header: map[string]string{"X-API-Key": "country"}, status: http.StatusOK},
+		{name: "chain needs its fields", target: "/chain", header: map[string]string{"X-API-Key": "country"}, status: http.StatusForbidden},
+		{name: "batch within maxBatch", method: http.MethodPost, target: "/batch", body: `["8.8.8.8", "1.1.1.1"]`, header: map[string]string{"X-API-Key": "small"}, status: http.StatusOK},
+		{name: "oversized batch", method: http.MethodPost, target: "/batch", body: `["8.8.8.8", "1.1.1.1", "91.198.174.1"]`, header: map[string]string{"X-API-Key": "small"}, status: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, Options{APIKeys: keys, APIKeysRequired: tt.required})
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			w := serve(h, method, tt.target, tt.body, tt.header)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+			if w.Code >= 400 {
+				var body struct{ Status, Message string }
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Status != "fail" || body.Message == "" {
+					t.Errorf("error body = %s, want status fail with a message", w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthFieldScopeTrimsResponse(t *testing.T) {
+	keys := newTestKeys(t, `{"keys": [{"name": "country", "hash": "`+apikey.Hash("country")+`", "scopes": {"fields": ["countryCode"]}}]}`)
+	h := newTestHandler(t, Options{APIKeys: keys})
+
+	w := serve(h, http.MethodGet, "/8.8.8.8", "", map[string]string{"X-API-Key": "country"})
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != 3 || body["countryCode"] != "US" || body["query"] != "8.8.8.8" || body["status"] != "success" {
+		t.Errorf("body = %v, want only query, status and countryCode", body)
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/andreybrigunet/IpContext/apikey"
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+// maxBatchSize mirrors the ip-api batch limit.
+const maxBatchSize = 100
+
+// batchQuery is one entry of a batch request. Entries may also be plain
+// strings, in which case only the query is set.
+type batchQuery struct {
+	Query  string `json:"query"`
+	Fields string `json:"fields,omitempty"`
+}
+
+func (q *batchQuery) UnmarshalJSON(data []byte) error {
+	var ip string
+	if err := json.Unmarshal(data, &ip); err == nil {
+		q.Query = ip
+		return nil
+	}
+
+	type plain batchQuery
+	return json.Unmarshal(data, (*plain)(q))
+}
+
+// handleBatch looks up several addresses in one request:
+// POST /batch with ["8.8.8.8", {"query": "1.1.1.1", "fields": "country"}].
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		s.respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var queries []batchQuery
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&queries); err != nil {
+		s.respondError(w, "Invalid batch request body", http.StatusBadRequest)
+		return
+	}
+
+	limit := maxBatchSize
+	key, hasKey := apikey.FromContext(r.Context())
+	if hasKey && key.Scopes.MaxBatch > 0 && key.Scopes.MaxBatch < limit {
+		limit = key.Scopes.MaxBatch
+	}
+	if len(queries) > limit {
+		s.respondError(w, "Too many queries in batch", http.StatusUnprocessableEntity)
+		return
+	}
+	if !s.reserveUsage(w, r, len(queries)) {
+		return
+	}
+
+	defaultFields, denied := scopeFields(r, geoip.ParseFields(r.URL.Query().Get("fields")))
+	if denied != "" {
+		s.respondError(w, "API key is not permitted to read field "+denied, http.StatusForbidden)
+		return
+	}
+
+	if !s.geoIP.Loaded() {
+		s.respondNotLoaded(w)
+		return
+	}
+
+	prof := s.precisionFor(w, r)
+	results := make([]interface{}, 0, len(queries))
+	served := 0
+	for _, q := range queries {
+		ip := net.ParseIP(q.Query)
+		if ip == nil {
+			results = append(results, &geoip.Response{Query: q.Query, Status: "fail", Message: "invalid query"})
+			continue
+		}
+
+		fields := defaultFields
+		if q.Fields != "" {
+			if fields, denied = scopeFields(r, geoip.ParseFields(q.Fields)); denied != "" {
+				results = append(results, &geoip.Response{Query: q.Query, Status: "fail", Message: "field not permitted: " + denied})
+				continue
+			}
+		}
+
+		resp, err := s.geoIP.LookupWithContext(r.Context(), ip.String())
+		if err != nil {
+			s.log.Error().Err(err).Str("ip", s.opts.Privacy.String(q.Query)).Msg("Batch lookup failed")
+			results = append(results, &geoip.Response{Query: q.Query, Status: "fail", Message: "lookup failed"})
+			continue
+		}
+
+		served++
+		resp = prof.Apply(resp)
+		if len(fields) > 0 {
+			results = append(results, resp.Select(fields))
+		} else {
+			results = append(results, resp)
+		}
+	}
+
+	setUsageCost(r, served)
+	s.respondJSON(w, results, http.StatusOK)
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/andreybrigunet/IpContext/clientip"
+)
+
+// chainHop is one address along the path a request took.
+type chainHop struct {
+	Address        string `json:"address"`
+	Source         string `json:"source"` // header the hop came from, or "peer"
+	Valid          bool   `json:"valid"`  // false for "unknown" or obfuscated nodes
+	Trusted        bool   `json:"trusted"`
+	Private        bool   `json:"private"`
+	Client         bool   `json:"client,omitempty"`     // the hop selected as the client IP
+	Unverified     bool   `json:"unverified,omitempty"` // left of the client, may be spoofed
+	CountryChanged bool   `json:"countryChanged,omitempty"`
+	Country        string `json:"country,omitempty"`
+	CountryCode    string `json:"countryCode,omitempty"`
+	AS             string `json:"as,omitempty"`
+	ASName         string `json:"asname,omitempty"`
+}
+
+// viaHop is one entry of the Via header.
+type viaHop struct {
+	Protocol   string `json:"protocol"`
+	ReceivedBy string `json:"receivedBy"`
+	Comment    string `json:"comment,omitempty"`
+}
+
+type chainFlags struct {
+	PrivateHops    int  `json:"privateHops"`
+	UntrustedHops  int  `json:"untrustedHops"`
+	CountryChanges int  `json:"countryChanges"`
+	InvalidHops    int  `json:"invalidHops"`
+	Spoofable      bool `json:"spoofable"` // addresses were supplied by an untrusted party
+}
+
+// maxChainHops caps the forwarded hops that are geolocated, so that a huge
+// header cannot turn one request into thousands of lookups.
+const maxChainHops = 32
+
+type chainResponse struct {
+	Status    string     `json:"status"`
+	Client    string     `json:"client"`
+	Source    string     `json:"source,omitempty"`
+	Truncated int        `json:"truncated,omitempty"` // left-most hops left out beyond maxChainHops
+	Hops      []chainHop `json:"hops"`
+	OtherHops []chainHop `json:"otherHops,omitempty"` // hops of the chain headers not in source
+	Via       []viaHop   `json:"via,omitempty"`
+	Flags     chainFlags `json:"flags"`
+}
+
+// chainHeaders are the headers listing a whole chain, shown whether or not
+// they are the configured client IP header.
+var chainHeaders = []string{clientip.HeaderXForwardedFor, clientip.HeaderForwarded}
+
+func parseChain(h http.Header, header string) []string {
+	if header == clientip.HeaderForwarded {
+		return clientip.Forwarded(h)
+	}
+	return clientip.ForwardedFor(h)
+}
+
+// handleChain geolocates every hop of the proxy chain, in order from the
+// original client to the directly connected peer.
+func (s *Server) handleChain(w http.ResponseWriter, r *http.Request) {
+	source, addrs := s.clientIP.Chain(r)
+	client := s.clientIP.ClientHop(r, addrs)
+	if len(addrs) == 0 {
+		// Show the path the chain headers claim, although the peer is
+		// the client and none of it can be verified.
+		for _, header := range chainHeaders {
+			if source, addrs = header, parseChain(r.Header, header); len(addrs) > 0 {
+				break
+			}
+		}
+		if len(addrs) == 0 {
+			source = ""
+		}
+		client = len(addrs)
+	}
+
+	peerAddr := r.RemoteAddr
+	if peer := clientip.PeerIP(r); peer != nil {
+		peerAddr = peer.String()
+	}
+	resp := chainResponse{Status: "success", Client: peerAddr, Source: source}
+	if client < len(addrs) {
+		resp.Client = addrs[client]
+	}
+
+	// Keep the hops nearest to us: they decide the client, while the ones
+	// further left are unverified anyway. When all hops are trusted the
+	// client itself may be left out, and clientIdx goes negative.
+	if len(addrs) > maxChainHops {
+		resp.Truncated = len(addrs) - maxChainHops
+		addrs = addrs[resp.Truncated:]
+	}
+	clientIdx := client - resp.Truncated
+
+	hops := make([]chainHop, 0, len(addrs)+1)
+	for _, a := range addrs {
+		hops = append(hops, chainHop{Address: a, Source: source})
+	}
+	hops = append(hops, chainHop{Address: peerAddr, Source: "peer"})
+
+	// The other chain headers share what is left of the cap.
+	var others []chainHop
+	left := maxChainHops - len(addrs)
+	for _, header := range chainHeaders {
+		if header == source {
+			continue
+		}
+		other := parseChain(r.Header, header)
+		if len(other) > left {
+			resp.Truncated += len(other) - left
+			other = other[len(other)-left:]
+		}
+		left -= len(other)
+		for _, a := range other {
+			others = append(others, chainHop{Address: a, Source: header, Unverified: true})
+		}
+	}
+
+	// Each hop may cost a lookup, so meter them like a batch.
+	if !s.reserveUsage(w, r, len(hops)+len(others)) {
+		return
+	}
+
+	looked := 0
+	lastCountry := ""
+	for i := range hops {
+		h := &hops[i]
+		h.Client = i == clientIdx
+		h.Unverified = i < clientIdx
+		if s.locateHop(r, h, &resp.Flags) {
+			looked++
+		}
+
+		if h.CountryCode != "" {
+			if lastCountry != "" && h.CountryCode != lastCountry {
+				h.CountryChanged = true
+				resp.Flags.CountryChanges++
+			}
+			lastCountry = h.CountryCode
+		}
+	}
+	for i := range others {
+		if s.locateHop(r, &others[i], &resp.Flags) {
+			looked++
+		}
+	}
+
+	setUsageCost(r, looked)
+	resp.Hops = hops
+	resp.OtherHops = others
+	resp.Via = parseVia(r.Header.Values("Via"))
+	if len(resp.Via) > maxChainHops {
+		resp.Via = resp.Via[len(resp.Via)-maxChainHops:]
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.respondJSON(w, resp, http.StatusOK)
+}
+
+// locateHop classifies h, counts it in flags and geolocates it unless it is
+// private. It reports whether a lookup was made.
+func (s *Server) locateHop(r *http.Request, h *chainHop, flags *chainFlags) bool {
+	ip := net.ParseIP(h.Address)
+	if ip == nil {
+		flags.InvalidHops++
+		return false
+	}
+
+	h.Valid = true
+	h.Trusted = s.clientIP.IsTrusted(ip)
+	h.Private = isPrivate(ip)
+
+	if h.Private {
+		flags.PrivateHops++
+	}
+	if !h.Trusted {
+		flags.UntrustedHops++
+	}
+	if h.Unverified {
+		flags.Spoofable = true
+	}
+
+	if h.Private {
+		return false
+	}
+
+	loc, err := s.geoIP.LookupWithContext(r.Context(), ip.String())
+	if err != nil {
+		s.log.Debug().Err(err).Str("ip", s.opts.Privacy.String(h.Address)).Msg("Chain hop lookup failed")
+		return false
+	}
+
+	h.Country = loc.Country
+	h.CountryCode = loc.CountryCode
+	h.AS = loc.AS
+	h.ASName = loc.ASName
+	return true
+}
+
+// parseVia splits "1.1 vegur, HTTP/1.0 proxy.example (Squid)" into entries.
+func parseVia(values []string) []viaHop {
+	var out []viaHop
+	for _, v := range values {
+		for _, entry := range strings.Split(v, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+
+			var hop viaHop
+			if i := strings.IndexByte(entry, '('); i >= 0 {
+				hop.Comment = strings.Trim(strings.TrimSpace(entry[i:]), "()")
+				entry = strings.TrimSpace(entry[:i])
+			}
+
+			parts := strings.Fields(entry)
+			if len(parts) > 0 {
+				hop.Protocol = parts[0]
+			}
+			if len(parts) > 1 {
+				hop.ReceivedBy = parts[1]
+			}
+			out = append(out, hop)
+		}
+	}
+	return out
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andreybrigunet/IpContext/clientip"
+)
+
+func TestChain(t *testing.T) {
+	var long []string
+	for i := 1; i <= maxChainHops+8; i++ {
+		long = append(long, fmt.Sprintf("8.8.8.%d", i))
+	}
+
+	type hop struct {
+		addr    string
+		flags   string // c client, u unverified, p private, x country changed, i invalid
+		country string
+	}
+	peer := hop{addr: "192.0.2.1"}
+
+	tests := []struct {
+		name      string
+		trusted   []string
+		header    string
+		headers   map[string]string
+		client    string
+		source    string
+		truncated int
+		hops      []hop // hops[0] and the last ones when truncated
+		others    []hop
+		flags     chainFlags
+	}{
+		{
+			name:    "trusted peer",
+			trusted: []string{"192.0.2.0/24"},
+			header:  clientip.HeaderXForwardedFor,
+			headers: map[string]string{"X-Forwarded-For": "8.8.8.8, 91.198.174.1"},
+			client:  "91.198.174.1",
+			source:  clientip.HeaderXForwardedFor,
+			hops:    []hop{{"8.8.8.8", "u", "US"}, {"91.198.174.1", "cx", "DE"}, peer},
+			flags:   chainFlags{UntrustedHops: 2, CountryChanges: 1, Spoofable: true},
+		},
+		{
+			name:    "untrusted peer",
+			trusted: []string{"10.0.0.0/8"},
+			header:  clientip.HeaderXForwardedFor,
+			headers: map[string]string{"X-Forwarded-For": "8.8.8.8"},
+			client:  "192.0.2.1",
+			source:  clientip.HeaderXForwardedFor,
+			hops:    []hop{{"8.8.8.8", "u", "US"}, {"192.0.2.1", "c", ""}},
+			flags:   chainFlags{UntrustedHops: 2, Spoofable: true},
+		},
+		{
+			name:    "unknown hop stops the walk",
+			trusted: []string{"192.0.2.0/24"},
+			header:  clientip.HeaderXForwardedFor,
+			headers: map[string]string{"X-Forwarded-For": "8.8.8.8, unknown"},
+			client:  "192.0.2.1",
+			source:  clientip.HeaderXForwardedFor,
+			hops:    []hop{{"8.8.8.8", "u", "US"}, {"unknown", "ui", ""}, {"192.0.2.1", "c", ""}},
+			flags:   chainFlags{InvalidHops: 1, UntrustedHops: 1, Spoofable: true},
+		},
+		{
+			name:    "private hops are not looked up",
+			trusted: []string{"192.0.2.0/24", "10.0.0.0/8"},
+			header:  clientip.HeaderXForwardedFor,
+			headers: map[string]string{"X-Forwarded-For": "1.1.1.1, 10.0.0.2, 10.0.0.3"},
+			client:  "1.1.1.1",
+			source:  clientip.HeaderXForwardedFor,
+			hops:    []hop{{"1.1.1.1", "c", "AU"}, {"10.0.0.2", "p", ""}, {"10.0.0.3", "p", ""}, peer},
+			flags:   chainFlags{PrivateHops: 2, UntrustedHops: 1},
+		},
+		{
+			name:    "country changes",
+			trusted: []string{"0.0.0.0/0"},
+			header:  clientip.HeaderXForwardedFor,
+			headers: map[string]string{"X-Forwarded-For": "8.8.8.8, 81.2.69.1, 81.2.69.2, 1.1.1.1"},
+			client:  "8.8.8.8",
+			source:  clientip.HeaderXForwardedFor,
+			hops:    []hop{{"8.8.8.8", "c", "US"}, {"81.2.69.1", "x", "GB"}, {"81.2.69.2", "", "GB"}, {"1.1.1.1", "x", "AU"}, peer},
+			flags:   chainFlags{CountryChanges: 2},
+		},
+		{
+			name:      "truncated",
+			trusted:   []string{"192.0.2.0/24"},
+			header:    clientip.HeaderXForwardedFor,
+			headers:   map[string]string{"X-Forwarded-For": strings.Join(long, ", ")},
+			client:    long[len(long)-1],
+			source:    clientip.HeaderXForwardedFor,
+			truncated: 8,
+			hops:      []hop{{long[8], "u", "US"}, {long[len(long)-1], "c", "US"}, peer},
+			flags:     chainFlags{UntrustedHops: maxChainHops, Spoofable: true},
+		},
+		{
+			name:      "truncated client",
+			trusted:   []string{"0.0.0.0/0"},
+			header:    clientip.HeaderXForwardedFor,
+			headers:   map[string]string{"X-Forwarded-For": strings.Join(long, ", ")},
+			client:    long[0],
+			source:    clientip.HeaderXForwardedFor,
+			truncated: 8,
+			hops:      []hop{{long[8], "", "US"}, {long[len(long)-1], "", "US"}, peer},
+		},
+		{
+			name:    "no header configured",
+			headers: map[string]string{"X-Forwarded-For": "8.8.8.8", "Forwarded": `for=1.1.1.1, for="[2001:db8::1]"`},
+			client:  "192.0.2.1",
+			source:  clientip.HeaderXForwardedFor,
+			hops:    []hop{{"8.8.8.8", "u", "US"}, {"192.0.2.1", "c", ""}},
+			others:  []hop{{"1.1.1.1", "u", "AU"}, {"2001:db8::1", "u", "FR"}},
+			flags:   chainFlags{UntrustedHops: 4, Spoofable: true},
+		},
+		{
+			name:    "forwarded when x-forwarded-for is absent",
+			headers: map[string]string{"Forwarded": "for=1.1.1.1"},
+			client:  "192.0.2.1",
+			source:  clientip.HeaderForwarded,
+			hops:    []hop{{"1.1.1.1", "u", "AU"}, {"192.0.2.1", "c", ""}},
+			flags:   chainFlags{UntrustedHops: 2, Spoofable: true},
+		},
+		{
+			name:    "other header is unverified",
+			trusted: []string{"192.0.2.0/24"},
+			header:  clientip.HeaderXRealIP,
+			headers: map[string]string{"X-Real-Ip": "8.8.8.8", "X-Forwarded-For": "1.1.1.1"},
+			client:  "8.8.8.8",
+			source:  clientip.HeaderXRealIP,
+			hops:    []hop{{"8.8.8.8", "c", "US"}, peer},
+			others:  []hop{{"1.1.1.1", "u", "AU"}},
+			flags:   chainFlags{UntrustedHops: 2, Spoofable: true},
+		},
+		{
+			name:   "no chain",
+			client: "192.0.2.1",
+			hops:   []hop{{"192.0.2.1", "c", ""}},
+			flags:  chainFlags{UntrustedHops: 1},
+		},
+	}
+
+	check := func(t *testing.T, kind string, got []chainHop, want []hop, truncated bool) {
+		t.Helper()
+		if truncated {
+			if len(got) != maxChainHops+1 {
+				t.Fatalf("%d %s, want %d", len(got), kind, maxChainHops+1)
+			}
+			got = []chainHop{got[0], got[len(got)-2], got[len(got)-1]}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s = %+v, want %d", kind, got, len(want))
+		}
+		for i, w := range want {
+			g := got[i]
+			flags := ""
+			for _, f := range []struct {
+				set  bool
+				flag string
+			}{{g.Client, "c"}, {g.Unverified, "u"}, {g.Private, "p"}, {g.CountryChanged, "x"}, {!g.Valid, "i"}} {
+				if f.set {
+					flags += f.flag
+				}
+			}
+			if g.Address != w.addr || flags != w.flags || g.CountryCode != w.country {
+				t.Errorf("%s[%d] = %s %q %s, want %s %q %s", kind, i, g.Address, flags, g.CountryCode, w.addr, w.flags, w.country)
+			}
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted, err := clientip.ParseCIDRs(tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := newTestHandler(t, Options{ClientIP: clientip.NewResolver(trusted, tt.header)})
+
+			w := serve(h, http.MethodGet, "/chain", "", tt.headers)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			var resp chainResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Client != tt.client || resp.Source != tt.source || resp.Truncated != tt.truncated {
+				t.Errorf("client %s, source %q, truncated %d, want %s, %q, %d",
+					resp.Client, resp.Source, resp.Truncated, tt.client, tt.source, tt.truncated)
+			}
+			check(t, "hops", resp.Hops, tt.hops, tt.truncated > 0)
+			check(t, "otherHops", resp.OtherHops, tt.others, false)
+			if resp.Flags != tt.flags {
+				t.Errorf("flags = %+v, want %+v", resp.Flags, tt.flags)
+			}
+		})
+	}
+}
+
+func TestChainVia(t *testing.T) {
+	h := newTestHandler(t, Options{})
+
+	via := make([]string, maxChainHops+2)
+	for i := range via {
+		via[i] = fmt.Sprintf("1.1 proxy%d", i)
+	}
+	w := serve(h, http.MethodGet, "/chain", "", map[string]string{"Via": strings.Join(via, ", ")})
+
+	var resp chainResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Via) != maxChainHops || resp.Via[0].ReceivedBy != "proxy2" {
+		t.Errorf("via = %d entries from %+v, want the last %d", len(resp.Via), resp.Via[0], maxChainHops)
+	}
+}
+
+func TestParseVia(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   []viaHop
+	}{
+		{values: nil, want: nil},
+		{values: []string{"1.1 vegur"}, want: []viaHop{{Protocol: "1.1", ReceivedBy: "vegur"}}},
+		{
+			values: []string{"1.0 fred, 1.1 p.example.net"},
+			want:   []viaHop{{Protocol: "1.0", ReceivedBy: "fred"}, {Protocol: "1.1", ReceivedBy: "p.example.net"}},
+		},
+		{
+			values: []string{"HTTP/1.0 proxy.example (Squid/3.1)", "2 edge:443 ( cache ) "},
+			want: []viaHop{
+				{Protocol: "HTTP/1.0", ReceivedBy: "proxy.example", Comment: "Squid/3.1"},
+				{Protocol: "2", ReceivedBy: "edge:443", Comment: " cache "},
+			},
+		},
+		{values: []string{" , 1.1"}, want: []viaHop{{Protocol: "1.1"}}},
+	}
+
+	for _, tt := range tests {
+		if got := parseVia(tt.values); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseVia(%q) = %+v, want %+v", tt.values, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// CORS is the cross-origin policy of the API.
+type CORS struct {
+	// AllowedOrigins lists the origins browsers may call the API from; "*"
+	// allows any. Empty disables CORS.
+	AllowedOrigins []string
+
+	// MaxAge is how long, in seconds, browsers may cache preflight results;
+	// 0 leaves it to the browser.
+	MaxAge int
+}
+
+// SetCORS replaces the cross-origin policy; requests in flight keep the
+// previous one.
+func (s *Server) SetCORS(c CORS) {
+	s.cors.Store(&c)
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for a request
+// from origin, or "" when the origin is not allowed.
+func (c *CORS) allowOrigin(origin string) string {
+	if slices.Contains(c.AllowedOrigins, "*") {
+		return "*"
+	}
+	if origin != "" && slices.Contains(c.AllowedOrigins, origin) {
+		return origin
+	}
+	return ""
+}
+
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := s.cors.Load()
+		if allowed := c.allowOrigin(r.Header.Get("Origin")); allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Request-ID")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+			if c.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+			}
+		}
+		if len(c.AllowedOrigins) > 0 && !slices.Contains(c.AllowedOrigins, "*") {
+			// The response depends on the origin, so caches must key on it.
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/andreybrigunet/IpContext/access"
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+// handleForwardAuth answers forward-auth subrequests from Traefik, Caddy or
+// nginx auth_request with 200 or 403 based on the access rules.
+func (s *Server) handleForwardAuth(w http.ResponseWriter, r *http.Request) {
+	ipStr := s.extractClientIP(r)
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		s.log.Warn().Str("ip", s.opts.Privacy.String(ipStr)).Msg("Forward auth: invalid client IP")
+		s.respondAuth(w, false)
+		return
+	}
+
+	sub := access.Subject{IP: ip}
+
+	// Without location data no country or ASN rule could match, so a
+	// default-allow policy would let everyone through: fail closed instead.
+	loc, err := s.geoIP.LookupWithContext(r.Context(), ip.String())
+	if err != nil {
+		s.log.Warn().Err(err).Str("ip", s.opts.Privacy.String(ipStr)).Msg("Forward auth: lookup failed, denying")
+		if errors.Is(err, geoip.ErrNotLoaded) {
+			s.respondNotLoaded(w)
+		} else {
+			s.respondError(w, "Location lookup failed", http.StatusServiceUnavailable)
+		}
+		return
+	}
+	sub.Location = loc
+
+	// Rules see the full location; the headers only what the profile keeps.
+	hdr := loc
+	if prof := s.precisionFor(w, r); prof != nil {
+		hdr = prof.Apply(loc)
+	}
+	w.Header().Set("X-Geo-Country", hdr.CountryCode)
+	if asn := hdr.ASNumber(); asn != 0 {
+		w.Header().Set("X-Geo-ASN", strconv.FormatUint(uint64(asn), 10))
+	}
+
+	if s.access.NeedsAnonymousDB() {
+		anon, err := s.geoIP.AnonymousIP(ip)
+		if err != nil {
+			s.log.Warn().Err(err).Str("ip", s.opts.Privacy.String(ipStr)).Msg("Forward auth: anonymous IP lookup failed, denying")
+			s.respondError(w, "Location lookup failed", http.StatusServiceUnavailable)
+			return
+		}
+		sub.Anonymous = anon
+	}
+
+	decision := s.access.Evaluate(sub)
+
+	evt := s.log.Debug()
+	if decision.Rule != "" {
+		evt = s.log.Info()
+	}
+	evt.Str("ip", s.opts.Privacy.String(ipStr)).
+		Str("country", w.Header().Get("X-Geo-Country")).
+		Str("rule", decision.Rule).
+		Bool("allow", decision.Allow).
+		Msg("Forward auth decision")
+
+	s.respondAuth(w, decision.Allow)
+}
+
+func (s *Server) respondAuth(w http.ResponseWriter, allow bool) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if allow {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte(`{"status":"fail","message":"Access denied"}`))
+}
@@ -2,20 +2,100 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"net"
 	"net/http"
+	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/andreybrigunet/IpContext/access"
+	"github.com/andreybrigunet/IpContext/apikey"
+	"github.com/andreybrigunet/IpContext/clientip"
 	"github.com/andreybrigunet/IpContext/geoip"
+	"github.com/andreybrigunet/IpContext/listen"
+	"github.com/andreybrigunet/IpContext/loadshed"
+	"github.com/andreybrigunet/IpContext/metrics"
+	"github.com/andreybrigunet/IpContext/precision"
+	"github.com/andreybrigunet/IpContext/privacy"
+	"github.com/andreybrigunet/IpContext/proxyproto"
+	"github.com/andreybrigunet/IpContext/ratelimit"
+	"github.com/andreybrigunet/IpContext/usage"
 )
 
 type Server struct {
-	server *http.Server
-	geoIP  *geoip.GeoIP
-	log    zerolog.Logger
+	server   *http.Server
+	geoIP    *geoip.GeoIP
+	access   *access.Engine
+	apiKeys  *apikey.Store
+	clientIP *clientip.Resolver
+	limiter  *ratelimit.Limiter
+	usage    *usage.Meter
+	opts     Options
+	cors     atomic.Pointer[CORS]
+	started  time.Time
+	log      zerolog.Logger
+}
+
+// Options configures optional server features.
+type Options struct {
+	Addr       string             // host:port, unix:///path or systemd:[name]
+	SocketMode os.FileMode        // permissions for unix socket listeners
+	Access     *access.Engine     // enables the /auth forward-auth endpoint
+	ClientIP   *clientip.Resolver // decides which forwarding headers to trust
+
+	// APIKeys enables API key checks; anonymous callers are still served
+	// unless APIKeysRequired is set
+	APIKeys         *apikey.Store
+	APIKeysRequired bool
+
+	// Usage meters lookups per API key and enforces monthly quotas;
+	// it requires APIKeys
+	Usage *usage.Meter
+
+	// Metrics records request metrics; nil disables them
+	Metrics *metrics.Metrics
+
+	// Tracing wraps requests in OpenTelemetry spans
+	Tracing bool
+
+	// CORS sets the cross-origin policy; nil allows any origin. SetCORS
+	// changes it at runtime.
+	CORS *CORS
+
+	// AccessLog logs requests; nil disables access logging
+	AccessLog *AccessLog
+
+	// Precision coarsens lookup responses; nil serves full precision
+	Precision *precision.Policy
+
+	// Privacy anonymizes client addresses in logs; nil logs them as they are
+	Privacy *privacy.Anonymizer
+
+	// Version, Stores and Readiness feed /ready and /status
+	Version   string
+	Stores    map[string]StoreStatus
+	Readiness Readiness
+
+	// LoadShed bounds concurrent requests; nil disables it
+	LoadShed *loadshed.Limiter
+
+	// RateLimits enables rate limiting; nil disables it
+	RateLimits *RateLimits
+
+	// ProxyProtocol accepts HAProxy PROXY v1/v2 headers from ProxyProtocolTrusted peers
+	ProxyProtocol        bool
+	ProxyProtocolTrusted []*net.IPNet
+	ProxyProtocolUnix    bool // accept PROXY headers from unix socket peers
+
+	// TLSAddr enables an HTTPS listener next to the plain HTTP one
+	TLSAddr          string
+	TLS              *tls.Config
+	TLSProxyProtocol bool
 }
 
 func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
@@ -28,37 +108,47 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ip := net.ParseIP(ipStr)
+	if ip == nil && path == "/" && clientip.PeerIP(r) == nil {
+		// Unix socket peers have no address to look up.
+		s.respondError(w, "Client address unknown: the request came over a unix socket without a trusted forwarding header; query /{ip} instead", http.StatusBadRequest)
+		return
+	}
 	if ip == nil {
 		s.respondError(w, "Invalid IP address", http.StatusBadRequest)
 		return
 	}
 
 	resp, err := s.geoIP.LookupWithContext(r.Context(), ip.String())
+	if errors.Is(err, geoip.ErrNotLoaded) {
+		s.respondNotLoaded(w)
+		return
+	}
 	if err != nil {
-		s.log.Error().Err(err).Str("ip", ipStr).Msg("Lookup failed")
+		s.log.Error().Err(err).Str("ip", s.opts.Privacy.String(ipStr)).Msg("Lookup failed")
 		s.respondError(w, "IP lookup failed", http.StatusInternalServerError)
 		return
 	}
+
+	fields, denied := scopeFields(r, geoip.ParseFields(r.URL.Query().Get("fields")))
+	if denied != "" {
+		s.respondError(w, "API key is not permitted to read field "+denied, http.StatusForbidden)
+		return
+	}
+
+	resp = s.precisionFor(w, r).Apply(resp)
+	if len(fields) > 0 {
+		s.respondJSON(w, resp.Select(fields), http.StatusOK)
+		return
+	}
 	s.respondJSON(w, resp, http.StatusOK)
 }
 
 func (s *Server) extractClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header first
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		return strings.TrimSpace(ips[0])
-	}
-	
-	// Check CF-Connecting-IP header (Cloudflare)
-	if cfIP := r.Header.Get("CF-Connecting-IP"); cfIP != "" {
-		return cfIP
-	}
-	
-	// Check X-Real-IP header
-	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
-		return realIP
+	// Forwarding headers are only honoured when set by a trusted proxy
+	if ip := s.clientIP.ClientIP(r); ip != nil {
+		return ip.String()
 	}
-	
+
 	// Fall back to RemoteAddr
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
@@ -75,21 +165,74 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"ok"}`))
 }
 
-func NewServer(addr string, geoIP *geoip.GeoIP, logger zerolog.Logger) *Server {
+func NewServer(opts Options, geoIP *geoip.GeoIP, logger zerolog.Logger) *Server {
 	s := &Server{
-		geoIP: geoIP,
-		log:   logger,
+		geoIP:    geoIP,
+		access:   opts.Access,
+		apiKeys:  opts.APIKeys,
+		usage:    opts.Usage,
+		clientIP: opts.ClientIP,
+		opts:     opts,
+		started:  time.Now(),
+		log:      logger,
+	}
+	if s.clientIP == nil {
+		s.clientIP = clientip.NewResolver(nil, "")
+	}
+	if opts.CORS != nil {
+		s.SetCORS(*opts.CORS)
+	} else {
+		s.SetCORS(CORS{AllowedOrigins: []string{"*"}})
 	}
 
 	r := http.NewServeMux()
 	r.HandleFunc("/", s.handleRoot)
 	r.HandleFunc("/health", s.handleHealth)
+	r.HandleFunc("/live", s.handleLive)
+	r.HandleFunc("/ready", s.handleReady)
+	r.HandleFunc("/status", s.handleStatus)
+	r.HandleFunc("/batch", s.handleBatch)
+	r.HandleFunc("/chain", s.handleChain)
+	if s.access != nil {
+		r.HandleFunc("/auth", s.handleForwardAuth)
+	}
+	if s.usage != nil {
+		r.HandleFunc("/usage", s.handleUsage)
+	}
 	
 	// Apply minimal middleware for performance
-	handler := s.corsMiddleware(s.recoveryMiddleware(r))
+	var handler http.Handler = r
+	if s.usage != nil {
+		handler = s.usageMiddleware(handler)
+	}
+	if opts.RateLimits != nil {
+		s.limiter = ratelimit.New(opts.RateLimits.MaxEntries)
+		handler = s.keyRateLimitMiddleware(handler)
+	}
+	if s.apiKeys != nil {
+		handler = s.authMiddleware(handler)
+	}
+	if opts.RateLimits != nil {
+		handler = s.ipRateLimitMiddleware(handler)
+	}
+	handler = s.recoveryMiddleware(handler)
+	if opts.LoadShed != nil {
+		handler = s.loadShedMiddleware(handler)
+	}
+	handler = s.corsMiddleware(handler)
+	if opts.AccessLog != nil {
+		handler = s.accessLogMiddleware(handler)
+	}
+	handler = s.requestIDMiddleware(handler)
+	if opts.Tracing {
+		handler = s.tracingMiddleware(handler)
+	}
+	if opts.Metrics != nil {
+		handler = opts.Metrics.Middleware(routeFor)(handler)
+	}
 	
 	s.server = &http.Server{
-		Addr:              addr,
+		Addr:              opts.Addr,
 		Handler:           handler,
 		ReadTimeout:       5 * time.Second,
 		WriteTimeout:      5 * time.Second,
@@ -101,9 +244,47 @@ func NewServer(addr string, geoIP *geoip.GeoIP, logger zerolog.Logger) *Server {
 	return s
 }
 
+// Start serves HTTP, and HTTPS when configured, until Stop is called or a
+// listener fails. It returns the first error.
 func (s *Server) Start() error {
-	s.log.Info().Str("addr", s.server.Addr).Msg("Starting HTTP server")
-	return s.server.ListenAndServe()
+	errCh := make(chan error, 2)
+
+	go func() {
+		errCh <- s.serve(s.server.Addr, s.opts.ProxyProtocol, nil)
+	}()
+
+	if s.opts.TLSAddr != "" && s.opts.TLS != nil {
+		go func() {
+			errCh <- s.serve(s.opts.TLSAddr, s.opts.TLSProxyProtocol, s.opts.TLS)
+		}()
+	}
+
+	return <-errCh
+}
+
+func (s *Server) serve(addr string, proxyProtocol bool, tlsConfig *tls.Config) error {
+	s.log.Info().
+		Str("addr", addr).
+		Bool("tls", tlsConfig != nil).
+		Bool("proxyProtocol", proxyProtocol).
+		Msg("Starting HTTP server")
+
+	ln, err := listen.Listen(addr, s.opts.SocketMode)
+	if err != nil {
+		return err
+	}
+
+	if proxyProtocol {
+		pl := proxyproto.NewListener(ln, s.opts.ProxyProtocolTrusted, s.server.ReadHeaderTimeout)
+		pl.TrustUnixSockets = s.opts.ProxyProtocolUnix
+		ln = pl
+	}
+
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
+	return s.server.Serve(ln)
 }
 
 func (s *Server) Stop() error {
@@ -121,9 +302,27 @@ func (s *Server) respondError(w http.ResponseWriter, message string, statusCode
 	s.respondJSON(w, resp, statusCode)
 }
 
+// respondNotLoaded tells clients to come back once the GeoIP databases have
+// been loaded.
+func (s *Server) respondNotLoaded(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "30")
+	s.respondError(w, geoip.ErrNotLoaded.Error(), http.StatusServiceUnavailable)
+}
+
 func (s *Server) respondJSON(w http.ResponseWriter, data interface{}, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "public, max-age=300")
+	switch {
+	case statusCode >= 400:
+		// Errors such as 401, 403 and 429 depend on the caller and the moment
+		w.Header().Set("Cache-Control", "no-store")
+	case w.Header().Get("Cache-Control") != "":
+		// The handler chose its own caching policy
+	case s.apiKeys != nil:
+		// Shared caches must not hand authenticated responses to other callers
+		w.Header().Set("Cache-Control", "private, max-age=300")
+	default:
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
 	w.WriteHeader(statusCode)
 
 	if err := json.NewEncoder(w).Encode(data); err != nil {
@@ -131,42 +330,6 @@ func (s *Server) respondJSON(w http.ResponseWriter, data interface{}, statusCode
 	}
 }
 
-// Middleware functions
-func (s *Server) corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		
-		next.ServeHTTP(w, r)
-	})
-}
-
-func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-
-		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-		
-		next.ServeHTTP(wrapped, r)
-		
-		duration := time.Since(start)
-		s.log.Debug().
-			Str("method", r.Method).
-			Str("path", r.URL.Path).
-			Str("remote_addr", r.RemoteAddr).
-			Int("status", wrapped.statusCode).
-			Dur("duration", duration).
-			Str("user_agent", r.UserAgent()).
-			Msg("HTTP request")
-	})
-}
-
 func (s *Server) recoveryMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -174,6 +337,7 @@ func (s *Server) recoveryMiddleware(next http.Handler) http.Handler {
 				s.log.Error().
 					Interface("panic", err).
 					Str("path", r.URL.Path).
+					Str("request_id", RequestID(r.Context())).
 					Msg("Panic recovered")
 				
 				s.respondError(w, "Internal server error", http.StatusInternalServerError)
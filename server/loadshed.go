@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/geoip"
+)
+
+// loadShedMiddleware rejects requests with a fast 503 once the concurrency
+// limit is reached, instead of letting them queue until the timeouts hit.
+// Health and readiness checks are always served so orchestrators do not restart a busy
+// but working instance.
+func (s *Server) loadShedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := r.URL.Path; p == "/health" || p == "/live" || p == "/ready" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.opts.LoadShed.Acquire() {
+			w.Header().Set("Retry-After", "1")
+			s.respondError(w, "Server overloaded, retry later", http.StatusServiceUnavailable)
+			return
+		}
+
+		// The adaptive limiter reacts to lookup latency, not to the time
+		// spent on slow clients or in other middleware. Reuse the access
+		// log's stats when there are any, as inner stats would hide the
+		// lookups from it.
+		stats := geoip.LookupStatsFrom(r.Context())
+		if stats == nil {
+			var ctx context.Context
+			ctx, stats = geoip.WithLookupStats(r.Context())
+			r = r.WithContext(ctx)
+		}
+		before := *stats
+		defer func() {
+			s.opts.LoadShed.Release(lookupLatency(before, *stats))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// lookupLatency is the mean duration of the lookups made between two
+// snapshots of the same stats, or 0 when there were none.
+func lookupLatency(before, after geoip.LookupStats) time.Duration {
+	n := after.Lookups - before.Lookups
+	if n <= 0 {
+		return 0
+	}
+	return (after.Duration - before.Duration) / time.Duration(n)
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/andreybrigunet/IpContext/apikey"
+	"github.com/andreybrigunet/IpContext/precision"
+	"github.com/andreybrigunet/IpContext/proxy"
+)
+
+// PrecisionHeader reports the precision profile applied to a response.
+const PrecisionHeader = "X-Precision-Profile"
+
+// PrecisionRoutes are the routes whose responses precision profiles apply
+// to. "/auth" covers the X-Geo-* headers of forward auth responses, and
+// "proxy" the headers the geo reverse proxy adds.
+var PrecisionRoutes = []string{"/", "/{ip}", "/batch", "/auth", proxy.PrecisionRoute}
+
+// precisionFor selects the precision profile of a lookup request and
+// reports it in the response headers. It returns nil when precision
+// profiles are not configured.
+func (s *Server) precisionFor(w http.ResponseWriter, r *http.Request) *precision.Profile {
+	if s.opts.Precision == nil {
+		return nil
+	}
+
+	var keyProfile string
+	if key, ok := apikey.FromContext(r.Context()); ok {
+		keyProfile = key.Scopes.Precision
+	}
+
+	prof := s.opts.Precision.Select(keyProfile, routeFor(r))
+	w.Header().Set(PrecisionHeader, prof.Name)
+	return prof
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andreybrigunet/IpContext/apikey"
+	"github.com/andreybrigunet/IpContext/precision"
+)
+
+func TestPrecisionRoutes(t *testing.T) {
+	pol, err := precision.NewPolicy("", "full", map[string]string{"/batch": "country"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := newTestKeys(t, `{"keys": [
+		{"name": "coarse", "hash": "`+apikey.Hash("coarse")+`", "scopes": {"precision": "city"}},
+		{"name": "plain", "hash": "`+apikey.Hash("plain")+`"}
+	]}`)
+	h := newTestHandler(t, Options{Precision: pol, APIKeys: keys})
+
+	type location struct {
+		Query    string
+		Lat, Lon float64
+		City     string
+		Zip      string
+		Region   string
+	}
+	full := location{Query: "8.8.8.8", Lat: 37.4056, Lon: -122.0775, City: "Mountain View", Zip: "12345", Region: "CA"}
+
+	// The same address is looked up again and again, so later requests are
+	// served from the cache and must not see an earlier request's coarsening.
+	steps := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		key     string
+		profile string
+		want    location
+	}{
+		{name: "country route", method: http.MethodPost, target: "/batch", body: `["8.8.8.8"]`, profile: "country", want: location{Query: "8.8.8.8"}},
+		{name: "default route", target: "/8.8.8.8", profile: "full", want: full},
+		{name: "key profile", target: "/8.8.8.8", key: "coarse", profile: "city", want: location{Query: "8.8.8.8", Lat: 37.41, Lon: -122.08, City: "Mountain View", Zip: "12345", Region: "CA"}},
+		{name: "key without profile", method: http.MethodPost, target: "/batch", body: `["8.8.8.8"]`, key: "plain", profile: "country", want: location{Query: "8.8.8.8"}},
+		{name: "full again", target: "/8.8.8.8", key: "plain", profile: "full", want: full},
+	}
+
+	for _, st := range steps {
+		method := st.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		header := map[string]string{}
+		if st.key != "" {
+			header["X-API-Key"] = st.key
+		}
+
+		w := serve(h, method, st.target, st.body, header)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", st.name, w.Code, w.Body)
+		}
+		if got := w.Header().Get(PrecisionHeader); got != st.profile {
+			t.Errorf("%s: %s = %q, want %q", st.name, PrecisionHeader, got, st.profile)
+		}
+
+		body := w.Body.Bytes()
+		if strings.HasPrefix(st.target, "/batch") {
+			var list []json.RawMessage
+			if err := json.Unmarshal(body, &list); err != nil || len(list) != 1 {
+				t.Fatalf("%s: batch body = %s", st.name, body)
+			}
+			body = list[0]
+		}
+		var got location
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != st.want {
+			t.Errorf("%s: got %+v, want %+v", st.name, got, st.want)
+		}
+	}
+}
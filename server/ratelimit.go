@@ -0,0 +1,111 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/apikey"
+	"github.com/andreybrigunet/IpContext/ratelimit"
+)
+
+// RateLimits configures request rate limits. Every request counts against
+// the client IP and the network it belongs to; requests made with an API key
+// also count against the key.
+type RateLimits struct {
+	IP   ratelimit.Limit
+	CIDR ratelimit.Limit
+	Key  ratelimit.Limit // default for keys without their own rateLimit scope
+
+	IPv4Prefix int // size of the networks CIDR limits aggregate, e.g. 24
+	IPv6Prefix int // e.g. 48
+
+	MaxEntries int // upper bound on tracked buckets
+}
+
+// ipRateLimitMiddleware counts every request against the client IP and its
+// network. It runs before authentication, so clients cannot try keys
+// without limit.
+func (s *Server) ipRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if endpointFor(r.URL.Path) == "" || s.allow(w, s.ipBuckets(r)...) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// keyRateLimitMiddleware counts authenticated requests against their key.
+func (s *Server) keyRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := apikey.FromContext(r.Context())
+		if !ok || endpointFor(r.URL.Path) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := s.opts.RateLimits.Key
+		if own, ok := key.RateLimit(); ok {
+			limit = own
+		}
+		if s.allow(w, ratelimit.Bucket{Key: "key:" + key.Hash, Limit: limit}) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allow takes a token from buckets and sets the rate limit headers. It
+// responds 429 and returns false when a bucket is empty.
+func (s *Server) allow(w http.ResponseWriter, buckets ...ratelimit.Bucket) bool {
+	res := s.limiter.Allow(buckets...)
+	if res.Limit > 0 {
+		reset := strconv.Itoa(ceilSeconds(res.Reset))
+		remaining := strconv.Itoa(res.Remaining)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("RateLimit-Remaining", remaining)
+		w.Header().Set("RateLimit-Reset", reset)
+		// ip-api compatible names
+		w.Header().Set("X-Rl", remaining)
+		w.Header().Set("X-Ttl", reset)
+		w.Header().Set("Access-Control-Expose-Headers", "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, X-Rl, X-Ttl, Retry-After")
+	}
+
+	if !res.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(res.RetryAfter))))
+		s.respondError(w, "Too many requests", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// ipv6HostMask is the IPv6 prefix RATE_LIMIT_IP treats as one client.
+var ipv6HostMask = net.CIDRMask(64, 128)
+
+func (s *Server) ipBuckets(r *http.Request) []ratelimit.Bucket {
+	limits := s.opts.RateLimits
+
+	ipStr := s.extractClientIP(r)
+	ip := net.ParseIP(ipStr)
+	if ip != nil && ip.To4() == nil {
+		// A single IPv6 host is usually handed a whole /64 and can pick
+		// a new address for every request.
+		ipStr = (&net.IPNet{IP: ip.Mask(ipv6HostMask), Mask: ipv6HostMask}).String()
+	}
+	buckets := []ratelimit.Bucket{{Key: "ip:" + ipStr, Limit: limits.IP}}
+
+	if ip != nil && limits.CIDR.Enabled() {
+		bits, prefix := 128, limits.IPv6Prefix
+		if v4 := ip.To4(); v4 != nil {
+			ip, bits, prefix = v4, 32, limits.IPv4Prefix
+		}
+		network := &net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}
+		buckets = append(buckets, ratelimit.Bucket{Key: "net:" + network.String(), Limit: limits.CIDR})
+	}
+
+	return buckets
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andreybrigunet/IpContext/ratelimit"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	h := newTestHandler(t, Options{RateLimits: &RateLimits{IP: ratelimit.Limit{Rate: 0.5, Burst: 2}}})
+
+	steps := []struct {
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{status: http.StatusOK, remaining: "1", reset: "2"},
+		{status: http.StatusOK, remaining: "0", reset: "4"},
+		{status: http.StatusTooManyRequests, remaining: "0", reset: "4", retryAfter: "2"},
+	}
+
+	for i, st := range steps {
+		w := serve(h, http.MethodGet, "/8.8.8.8", "", nil)
+		got := w.Header()
+		if w.Code != st.status || got.Get("RateLimit-Limit") != "2" || got.Get("RateLimit-Remaining") != st.remaining ||
+			got.Get("X-Rl") != st.remaining || got.Get("RateLimit-Reset") != st.reset || got.Get("X-Ttl") != st.reset ||
+			got.Get("Retry-After") != st.retryAfter {
+			t.Errorf("request %d: %d with %v, want %d, remaining %s, reset %s, Retry-After %q",
+				i, w.Code, got, st.status, st.remaining, st.reset, st.retryAfter)
+		}
+	}
+
+	// Unlimited endpoints carry no rate limit headers.
+	if w := serve(h, http.MethodGet, "/health", "", nil); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("/health: %d with %v", w.Code, w.Header())
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	h := newTestHandler(t, Options{RateLimits: &RateLimits{
+		IP:         ratelimit.Limit{Rate: 1, Burst: 1},
+		CIDR:       ratelimit.Limit{Rate: 1, Burst: 3},
+		IPv4Prefix: 24,
+		IPv6Prefix: 48,
+	}})
+
+	get := func(remote string) int {
+		r := httptest.NewRequest(http.MethodGet, "/8.8.8.8", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	steps := []struct {
+		remote string
+		status int
+	}{
+		{remote: "[2001:db8:1:1::1]:1000", status: http.StatusOK},
+		{remote: "[2001:db8:1:1::2]:1000", status: http.StatusTooManyRequests}, // same /64
+		{remote: "[2001:db8:1:2::1]:1000", status: http.StatusOK},              // same /48
+		{remote: "203.0.113.1:1000", status: http.StatusOK},
+		{remote: "203.0.113.2:1000", status: http.StatusOK},
+		{remote: "[2001:db8:1:3::1]:1000", status: http.StatusOK},
+		{remote: "[2001:db8:1:4::1]:1000", status: http.StatusTooManyRequests}, // /48 is empty
+	}
+
+	for _, st := range steps {
+		if got := get(st.remote); got != st.status {
+			t.Errorf("request from %s: status %d, want %d", st.remote, got, st.status)
+		}
+	}
+}
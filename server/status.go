@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// StoreStatus is implemented by the GeoNames stores.
+type StoreStatus interface {
+	Coverage() (loaded, total int)
+	RefreshStats() (succeeded, failed uint64, lastSuccess time.Time)
+	LastRefresh() (finished time.Time, succeeded, failed int)
+}
+
+// Readiness sets the criteria /ready checks beyond the databases being loaded.
+type Readiness struct {
+	// MaxDatabaseAge fails readiness once a database build is older; 0
+	// disables the check.
+	MaxDatabaseAge time.Duration
+
+	// MinStoreCoverage is the fraction of countries, from 0 to 1, each
+	// GeoNames store must have loaded; 0 disables the check.
+	MinStoreCoverage float64
+}
+
+func (s *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	s.respondJSON(w, map[string]string{"status": "ok"}, http.StatusOK)
+}
+
+type readyResponse struct {
+	Status string            `json:"status"` // ready or not ready
+	Checks map[string]string `json:"checks"` // "ok" or the reason a check failed
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	checks, ready := s.readiness()
+
+	resp := readyResponse{Status: "ready", Checks: checks}
+	code := http.StatusOK
+	if !ready {
+		resp.Status = "not ready"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.respondJSON(w, resp, code)
+}
+
+// readiness evaluates every check, so that /ready reports all failures.
+func (s *Server) readiness() (map[string]string, bool) {
+	checks := make(map[string]string)
+	ready := true
+	fail := func(name, format string, args ...interface{}) {
+		checks[name] = fmt.Sprintf(format, args...)
+		ready = false
+	}
+
+	if !s.geoIP.Loaded() {
+		fail("databases", "not loaded yet")
+	}
+	dbs := s.geoIP.Databases()
+	maxAge := s.opts.Readiness.MaxDatabaseAge
+	for _, db := range dbs {
+		if age := time.Since(db.BuildTime); maxAge > 0 && age > maxAge {
+			fail(db.Edition, "built %s ago, more than %s", age.Round(time.Hour), maxAge)
+		} else {
+			checks[db.Edition] = "ok"
+		}
+	}
+
+	minCoverage := s.opts.Readiness.MinStoreCoverage
+	for name, st := range s.opts.Stores {
+		loaded, total := st.Coverage()
+		if minCoverage > 0 && total > 0 && float64(loaded)/float64(total) < minCoverage {
+			fail(name, "%d of %d countries loaded, below %.0f%%", loaded, total, minCoverage*100)
+		} else {
+			checks[name] = "ok"
+		}
+	}
+
+	return checks, ready
+}
+
+type statusResponse struct {
+	Version       string                 `json:"version"`
+	StartedAt     time.Time              `json:"startedAt"`
+	UptimeSeconds int64                  `json:"uptimeSeconds"`
+	Ready         bool                   `json:"ready"`
+	Databases     []databaseStatus       `json:"databases"`
+	Stores        map[string]storeStatus `json:"stores,omitempty"`
+	Cache         cacheStatus            `json:"cache"`
+}
+
+type databaseStatus struct {
+	Edition    string    `json:"edition"`
+	BuildTime  time.Time `json:"buildTime"`
+	BuildEpoch int64     `json:"buildEpoch"`
+	AgeSeconds int64     `json:"ageSeconds"`
+}
+
+type storeStatus struct {
+	Loaded      int            `json:"loaded"`
+	Total       int            `json:"total"`
+	LastSuccess *time.Time     `json:"lastSuccess,omitempty"`
+	LastRefresh *refreshStatus `json:"lastRefresh,omitempty"`
+}
+
+type refreshStatus struct {
+	Finished  time.Time `json:"finished"`
+	Succeeded int       `json:"succeeded"`
+	Failed    int       `json:"failed"`
+}
+
+type cacheStatus struct {
+	Items     int    `json:"items"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	_, ready := s.readiness()
+
+	resp := statusResponse{
+		Version:       s.opts.Version,
+		StartedAt:     s.started.UTC(),
+		UptimeSeconds: int64(now.Sub(s.started).Seconds()),
+		Ready:         ready,
+		Databases:     []databaseStatus{},
+	}
+
+	for _, db := range s.geoIP.Databases() {
+		resp.Databases = append(resp.Databases, databaseStatus{
+			Edition:    db.Edition,
+			BuildTime:  db.BuildTime,
+			BuildEpoch: db.BuildTime.Unix(),
+			AgeSeconds: int64(now.Sub(db.BuildTime).Seconds()),
+		})
+	}
+	sort.Slice(resp.Databases, func(i, j int) bool { return resp.Databases[i].Edition < resp.Databases[j].Edition })
+
+	if len(s.opts.Stores) > 0 {
+		resp.Stores = make(map[string]storeStatus, len(s.opts.Stores))
+	}
+	for name, st := range s.opts.Stores {
+		var ss storeStatus
+		ss.Loaded, ss.Total = st.Coverage()
+		if _, _, last := st.RefreshStats(); !last.IsZero() {
+			last = last.UTC()
+			ss.LastSuccess = &last
+		}
+		if finished, ok, failed := st.LastRefresh(); !finished.IsZero() {
+			ss.LastRefresh = &refreshStatus{Finished: finished.UTC(), Succeeded: ok, Failed: failed}
+		}
+		resp.Stores[name] = ss
+	}
+
+	cs := s.geoIP.CacheStats()
+	resp.Cache = cacheStatus{Items: cs.Size, Hits: cs.Hits, Misses: cs.Misses, Evictions: cs.Evictions}
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.respondJSON(w, resp, http.StatusOK)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andreybrigunet/IpContext/apikey"
+	"github.com/andreybrigunet/IpContext/usage"
+)
+
+type usageChargeKey struct{}
+
+// usageCharge is the quota reserved for a request and the lookups it served.
+type usageCharge struct {
+	key      *apikey.Key
+	reserved int
+	cost     int
+}
+
+// meteredEndpoint names the counter a path is recorded under, or "" when the
+// path is not metered.
+func meteredEndpoint(path string) string {
+	switch endpointFor(path) {
+	case apikey.EndpointBatch:
+		return "batch"
+	case apikey.EndpointSingle:
+		if path == "/chain" {
+			return "chain"
+		}
+		return "single"
+	}
+	return ""
+}
+
+// usageMiddleware enforces monthly quotas and counts successful lookups made
+// with an API key. One lookup is reserved against the quota up front and
+// refunded if the request fails. Handlers that serve more than one lookup
+// per request reserve them with reserveUsage and report their count with
+// setUsageCost.
+func (s *Server) usageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := meteredEndpoint(r.URL.Path)
+		key, ok := apikey.FromContext(r.Context())
+		if endpoint == "" || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		charge := &usageCharge{key: key, cost: 1}
+		if !s.reserve(w, charge, 1) {
+			return
+		}
+
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrapped, r.WithContext(context.WithValue(r.Context(), usageChargeKey{}, charge)))
+
+		used := charge.cost
+		if wrapped.statusCode >= 400 {
+			used = 0
+		}
+		s.usage.Commit(key.Name, endpoint, charge.reserved, used)
+	})
+}
+
+// setUsageCost sets the number of lookups the request is billed for, at
+// most what was reserved.
+func setUsageCost(r *http.Request, n int) {
+	if charge, ok := r.Context().Value(usageChargeKey{}).(*usageCharge); ok {
+		charge.cost = min(n, charge.reserved)
+	}
+}
+
+// reserveUsage reserves quota for n lookups in total for the request. It
+// responds with 429 and returns false when that would take the key over its
+// monthly quota.
+func (s *Server) reserveUsage(w http.ResponseWriter, r *http.Request, n int) bool {
+	charge, ok := r.Context().Value(usageChargeKey{}).(*usageCharge)
+	if !ok || n <= charge.reserved {
+		return true
+	}
+	return s.reserve(w, charge, n-charge.reserved)
+}
+
+func (s *Server) reserve(w http.ResponseWriter, charge *usageCharge, n int) bool {
+	if s.usage.Reserve(charge.key.Name, n, charge.key.Scopes.MonthlyQuota) {
+		charge.reserved += n
+		return true
+	}
+
+	retry := time.Until(s.usage.MonthReset())
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(retry))))
+	s.respondError(w, "Monthly quota exceeded", http.StatusTooManyRequests)
+	return false
+}
+
+type usageMonth struct {
+	Used      uint64 `json:"used"`
+	Quota     uint64 `json:"quota,omitempty"`
+	Remaining uint64 `json:"remaining,omitempty"`
+	Reset     string `json:"reset"`
+}
+
+type usageResponse struct {
+	Status string      `json:"status"`
+	Key    string      `json:"key"`
+	From   string      `json:"from"`
+	To     string      `json:"to"`
+	Month  usageMonth  `json:"month"`
+	Days   []usage.Day `json:"days"`
+}
+
+// handleUsage reports the caller's own usage:
+// GET /usage?from=2024-05-01&to=2024-05-31 (defaults to the current month).
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	key, ok := apikey.FromContext(r.Context())
+	if !ok {
+		s.respondUnauthorized(w, "API key required")
+		return
+	}
+
+	from, to, err := usage.ParseRange(r.URL.Query())
+	if err != nil {
+		s.respondError(w, "Invalid usage range: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	days, err := s.usage.Report(key.Name, from, to)
+	if err != nil {
+		s.log.Error().Err(err).Msg("Usage report failed")
+		s.respondError(w, "Usage report failed", http.StatusInternalServerError)
+		return
+	}
+
+	month := usageMonth{
+		Used:  s.usage.MonthUsed(key.Name),
+		Quota: key.Scopes.MonthlyQuota,
+		Reset: s.usage.MonthReset().Format(time.RFC3339),
+	}
+	if month.Quota > month.Used {
+		month.Remaining = month.Quota - month.Used
+	}
+	if days == nil {
+		days = []usage.Day{}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.respondJSON(w, usageResponse{Status: "success", Key: key.Name, From: from, To: to, Month: month, Days: days}, http.StatusOK)
+}
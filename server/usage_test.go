@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreybrigunet/IpContext/apikey"
+	"github.com/andreybrigunet/IpContext/clientip"
+	"github.com/andreybrigunet/IpContext/usage"
+	"github.com/rs/zerolog"
+)
+
+func TestUsageMetering(t *testing.T) {
+	keys := newTestKeys(t, `{"keys": [{"name": "q", "hash": "`+apikey.Hash("q")+`", "scopes": {"monthlyQuota": 6}}]}`)
+	meter, err := usage.Open(filepath.Join(t.TempDir(), "usage.db"), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer meter.Close()
+
+	trusted, _ := clientip.ParseCIDRs([]string{"192.0.2.0/24"})
+	h := newTestHandler(t, Options{
+		APIKeys:  keys,
+		Usage:    meter,
+		ClientIP: clientip.NewResolver(trusted, clientip.HeaderXForwardedFor),
+	})
+
+	steps := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		header map[string]string
+		status int
+		used   uint64 // month total afterwards
+	}{
+		{name: "single", target: "/8.8.8.8", status: http.StatusOK, used: 1},
+		{name: "failed single is refunded", target: "/not-an-ip", status: http.StatusBadRequest, used: 1},
+		{name: "batch counts resolved queries", method: http.MethodPost, target: "/batch", body: `["1.1.1.1", "bad"]`, status: http.StatusOK, used: 2},
+		// Two public hops and the peer are looked up, the private hop is not.
+		{name: "chain counts geolocated hops", target: "/chain", header: map[string]string{"X-Forwarded-For": "8.8.8.8, 10.0.0.1, 91.198.174.1"}, status: http.StatusOK, used: 5},
+		{name: "chain over quota", target: "/chain", header: map[string]string{"X-Forwarded-For": "8.8.8.8, 1.1.1.1"}, status: http.StatusTooManyRequests, used: 5},
+		{name: "single within quota", target: "/8.8.8.8", status: http.StatusOK, used: 6},
+	}
+
+	for _, st := range steps {
+		header := map[string]string{"X-API-Key": "q"}
+		for k, v := range st.header {
+			header[k] = v
+		}
+		method := st.method
+		if method == "" {
+			method = http.MethodGet
+		}
+
+		w := serve(h, method, st.target, st.body, header)
+		if w.Code != st.status {
+			t.Fatalf("%s: status = %d, want %d: %s", st.name, w.Code, st.status, w.Body)
+		}
+		if st.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: 429 without Retry-After", st.name)
+		}
+		if got := meter.MonthUsed("q"); got != st.used {
+			t.Fatalf("%s: month usage = %d, want %d", st.name, got, st.used)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package tlsx builds server TLS configurations whose certificate and client
+// CA bundle are reloaded when the files change on disk.
+package tlsx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Options describes the TLS inputs.
+type Options struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // enables client certificate verification when set
+	ClientAuth   string // require | verify-if-given
+	MinVersion   string // 1.2 | 1.3
+}
+
+// Reloader serves the most recently loaded certificate and client CA pool.
+type Reloader struct {
+	opts Options
+	log  zerolog.Logger
+
+	cert atomic.Pointer[tls.Certificate]
+	cas  atomic.Pointer[x509.CertPool]
+
+	modTimes map[string]time.Time
+}
+
+// New loads the certificate, key and optional CA bundle.
+func New(opts Options, logger zerolog.Logger) (*Reloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("tlsx: certificate and key files are required")
+	}
+
+	r := &Reloader{opts: opts, log: logger, modTimes: make(map[string]time.Time)}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Config returns a tls.Config that always uses the current certificate and CA pool.
+func (r *Reloader) Config() (*tls.Config, error) {
+	minVersion, err := ParseVersion(r.opts.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth := tls.NoClientCert
+	if r.opts.ClientCAFile != "" {
+		switch strings.ToLower(r.opts.ClientAuth) {
+		case "", "require":
+			clientAuth = tls.RequireAndVerifyClientCert
+		case "verify-if-given":
+			clientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("tlsx: invalid client auth mode %q", r.opts.ClientAuth)
+		}
+	}
+
+	base := &tls.Config{
+		MinVersion: minVersion,
+		NextProtos: []string{"h2", "http/1.1"},
+		ClientAuth: clientAuth,
+	}
+
+	// Resolve per handshake so reloaded files apply to new connections.
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.Certificates = []tls.Certificate{*r.cert.Load()}
+		cfg.ClientCAs = r.cas.Load()
+		return cfg, nil
+	}
+
+	return base, nil
+}
+
+// Start checks the files for changes every interval until ctx is cancelled.
+func (r *Reloader) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !r.changed() {
+					continue
+				}
+				if err := r.load(); err != nil {
+					// Keep serving the previous certificate until the files are fixed.
+					r.log.Error().Err(err).Msg("Failed to reload TLS certificate")
+				}
+			}
+		}
+	}()
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	return files
+}
+
+func (r *Reloader) changed() bool {
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if !fi.ModTime().Equal(r.modTimes[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) load() error {
+	for _, f := range r.files() {
+		if fi, err := os.Stat(f); err == nil {
+			r.modTimes[f] = fi.ModTime()
+		}
+	}
+
+	cert, pool, err := loadFiles(r.opts)
+	if err != nil {
+		return err
+	}
+
+	r.cert.Store(&cert)
+	r.cas.Store(pool)
+
+	evt := r.log.Info().Str("cert", r.opts.CertFile)
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		evt = evt.Str("subject", leaf.Subject.CommonName).Time("notAfter", leaf.NotAfter)
+	}
+	evt.Bool("mTLS", pool != nil).Msg("TLS certificate loaded")
+
+	return nil
+}
+
+// Check loads the files of opts without keeping them, to report errors
+// before New.
+func Check(opts Options) error {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return errors.New("tlsx: certificate and key files are required")
+	}
+	_, _, err := loadFiles(opts)
+	return err
+}
+
+func loadFiles(opts Options) (tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("tlsx: load key pair: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return tls.Certificate{}, nil, fmt.Errorf("tlsx: read client CA bundle: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return tls.Certificate{}, nil, fmt.Errorf("tlsx: no certificates found in %s", opts.ClientCAFile)
+		}
+	}
+	return cert, pool, nil
+}
+
+// ParseVersion maps "1.2"/"1.3" to tls version constants; empty means 1.2.
+func ParseVersion(v string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(v), "tls") {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	}
+	return 0, fmt.Errorf("tlsx: unsupported TLS version %q", v)
+}
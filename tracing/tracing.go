@@ -0,0 +1,117 @@
+// Package tracing configures OpenTelemetry tracing. Other packages create
+// spans through the global tracer provider, which stays a no-op until Setup
+// installs an exporter.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Options selects and configures the span exporter.
+type Options struct {
+	Exporter    string  // otlp | stdout | none
+	Endpoint    string  // OTLP/HTTP endpoint, e.g. http://collector:4318
+	ServiceName string  // service.name resource attribute
+	Version     string  // service.version resource attribute
+	SampleRatio float64 // fraction of new traces to sample; parents' decisions are kept
+}
+
+// Setup installs a tracer provider and the W3C trace context propagator.
+// The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	// Always accept and forward traceparent, even when not exporting, so
+	// IpContext does not break traces passing through it.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch strings.ToLower(opts.Exporter) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case "otlp":
+		exporter, err = newOTLPExporter(ctx, opts.Endpoint)
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q (expected otlp, stdout or none)", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(opts.ServiceName),
+		semconv.ServiceVersion(opts.Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	ratio := opts.SampleRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = 1
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// newOTLPExporter exports over OTLP/HTTP. Without an endpoint the standard
+// OTEL_EXPORTER_OTLP_* environment variables apply.
+func newOTLPExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+	var opts []otlptracehttp.Option
+	if endpoint != "" {
+		if err := CheckEndpoint(endpoint); err != nil {
+			return nil, err
+		}
+		u, _ := url.Parse(endpoint)
+		opts = append(opts, otlptracehttp.WithEndpoint(u.Host))
+		if u.Scheme == "http" {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if u.Path != "" && u.Path != "/" {
+			opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+		}
+	}
+	return otlptracehttp.New(ctx, opts...)
+}
+
+// CheckEndpoint reports an OTLP endpoint that is not a URL with a host.
+func CheckEndpoint(endpoint string) error {
+	if u, err := url.Parse(endpoint); err != nil || u.Host == "" {
+		return fmt.Errorf("invalid OTLP endpoint %q, expected a URL such as http://collector:4318", endpoint)
+	}
+	return nil
+}
+
+// EndSpan records err, if any, and ends the span.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,298 @@
+// Package usage counts lookups per API key, day and endpoint, and persists
+// the counters in an embedded bbolt database so they survive restarts.
+package usage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DateLayout is the format of days in counters and reports (UTC).
+const DateLayout = "2006-01-02"
+
+var bucketName = []byte("usage")
+
+// counter identifies one persisted count. Stored as "key|day|endpoint".
+type counter struct {
+	key      string
+	day      string
+	endpoint string
+}
+
+func (c counter) bytes() []byte {
+	return []byte(c.key + "|" + c.day + "|" + c.endpoint)
+}
+
+func parseCounter(b []byte) (counter, bool) {
+	parts := strings.Split(string(b), "|")
+	if len(parts) != 3 {
+		return counter{}, false
+	}
+	return counter{key: parts[0], day: parts[1], endpoint: parts[2]}, true
+}
+
+// Day is the usage of one key on one day.
+type Day struct {
+	Date      string            `json:"date"`
+	Total     uint64            `json:"total"`
+	Endpoints map[string]uint64 `json:"endpoints"`
+}
+
+// Meter accumulates counts in memory and writes them to disk periodically.
+type Meter struct {
+	db  *bolt.DB
+	log zerolog.Logger
+	now func() time.Time
+
+	mu      sync.Mutex
+	pending map[counter]uint64
+	month   string            // month the totals below belong to, "2006-01"
+	totals  map[string]uint64 // lookups per key in the current month
+}
+
+// Open opens or creates the database at path.
+func Open(path string, logger zerolog.Logger) (*Meter, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("usage: open %s: %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("usage: %w", err)
+	}
+
+	m := &Meter{
+		db:      db,
+		log:     logger,
+		now:     func() time.Time { return time.Now().UTC() },
+		pending: make(map[counter]uint64),
+	}
+	if err := m.loadMonth(m.now().Format("2006-01")); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// loadMonth rebuilds the per-key totals for month from disk.
+func (m *Meter) loadMonth(month string) error {
+	totals := make(map[string]uint64)
+	err := m.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).ForEach(func(k, v []byte) error {
+			if c, ok := parseCounter(k); ok && strings.HasPrefix(c.day, month) {
+				totals[c.key] += binary.BigEndian.Uint64(v)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("usage: load totals: %w", err)
+	}
+
+	m.month, m.totals = month, totals
+	return nil
+}
+
+// Reserve counts n lookups by key against the current month if the month's
+// total stays within quota, 0 meaning no quota, and reports whether it did.
+// Checking and counting happen together, so concurrent requests cannot all
+// pass the check. Every reservation is settled with Commit.
+func (m *Meter) Reserve(key string, n int, quota uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollMonth(m.now())
+	if quota > 0 && m.totals[key]+uint64(n) > quota {
+		return false
+	}
+	m.totals[key] += uint64(n)
+	return true
+}
+
+// Commit records used lookups by key on endpoint for a reservation of
+// reserved lookups and refunds the rest, e.g. all of it for a failed
+// request.
+func (m *Meter) Commit(key, endpoint string, reserved, used int) {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollMonth(now)
+	if used > 0 {
+		m.pending[counter{key: key, day: now.Format(DateLayout), endpoint: endpoint}] += uint64(used)
+	}
+	// A reservation made before the month rolled over went with the old
+	// totals, so the total may hold less than it.
+	total := m.totals[key] + uint64(max(used, 0))
+	m.totals[key] = total - min(total, uint64(max(reserved, 0)))
+}
+
+// MonthUsed returns the lookups made by key in the current calendar month (UTC).
+func (m *Meter) MonthUsed(key string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollMonth(m.now())
+	return m.totals[key]
+}
+
+// MonthReset returns when the current quota period ends.
+func (m *Meter) MonthReset() time.Time {
+	now := m.now()
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (m *Meter) rollMonth(now time.Time) {
+	if month := now.Format("2006-01"); month != m.month {
+		m.month, m.totals = month, make(map[string]uint64)
+	}
+}
+
+// Start flushes pending counts every interval until ctx is cancelled.
+func (m *Meter) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.Flush(); err != nil {
+					m.log.Error().Err(err).Msg("Failed to persist usage counters")
+				}
+			}
+		}
+	}()
+}
+
+// Flush writes pending counts to disk. Counts that could not be written are
+// kept for the next attempt.
+func (m *Meter) Flush() error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[counter]uint64)
+	m.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		for c, n := range pending {
+			k := c.bytes()
+			var total uint64
+			if v := b.Get(k); len(v) == 8 {
+				total = binary.BigEndian.Uint64(v)
+			}
+			v := make([]byte, 8)
+			binary.BigEndian.PutUint64(v, total+n)
+			if err := b.Put(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		m.mu.Lock()
+		for c, n := range pending {
+			m.pending[c] += n
+		}
+		m.mu.Unlock()
+		return fmt.Errorf("usage: flush: %w", err)
+	}
+
+	return nil
+}
+
+// Close flushes pending counts and closes the database.
+func (m *Meter) Close() error {
+	return errors.Join(m.Flush(), m.db.Close())
+}
+
+// Report returns the daily usage of key between from and to inclusive
+// (dates in DateLayout), oldest first.
+func (m *Meter) Report(key, from, to string) ([]Day, error) {
+	all, err := m.report(key, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return all[key], nil
+}
+
+// ReportAll returns the daily usage of every key between from and to.
+func (m *Meter) ReportAll(from, to string) (map[string][]Day, error) {
+	return m.report("", from, to)
+}
+
+func (m *Meter) report(key, from, to string) (map[string][]Day, error) {
+	if err := m.Flush(); err != nil {
+		return nil, err
+	}
+
+	days := make(map[string]map[string]*Day)
+	err := m.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketName).Cursor()
+
+		var prefix []byte
+		if key != "" {
+			prefix = []byte(key + "|")
+		}
+
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			ct, ok := parseCounter(k)
+			if !ok || ct.day < from || ct.day > to {
+				continue
+			}
+
+			if days[ct.key] == nil {
+				days[ct.key] = make(map[string]*Day)
+			}
+			d := days[ct.key][ct.day]
+			if d == nil {
+				d = &Day{Date: ct.day, Endpoints: make(map[string]uint64)}
+				days[ct.key][ct.day] = d
+			}
+			n := binary.BigEndian.Uint64(v)
+			d.Endpoints[ct.endpoint] += n
+			d.Total += n
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("usage: report: %w", err)
+	}
+
+	out := make(map[string][]Day, len(days))
+	for k, byDay := range days {
+		list := make([]Day, 0, len(byDay))
+		for _, d := range byDay {
+			list = append(list, *d)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Date < list[j].Date })
+		out[k] = list
+	}
+	return out, nil
+}
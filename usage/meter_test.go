@@ -0,0 +1,134 @@
+package usage
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func openMeter(t *testing.T, path string, now time.Time) *Meter {
+	t.Helper()
+	m, err := Open(path, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	m.now = func() time.Time { return now }
+	// Open loaded the totals of the real month.
+	if err := m.loadMonth(now.Format("2006-01")); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestQuotaAndPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.db")
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	m := openMeter(t, path, now)
+
+	const quota = 5
+	if !m.Reserve("a", 3, quota) {
+		t.Fatal("first reservation rejected")
+	}
+	if !m.Reserve("a", 2, quota) {
+		t.Fatal("reservation up to the quota rejected")
+	}
+	if m.Reserve("a", 1, quota) {
+		t.Fatal("reservation past the quota accepted")
+	}
+	if !m.Reserve("b", 1, quota) {
+		t.Fatal("quota of one key applied to another")
+	}
+
+	// The batch of 3 resolved 2 lookups, the single request failed.
+	m.Commit("a", "batch", 3, 2)
+	m.Commit("a", "single", 2, 0)
+	m.Commit("b", "single", 1, 1)
+	if got := m.MonthUsed("a"); got != 2 {
+		t.Errorf("MonthUsed(a) = %d after refunds, want 2", got)
+	}
+	if !m.Reserve("a", 3, quota) {
+		t.Fatal("refunded lookups still counted against the quota")
+	}
+	m.Commit("a", "single", 3, 3)
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	m = openMeter(t, path, now.Add(24*time.Hour))
+	defer m.Close()
+
+	if got := m.MonthUsed("a"); got != 5 {
+		t.Errorf("MonthUsed(a) after reopening = %d, want 5", got)
+	}
+	if m.Reserve("a", 1, quota) {
+		t.Error("quota not enforced after reopening")
+	}
+
+	days, err := m.Report("a", "2024-05-01", "2024-05-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 1 || days[0].Date != "2024-05-02" || days[0].Total != 5 ||
+		days[0].Endpoints["batch"] != 2 || days[0].Endpoints["single"] != 3 {
+		t.Errorf("Report(a) = %+v, want 2 batch and 3 single lookups on 2024-05-02", days)
+	}
+
+	all, err := m.ReportAll("2024-05-01", "2024-05-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || len(all["b"]) != 1 || all["b"][0].Total != 1 {
+		t.Errorf("ReportAll() = %+v, want keys a and b", all)
+	}
+}
+
+func TestMonthRollover(t *testing.T) {
+	now := time.Date(2024, 5, 31, 23, 0, 0, 0, time.UTC)
+	m := openMeter(t, filepath.Join(t.TempDir(), "usage.db"), now)
+	defer m.Close()
+
+	if !m.Reserve("a", 2, 2) {
+		t.Fatal("reservation rejected")
+	}
+
+	// The request ends in the next month: its lookups count there, and
+	// the refund must not wrap the new month's total.
+	m.now = func() time.Time { return now.Add(2 * time.Hour) }
+	m.Commit("a", "single", 2, 1)
+	if got := m.MonthUsed("a"); got != 0 {
+		t.Errorf("MonthUsed(a) = %d after rollover, want 0", got)
+	}
+	if !m.Reserve("a", 2, 2) {
+		t.Error("new month started with the old month's usage")
+	}
+}
+
+func TestReserveConcurrent(t *testing.T) {
+	m := openMeter(t, filepath.Join(t.TempDir(), "usage.db"), time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC))
+	defer m.Close()
+
+	const quota = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if m.Reserve("a", 1, quota) {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != quota {
+		t.Errorf("%d reservations accepted, want %d", accepted, quota)
+	}
+}
@@ -0,0 +1,32 @@
+package usage
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// ParseRange reads the from and to query parameters of a usage report
+// (DateLayout). They default to the first day of the current month and
+// today (UTC).
+func ParseRange(q url.Values) (from, to string, err error) {
+	now := time.Now().UTC()
+	from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).Format(DateLayout)
+	to = now.Format(DateLayout)
+
+	for _, p := range []struct {
+		name string
+		dst  *string
+	}{{"from", &from}, {"to", &to}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		if _, err := time.Parse(DateLayout, v); err != nil {
+			return "", "", fmt.Errorf("%s must be a YYYY-MM-DD date", p.name)
+		}
+		*p.dst = v
+	}
+
+	return from, to, nil
+}
@@ -0,0 +1,41 @@
+package usage
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	now := time.Now().UTC()
+	monthStart := now.Format("2006-01") + "-01"
+	today := now.Format(DateLayout)
+
+	tests := []struct {
+		query    string
+		from, to string
+		wantErr  string
+	}{
+		{query: "", from: monthStart, to: today},
+		{query: "from=2024-05-01", from: "2024-05-01", to: today},
+		{query: "from=2024-05-01&to=2024-05-31", from: "2024-05-01", to: "2024-05-31"},
+		{query: "to=2024-05-31", from: monthStart, to: "2024-05-31"},
+		{query: "from=May", wantErr: "from"},
+		{query: "from=2024-05-01&to=2024-13-01", wantErr: "to"},
+	}
+
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		from, to, err := ParseRange(q)
+		if tt.wantErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("ParseRange(%q) error = %v, want one about %s", tt.query, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || from != tt.from || to != tt.to {
+			t.Errorf("ParseRange(%q) = %s, %s, %v, want %s, %s", tt.query, from, to, err, tt.from, tt.to)
+		}
+	}
+}